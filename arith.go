@@ -0,0 +1,255 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+// 运算异常策略(Arithmetic Fault Policy)
+//
+// 除数为0、取余除数为0、整数运算的操作数不是整数，以及写入数据的值为NaN、Inf时，按引擎的异常策略处理：
+// 保留运算结果、取左操作数（写入数据时为原值）、取默认值、限定为有限值或抛出*ArithError；
+// 每个仓库分别统计各类异常的次数。*ArithError由EvalFrml、EvalCond、TryExecOper、TryExecProc返回，
+// 已执行的运算不回滚；同步监听回调、条件监听回调及Get、Set函数中的*ArithError不记录日志，继续向外抛出，
+// 异步监听的回调在队列协程中执行，其*ArithError与其它异常一样记录日志
+
+import (
+	"fmt"
+	"math"
+)
+
+type ArithFault uint
+
+const (
+	// 公式的除数为0（/、Div）
+	AF_DIV_ZERO ArithFault = iota
+	// 取余的除数截断为整数后为0
+	AF_MOD_ZERO
+	// 整数运算（&、|、~、<<、>>、Div、&=、|=）的操作数不是int64范围内的整数
+	AF_NOT_INT
+	// 写入数据的值为NaN
+	AF_NAN
+	// 写入数据的值为Inf
+	AF_INF
+	// 写入数据的除数为0（/=）
+	AF_OPER_DIV_ZERO
+	afCount
+)
+
+var faultCodes = [afCount]string{"fault_div_zero", "fault_mod_zero", "fault_not_int", "fault_nan", "fault_inf", "fault_oper_div_zero"}
+
+func (this ArithFault) String() string {
+	if this < afCount {
+		return Message(faultCodes[this])
+	}
+	return fmt.Sprintf("ArithFault(%d)", uint(this))
+}
+
+type FaultAction uint
+
+const (
+	// 保留运算结果
+	FA_IGNORE FaultAction = iota
+	// 取左操作数，写入数据时为数据原值
+	FA_LEFT
+	// 取策略的默认值
+	FA_DEFAULT
+	// 限定为有限值：±Inf及除数为0按符号取±MaxFloat64，其余取默认值；写入数据时再按数据上下限限定
+	FA_CLAMP
+	// 抛出*ArithError
+	FA_ERROR
+)
+
+type ArithPolicy struct {
+	Action FaultAction
+	// FA_DEFAULT、FA_CLAMP的默认值
+	Value float64
+}
+
+// 默认策略与原有行为一致：公式除数为0取左操作数，写入数据时除数为0结果为0，整数运算失败为0，NaN、Inf保留
+var arithPolicies = [afCount]ArithPolicy{
+	AF_DIV_ZERO:      {Action: FA_LEFT},
+	AF_MOD_ZERO:      {Action: FA_LEFT},
+	AF_NOT_INT:       {Action: FA_DEFAULT},
+	AF_NAN:           {Action: FA_IGNORE},
+	AF_INF:           {Action: FA_IGNORE},
+	AF_OPER_DIV_ZERO: {Action: FA_DEFAULT},
+}
+
+func checkFault(fault ArithFault, flag string) {
+	if fault >= afCount {
+		panic(dmpText(flag, "invalid_fault", uint(fault)))
+	}
+}
+
+// 设置运算异常策略，须在执行表达式之前设置
+func SetArithPolicy(fault ArithFault, policy ArithPolicy) {
+	checkFault(fault, "SetArithPolicy")
+	if policy.Action > FA_ERROR {
+		panic(dmpText("SetArithPolicy", "invalid_fault_action", uint(policy.Action)))
+	}
+	arithPolicies[fault] = policy
+}
+
+func GetArithPolicy(fault ArithFault) ArithPolicy {
+	checkFault(fault, "GetArithPolicy")
+	return arithPolicies[fault]
+}
+
+// 异常结果由策略取值时写警告日志，取左操作数、限定或抛出*ArithError时不写
+func warnFault(fault ArithFault) bool {
+	action := arithPolicies[fault].Action
+	return (action == FA_IGNORE) || (action == FA_DEFAULT)
+}
+
+// 运算异常，Exp为出错的表达式，写入数据时为“数据名运算符值”，如：钱包/=0
+type ArithError struct {
+	Fault ArithFault
+	Exp   string
+	Left  float64
+	Right float64
+}
+
+func (this *ArithError) Error() string {
+	return dmpText("Arith", "arith_error", this.Fault, this.Exp, this.Left, this.Right)
+}
+
+// 按策略处理运算异常并返回运算结果，value为未处理时的结果
+func (this *Storehouse) arithFault(fault ArithFault, exp anyExp, left, right, value float64) float64 {
+	policy := &arithPolicies[fault]
+	if policy.Action == FA_ERROR {
+		name := ""
+		if exp != nil {
+			name = exp.NameExp()
+		}
+		this.countFault(fault)
+		panic(&ArithError{Fault: fault, Exp: name, Left: left, Right: right})
+	}
+	return this.faultValue(fault, policy, left, value)
+}
+
+func (this *Storehouse) faultValue(fault ArithFault, policy *ArithPolicy, left, value float64) float64 {
+	this.countFault(fault)
+	switch policy.Action {
+	case FA_LEFT:
+		return left
+	case FA_DEFAULT:
+		return policy.Value
+	case FA_CLAMP:
+		{
+			if (fault != AF_DIV_ZERO) && (fault != AF_OPER_DIV_ZERO) && (fault != AF_INF) {
+				return policy.Value
+			}
+			if (fault == AF_DIV_ZERO) || (fault == AF_OPER_DIV_ZERO) {
+				value = left
+			}
+			if value > 0 {
+				return math.MaxFloat64
+			} else if value < 0 {
+				return -math.MaxFloat64
+			}
+			return 0
+		}
+	}
+	return value
+}
+
+// 写入数据的运算异常，左值为数据原值
+func (this *Storehouse) operFault(fault ArithFault, cfg *nameCfg, operSymbol OperSymbol, oldValue, value, newValue float64) float64 {
+	policy := &arithPolicies[fault]
+	if policy.Action == FA_ERROR {
+		this.countFault(fault)
+		exp := fmt.Sprintf("%s%s%v", cfg.name, operSymbolText[operSymbol], value)
+		panic(&ArithError{Fault: fault, Exp: exp, Left: oldValue, Right: value})
+	}
+	return this.faultValue(fault, policy, oldValue, newValue)
+}
+
+var operSymbolText = map[OperSymbol]string{
+	OS_INC: "+=",
+	OS_DEC: "-=",
+	OS_MUL: "*=",
+	OS_DIV: "/=",
+	OS_SET: "=",
+	OS_AND: "&=",
+	OS_OR:  "|=",
+}
+
+func (this *Storehouse) countFault(fault ArithFault) {
+	if this != nil {
+		this.faults[fault]++
+	}
+}
+
+// 仓库中某类运算异常发生的次数
+func (this *Storehouse) FaultCount(fault ArithFault) uint64 {
+	checkFault(fault, "Storehouse.FaultCount")
+	return this.faults[fault]
+}
+
+func (this *Storehouse) ResetFaults() {
+	this.faults = [afCount]uint64{}
+}
+
+// 合并其它仓库（如流程的私有仓库）的异常计数，并清零其计数
+func (this *Storehouse) mergeFaults(other *Storehouse) {
+	for i, count := range other.faults {
+		this.faults[i] += count
+	}
+	other.ResetFaults()
+}
+
+// 回调中的*ArithError继续抛出，由最外层的EvalFrml、TryExecOper等返回
+func rethrowArith(err any) {
+	if aerr, ok := err.(*ArithError); ok {
+		panic(aerr)
+	}
+}
+
+// 回收*ArithError为错误，其它异常继续抛出
+func recoverArith(err *error) {
+	if e := recover(); e != nil {
+		aerr, ok := e.(*ArithError)
+		if !ok {
+			panic(e)
+		}
+		*err = aerr
+	}
+}
+
+// 计算公式，运算异常策略为FA_ERROR时返回*ArithError
+func EvalFrml(store *Storehouse, exp FrmlExp) (ret float64, err error) {
+	if exp == nil {
+		return 0, &CodeError{Flag: "EvalFrml", Code: "empty_frml"}
+	}
+	defer recoverArith(&err)
+	return exp.Float64(store), nil
+}
+
+// 检查条件，运算异常策略为FA_ERROR时返回*ArithError
+func EvalCond(store *Storehouse, exp CondExp) (ret bool, err error) {
+	if exp == nil {
+		return false, &CodeError{Flag: "EvalCond", Code: "empty_cond"}
+	}
+	defer recoverArith(&err)
+	return exp.Check(store), nil
+}
+
+// 执行运算集合，运算异常时停止执行并返回*ArithError
+func (this *Workstat) TryExecOper(store *Storehouse, exp OperSet, recordProduce bool) (err error) {
+	defer recoverArith(&err)
+	this.ExecOper(store, exp, recordProduce)
+	return nil
+}
+
+// 执行流程，运算异常时停止执行并返回*ArithError
+func (this *Workstat) TryExecProc(store *Storehouse, exp ProcExp, recordProduce bool) (ret float64, err error) {
+	defer recoverArith(&err)
+	return this.ExecProc(store, exp, recordProduce), nil
+}
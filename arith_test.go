@@ -0,0 +1,263 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// 临时设置运算异常策略，返回恢复函数
+func setTestPolicy(fault ArithFault, policy ArithPolicy) func() {
+	old := GetArithPolicy(fault)
+	SetArithPolicy(fault, policy)
+	return func() {
+		SetArithPolicy(fault, old)
+	}
+}
+
+func TestArithPolicy(t *testing.T) {
+	id := testName("测试异常", "异常甲")
+	SetLogger(LogFunc(func(entry *LogEntry) {}))
+	defer SetLogger(nil)
+
+	tests := []struct {
+		name   string
+		fault  ArithFault
+		policy *ArithPolicy
+		exp    string
+		oper   string
+		want   float64
+	}{
+		{"公式除数为0默认", AF_DIV_ZERO, nil, "异常甲/0", "", 6},
+		{"公式除数为0默认值", AF_DIV_ZERO, &ArithPolicy{Action: FA_DEFAULT, Value: -1}, "异常甲/0", "", -1},
+		{"公式除数为0保留", AF_DIV_ZERO, &ArithPolicy{Action: FA_IGNORE}, "异常甲/0", "", math.Inf(1)},
+		{"公式除数为0限定", AF_DIV_ZERO, &ArithPolicy{Action: FA_CLAMP}, "-异常甲/0", "", -math.MaxFloat64},
+		{"整除除数为0默认", AF_DIV_ZERO, nil, "Div(异常甲, 0)", "", 6},
+		{"取余除数为0默认", AF_MOD_ZERO, nil, "异常甲%0", "", 6},
+		{"整数运算默认", AF_NOT_INT, nil, "异常甲 & 1.5", "", 0},
+		{"整数运算默认值", AF_NOT_INT, &ArithPolicy{Action: FA_DEFAULT, Value: 7}, "~0.5", "", 7},
+		{"写入除数为0默认", AF_OPER_DIV_ZERO, nil, "", "异常甲 /= 0", 0},
+		{"写入除数为0原值", AF_OPER_DIV_ZERO, &ArithPolicy{Action: FA_LEFT}, "", "异常甲 /= 0", 6},
+		{"写入除数为0限定", AF_OPER_DIV_ZERO, &ArithPolicy{Action: FA_CLAMP}, "", "异常甲 /= 0", math.MaxFloat64},
+		{"写入整数运算默认", AF_NOT_INT, nil, "", "异常甲 |= 0.5", 0},
+		{"写入整数运算原值", AF_NOT_INT, &ArithPolicy{Action: FA_LEFT}, "", "异常甲 &= 0.5", 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.policy != nil {
+				defer setTestPolicy(tt.fault, *tt.policy)()
+			}
+			store := NewStorehouse(nil)
+			store.Set(id, 6)
+
+			var got float64
+			if tt.exp != "" {
+				frml, err := ParseFrmlExp(tt.exp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = frml.Float64(store)
+			} else {
+				oper, err := ParseOperExp(tt.oper)
+				if err != nil {
+					t.Fatal(err)
+				}
+				WorkStat.ExecOper(store, oper, false)
+				got = store.Get(id)
+			}
+			if got != tt.want {
+				t.Fatalf("%v，应为%v", got, tt.want)
+			}
+			if count := store.FaultCount(tt.fault); count != 1 {
+				t.Fatalf("异常计数：%d，应为1", count)
+			}
+		})
+	}
+}
+
+func TestArithError(t *testing.T) {
+	id := testName("测试异常", "异常甲")
+	defer setTestPolicy(AF_OPER_DIV_ZERO, ArithPolicy{Action: FA_ERROR})()
+	defer setTestPolicy(AF_DIV_ZERO, ArithPolicy{Action: FA_ERROR})()
+
+	store := NewStorehouse(nil)
+	store.Set(id, 6)
+
+	frml, _ := ParseFrmlExp("异常甲/0")
+	_, err := EvalFrml(store, frml)
+	var aerr *ArithError
+	if !errors.As(err, &aerr) || (aerr.Fault != AF_DIV_ZERO) || (aerr.Left != 6) {
+		t.Fatalf("公式：%v", err)
+	}
+
+	oper, _ := ParseOperExp("异常甲 += 1 异常甲 /= 0 异常甲 += 1")
+	err = WorkStat.TryExecOper(store, oper, false)
+	if !errors.As(err, &aerr) || (aerr.Fault != AF_OPER_DIV_ZERO) || (aerr.Exp != "异常甲/=0") {
+		t.Fatalf("运算集合：%v", err)
+	}
+	if got := store.Get(id); got != 7 {
+		t.Fatalf("已执行的运算不回滚，中止后不再执行：%v，应为7", got)
+	}
+}
+
+// 取余的除数截断为整数后为0时按运算异常策略处理，树形求值与编译后的结果一致
+func TestArithModFraction(t *testing.T) {
+	id := testName("测试异常", "异常甲")
+	SetLogger(LogFunc(func(entry *LogEntry) {}))
+	defer SetLogger(nil)
+
+	tests := []struct {
+		name   string
+		exp    string
+		policy *ArithPolicy
+		want   float64
+		faults uint64
+	}{
+		{"默认取被除数", "异常甲%0.5", nil, 6, 1},
+		{"负小数", "异常甲%-0.9", nil, 6, 1},
+		{"默认值", "异常甲%0.5", &ArithPolicy{Action: FA_DEFAULT, Value: -1}, -1, 1},
+		{"截断后不为0", "异常甲%4.5", nil, 2, 0},
+		{"错误", "异常甲%0.5", &ArithPolicy{Action: FA_ERROR}, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.policy != nil {
+				defer setTestPolicy(AF_MOD_ZERO, *tt.policy)()
+			}
+			frml, err := ParseFrmlExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, exp := range []FrmlExp{frml, CompileFrml(frml)} {
+				store := NewStorehouse(nil)
+				store.Set(id, 6)
+				got, err := EvalFrml(store, exp)
+				if (tt.policy != nil) && (tt.policy.Action == FA_ERROR) {
+					var aerr *ArithError
+					if !errors.As(err, &aerr) || (aerr.Fault != AF_MOD_ZERO) {
+						t.Fatalf("%T应返回*ArithError：%v", exp, err)
+					}
+					continue
+				}
+				if (err != nil) || (got != tt.want) {
+					t.Fatalf("%T：%v %v，应为%v", exp, got, err, tt.want)
+				}
+				if store.FaultCount(AF_MOD_ZERO) != tt.faults {
+					t.Fatalf("%T异常计数：%d", exp, store.FaultCount(AF_MOD_ZERO))
+				}
+			}
+		})
+	}
+}
+
+// 整数运算失败的警告日志只在策略取值时写出
+func TestArithWarnLog(t *testing.T) {
+	id := testName("测试异常", "异常甲")
+
+	var codes []string
+	SetLogger(LogFunc(func(entry *LogEntry) {
+		codes = append(codes, entry.Code)
+	}))
+	defer SetLogger(nil)
+
+	tests := []struct {
+		action FaultAction
+		want   int
+	}{
+		{FA_IGNORE, 3},
+		{FA_LEFT, 0},
+		{FA_DEFAULT, 3},
+		{FA_CLAMP, 0},
+		{FA_ERROR, 0},
+	}
+	for _, tt := range tests {
+		t.Run(Message(faultCodes[AF_NOT_INT]), func(t *testing.T) {
+			defer setTestPolicy(AF_NOT_INT, ArithPolicy{Action: tt.action})()
+			codes = nil
+			store := NewStorehouse(nil)
+			store.Set(id, 6)
+			for _, exp := range []string{"异常甲 & 0.5", "~0.5"} {
+				frml, _ := ParseFrmlExp(exp)
+				EvalFrml(store, frml)
+			}
+			oper, _ := ParseOperExp("异常甲 |= 0.5")
+			WorkStat.TryExecOper(store, oper, false)
+			if len(codes) != tt.want {
+				t.Fatalf("策略%d的日志：%v，应为%d条", tt.action, codes, tt.want)
+			}
+		})
+	}
+}
+
+// 监听回调、Get、Set函数中的*ArithError不被回收，由TryExecOper返回
+func TestArithErrorInCallback(t *testing.T) {
+	id := testName("测试异常", "异常甲")
+	getId := testName("测试异常", "异常取值")
+	setId := testName("测试异常", "异常设值")
+	frml, _ := ParseFrmlExp("异常甲/0")
+	Names.RegisterGetFuncById(getId, func(store *Storehouse, id uint32) float64 {
+		return frml.Float64(store)
+	})
+	Names.RegisterSetFuncById(setId, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) float64 {
+		return frml.Float64(store)
+	})
+	defer setTestPolicy(AF_DIV_ZERO, ArithPolicy{Action: FA_ERROR})()
+
+	var codes []string
+	SetLogger(LogFunc(func(entry *LogEntry) {
+		codes = append(codes, entry.Code)
+	}))
+	defer SetLogger(nil)
+
+	cond, _ := ParseCondExp("异常甲>10")
+	tests := []struct {
+		name   string
+		oper   string
+		listen func(store *Storehouse)
+	}{
+		{"数据监听", "异常甲 = 1", func(store *Storehouse) {
+			store.Lister.AddById(id, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+				frml.Float64(store)
+			})
+		}},
+		{"条件监听", "异常甲 = 20", func(store *Storehouse) {
+			WorkStat.ListenCond(store, cond, func(store *Storehouse, ctx any) {
+				frml.Float64(store)
+			}, nil)
+		}},
+		{"Get函数", "异常甲 = 异常取值", nil},
+		{"Set函数", "异常设值 = 1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codes = nil
+			store := NewStorehouse(nil)
+			if tt.listen != nil {
+				tt.listen(store)
+			}
+			oper, err := ParseOperExp(tt.oper)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = WorkStat.TryExecOper(store, oper, false)
+			var aerr *ArithError
+			if !errors.As(err, &aerr) || (aerr.Fault != AF_DIV_ZERO) {
+				t.Fatalf("应返回*ArithError：%v", err)
+			}
+			if len(codes) != 0 {
+				t.Fatalf("不应记录日志：%v", codes)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+// 时钟(Clock)
+
+// 仓库不是线程安全的，定时回调（防抖、保持计时）须在仓库拥有者的协程中执行：
+// 节拍时钟由拥有者调用Advance推进，回调直接执行；其它时钟的回调投递到仓库，由拥有者调用Storehouse.Pump执行
+
+import (
+	"sync"
+	"time"
+)
+
+type Timer interface {
+	Stop() bool
+}
+
+// 引擎时钟，用于监听防抖、节流等与时间相关的功能，可通过SetClock替换
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, fn func()) Timer
+}
+
+// 系统时钟，定时回调在定时器协程中执行（用于防抖、保持计时时投递到仓库，见Storehouse.Pump）
+type sysClock struct {
+}
+
+func (this sysClock) Now() time.Time {
+	return time.Now()
+}
+
+func (this sysClock) AfterFunc(d time.Duration, fn func()) Timer {
+	return time.AfterFunc(d, fn)
+}
+
+type tickTimer struct {
+	clock   *TickClock
+	when    time.Time
+	seq     uint64
+	fn      func()
+	stopped bool
+}
+
+func (this *tickTimer) Stop() bool {
+	this.clock.mutex.Lock()
+	defer this.clock.mutex.Unlock()
+	if this.stopped {
+		return false
+	}
+	this.stopped = true
+	this.clock.remove(this)
+	return true
+}
+
+// 节拍时钟：时间只在调用Advance时推进，到期的定时回调在Advance中同步执行，
+// 适用于按帧驱动的逻辑和测试；用于仓库的定时回调时应在仓库拥有者的协程中调用Advance
+type TickClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	seq    uint64
+	timers []*tickTimer
+}
+
+func NewTickClock(start time.Time) *TickClock {
+	return &TickClock{now: start}
+}
+
+func (this *TickClock) Now() time.Time {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.now
+}
+
+func (this *TickClock) AfterFunc(d time.Duration, fn func()) Timer {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.seq++
+	ret := &tickTimer{
+		clock: this,
+		when:  this.now.Add(d),
+		seq:   this.seq,
+		fn:    fn,
+	}
+	this.timers = append(this.timers, ret)
+	return ret
+}
+
+func (this *TickClock) remove(timer *tickTimer) {
+	for i, t := range this.timers {
+		if t == timer {
+			this.timers = append(this.timers[:i], this.timers[i+1:]...)
+			return
+		}
+	}
+}
+
+// 推进时间并按到期先后执行定时回调（回调中新增的到期定时器也会执行）
+func (this *TickClock) Advance(d time.Duration) {
+	this.mutex.Lock()
+	end := this.now.Add(d)
+	for {
+		var next *tickTimer
+		for _, t := range this.timers {
+			if t.when.After(end) {
+				continue
+			}
+			if (next == nil) || t.when.Before(next.when) || (t.when.Equal(next.when) && (t.seq < next.seq)) {
+				next = t
+			}
+		}
+		if next == nil {
+			break
+		}
+		this.remove(next)
+		next.stopped = true
+		if next.when.After(this.now) {
+			this.now = next.when
+		}
+		this.mutex.Unlock()
+		next.fn()
+		this.mutex.Lock()
+	}
+	this.now = end
+	this.mutex.Unlock()
+}
+
+// 创建在仓库拥有者协程中执行的定时回调
+func (this *Storehouse) afterFunc(clk Clock, d time.Duration, fn func()) Timer {
+	if _, ok := clk.(*TickClock); ok {
+		return clk.AfterFunc(d, fn)
+	}
+	return clk.AfterFunc(d, func() {
+		this.post(fn)
+	})
+}
+
+func (this *Storehouse) post(fn func()) {
+	this.timerMutex.Lock()
+	this.timerPosts = append(this.timerPosts, fn)
+	notify := this.onTimerPost
+	this.timerMutex.Unlock()
+
+	if notify != nil {
+		notify()
+	}
+}
+
+// 在仓库拥有者的协程中执行已到期的定时回调（防抖、保持计时），返回执行的回调数量；
+// 使用系统时钟等非节拍时钟时，拥有者应在每帧或收到SetPumpNotify的通知后调用
+func (this *Storehouse) Pump() int {
+	this.timerMutex.Lock()
+	posts := this.timerPosts
+	this.timerPosts = nil
+	this.timerMutex.Unlock()
+
+	for _, fn := range posts {
+		fn()
+	}
+	return len(posts)
+}
+
+// 设置有定时回调待执行时的通知函数，在定时器协程中调用，可用于唤醒拥有者调用Pump
+func (this *Storehouse) SetPumpNotify(fn func()) {
+	this.timerMutex.Lock()
+	this.onTimerPost = fn
+	this.timerMutex.Unlock()
+}
@@ -14,8 +14,8 @@ package de
 // 条件系统(Condition System)
 
 import (
-	"errors"
-	"fmt"
+	"math"
+	"strings"
 )
 
 type CondExp interface {
@@ -25,11 +25,37 @@ type CondExp interface {
 	EachId(fn func(id uint32))
 }
 
+// 可解释的条件：检查并返回解释树，用于查看条件不成立的原因；
+// 自定义的CondExp可选实现，未实现时解释为一个只有结果的节点
+type CondExplainer interface {
+	ExplainCheck(store *Storehouse) *CondTrace
+}
+
 type condExp struct {
 	oper  string
 	names INames
 	left  FrmlExp
 	right FrmlExp
+	// 比较容差，nil时使用引擎容差
+	tol *Tolerance
+}
+
+func (this *condExp) getCondExp() *condExp {
+	return this
+}
+
+func (this *condExp) tolerance() *Tolerance {
+	if this.tol != nil {
+		return this.tol
+	}
+	return &tolerance
+}
+
+// 比较表达式
+type compExp interface {
+	CondExp
+	getCondExp() *condExp
+	compare(left, right float64) bool
 }
 
 func (this *condExp) EachId(fn func(id uint32)) {
@@ -45,52 +71,119 @@ func (this *condExp) ValueExp(store *Storehouse) string {
 	return "(" + this.left.ValueExp(store) + this.oper + this.right.ValueExp(store) + ")"
 }
 
+// 带回差的检查：band为回差带宽，比较按有利于条件成立的方向放宽band后仍按比较容差比较，用于条件已成立时判断是否保持成立
+type bandChecker interface {
+	checkBand(store *Storehouse, band float64) bool
+}
+
+func checkBand(cond CondExp, store *Storehouse, band float64) bool {
+	if bc, ok := cond.(bandChecker); ok {
+		return bc.checkBand(store, band)
+	}
+	return cond.Check(store)
+}
+
 type compExpG struct {
 	condExp
 }
 
+func (this *compExpG) compare(left, right float64) bool {
+	return this.tolerance().less(right, left)
+}
+
 func (this *compExpG) Check(store *Storehouse) bool {
-	return this.left.Float64(store) > this.right.Float64(store)
+	return this.compare(this.left.Float64(store), this.right.Float64(store))
+}
+
+func (this *compExpG) checkBand(store *Storehouse, band float64) bool {
+	return this.tolerance().less(this.right.Float64(store)-band, this.left.Float64(store))
 }
 
 type compExpNG struct {
 	condExp
 }
 
+func (this *compExpNG) compare(left, right float64) bool {
+	return this.tolerance().lessOrEqual(left, right)
+}
+
 func (this *compExpNG) Check(store *Storehouse) bool {
-	return this.left.Float64(store) <= this.right.Float64(store)
+	return this.compare(this.left.Float64(store), this.right.Float64(store))
+}
+
+func (this *compExpNG) checkBand(store *Storehouse, band float64) bool {
+	return this.tolerance().lessOrEqual(this.left.Float64(store), this.right.Float64(store)+band)
 }
 
 type compExpL struct {
 	condExp
 }
 
+func (this *compExpL) compare(left, right float64) bool {
+	return this.tolerance().less(left, right)
+}
+
 func (this *compExpL) Check(store *Storehouse) bool {
-	return this.left.Float64(store) < this.right.Float64(store)
+	return this.compare(this.left.Float64(store), this.right.Float64(store))
+}
+
+func (this *compExpL) checkBand(store *Storehouse, band float64) bool {
+	return this.tolerance().less(this.left.Float64(store), this.right.Float64(store)+band)
 }
 
 type compExpNL struct {
 	condExp
 }
 
+func (this *compExpNL) compare(left, right float64) bool {
+	return this.tolerance().lessOrEqual(right, left)
+}
+
 func (this *compExpNL) Check(store *Storehouse) bool {
-	return this.left.Float64(store) >= this.right.Float64(store)
+	return this.compare(this.left.Float64(store), this.right.Float64(store))
+}
+
+func (this *compExpNL) checkBand(store *Storehouse, band float64) bool {
+	return this.tolerance().lessOrEqual(this.right.Float64(store)-band, this.left.Float64(store))
 }
 
 type compExpE struct {
 	condExp
 }
 
+func (this *compExpE) compare(left, right float64) bool {
+	return this.tolerance().equal(left, right)
+}
+
 func (this *compExpE) Check(store *Storehouse) bool {
-	return this.left.Float64(store) == this.right.Float64(store)
+	return this.compare(this.left.Float64(store), this.right.Float64(store))
+}
+
+func (this *compExpE) checkBand(store *Storehouse, band float64) bool {
+	if band < 0 {
+		return this.Check(store)
+	}
+	return this.tolerance().lessOrEqual(math.Abs(this.left.Float64(store)-this.right.Float64(store)), band)
 }
 
 type compExpNE struct {
 	condExp
 }
 
+func (this *compExpNE) compare(left, right float64) bool {
+	return !this.tolerance().equal(left, right)
+}
+
 func (this *compExpNE) Check(store *Storehouse) bool {
-	return this.left.Float64(store) != this.right.Float64(store)
+	return this.compare(this.left.Float64(store), this.right.Float64(store))
+}
+
+// 与等于相反：不等于只在相等时不成立，没有可放宽的方向；取反时（band<0）与带回差的等于一致
+func (this *compExpNE) checkBand(store *Storehouse, band float64) bool {
+	if band > 0 {
+		return this.Check(store)
+	}
+	return !this.tolerance().lessOrEqual(math.Abs(this.left.Float64(store)-this.right.Float64(store)), -band)
 }
 
 type logicExp struct {
@@ -100,6 +193,10 @@ type logicExp struct {
 	right CondExp
 }
 
+func (this *logicExp) getLogicExp() *logicExp {
+	return this
+}
+
 func (this *logicExp) EachId(fn func(id uint32)) {
 	this.left.EachId(fn)
 	this.right.EachId(fn)
@@ -121,6 +218,10 @@ func (this *logicExpAnd) Check(store *Storehouse) bool {
 	return this.left.Check(store) && this.right.Check(store)
 }
 
+func (this *logicExpAnd) checkBand(store *Storehouse, band float64) bool {
+	return checkBand(this.left, store, band) && checkBand(this.right, store, band)
+}
+
 type logicExpOr struct {
 	logicExp
 }
@@ -129,290 +230,242 @@ func (this *logicExpOr) Check(store *Storehouse) bool {
 	return this.left.Check(store) || this.right.Check(store)
 }
 
-type condParser struct {
-	parser
+func (this *logicExpOr) checkBand(store *Storehouse, band float64) bool {
+	return checkBand(this.left, store, band) || checkBand(this.right, store, band)
+}
+
+// 集合判断：x in (1,3,7)、x not in (1,3,7)，集合项可引用数据名
+type inExp struct {
+	names INames
+	not   bool
+	left  FrmlExp
+	items []FrmlExp
+	tol   *Tolerance
 }
 
-func (this *condParser) getCompSymbol() string {
-	if this.index >= this.end {
-		return ""
+func (this *inExp) tolerance() *Tolerance {
+	if this.tol != nil {
+		return this.tol
 	}
+	return &tolerance
+}
 
-	this.pass()
+func (this *inExp) EachId(fn func(id uint32)) {
+	this.left.EachId(fn)
+	for _, item := range this.items {
+		item.EachId(fn)
+	}
+}
 
-	start := this.index
+func (this *inExp) symbol() string {
+	if this.not {
+		return " not in "
+	}
+	return " in "
+}
 
-	for {
-		if this.index >= this.end {
-			break
+func (this *inExp) NameExp() string {
+	ret := ""
+	for _, item := range this.items {
+		if ret != "" {
+			ret = ret + ","
 		}
-		char := this.exp[this.index]
-		switch char {
-		case '>', '<', '=', '!':
-			{
-				this.index++
-				continue
-			}
+		ret = ret + item.NameExp()
+	}
+	return "(" + this.left.NameExp() + this.symbol() + "(" + ret + "))"
+}
+
+func (this *inExp) ValueExp(store *Storehouse) string {
+	ret := ""
+	for _, item := range this.items {
+		if ret != "" {
+			ret = ret + ","
 		}
-		break
+		ret = ret + item.ValueExp(store)
 	}
+	return "(" + this.left.ValueExp(store) + this.symbol() + "(" + ret + "))"
+}
 
-	if start == this.index {
-		return ""
+func (this *inExp) Check(store *Storehouse) bool {
+	value := this.left.Float64(store)
+	tol := this.tolerance()
+	for _, item := range this.items {
+		if tol.equal(item.Float64(store), value) {
+			return !this.not
+		}
 	}
+	return this.not
+}
 
-	return string(this.exp[start:this.index])
+// 范围判断：x between a and b，即 a<=x && x<=b
+type betweenExp struct {
+	names INames
+	left  FrmlExp
+	low   FrmlExp
+	high  FrmlExp
+	tol   *Tolerance
 }
 
-func (this *condParser) getCompLeftFloat64() (ret string) {
-	ret = ""
-	leftParentheses := 0
-	for {
-		this.pass()
+func (this *betweenExp) tolerance() *Tolerance {
+	if this.tol != nil {
+		return this.tol
+	}
+	return &tolerance
+}
 
-		if this.index >= this.end {
-			break
-		}
+func (this *betweenExp) compare(value, low, high float64) bool {
+	tol := this.tolerance()
+	return tol.lessOrEqual(low, value) && tol.lessOrEqual(value, high)
+}
 
-		char := this.exp[this.index]
+func (this *betweenExp) EachId(fn func(id uint32)) {
+	this.left.EachId(fn)
+	this.low.EachId(fn)
+	this.high.EachId(fn)
+}
 
-		if char == '(' {
-			leftParentheses++
-		} else if char == ')' {
-			leftParentheses--
-		}
+func (this *betweenExp) NameExp() string {
+	return "(" + this.left.NameExp() + " between " + this.low.NameExp() + " and " + this.high.NameExp() + ")"
+}
 
-		if leftParentheses == 0 {
-			switch char {
-			case '>', '<', '=', '!':
-				{
-					return
-				}
-			}
-		}
+func (this *betweenExp) ValueExp(store *Storehouse) string {
+	return "(" + this.left.ValueExp(store) + " between " + this.low.ValueExp(store) + " and " + this.high.ValueExp(store) + ")"
+}
 
-		ret = ret + string(char)
-		this.index++
-	}
+func (this *betweenExp) Check(store *Storehouse) bool {
+	return this.checkBand(store, 0)
+}
+
+func (this *betweenExp) checkBand(store *Storehouse, band float64) bool {
+	return this.compare(this.left.Float64(store), this.low.Float64(store)-band, this.high.Float64(store)+band)
+}
 
-	return
+type notExp struct {
+	names INames
+	cond  CondExp
 }
 
-func (this *condParser) getCompRightFloat64() (ret string) {
-	ret = ""
-	leftParentheses := 0
-	for {
-		this.pass()
+func (this *notExp) EachId(fn func(id uint32)) {
+	this.cond.EachId(fn)
+}
 
-		if this.index >= this.end {
-			break
-		}
-		char := this.exp[this.index]
-
-		if char == '(' {
-			leftParentheses++
-		} else if char == ')' {
-			if leftParentheses == 0 {
-				return
-			}
-			leftParentheses--
-		}
+func (this *notExp) NameExp() string {
+	return "!" + this.cond.NameExp()
+}
 
-		if leftParentheses == 0 {
-			switch char {
-			case '&', '|':
-				{
-					return
-				}
-			}
-		}
+func (this *notExp) ValueExp(store *Storehouse) string {
+	return "!" + this.cond.ValueExp(store)
+}
 
-		ret = ret + string(char)
-		this.index++
-	}
+func (this *notExp) Check(store *Storehouse) bool {
+	return !this.cond.Check(store)
+}
+
+// 取反后有利于成立的方向也相反
+func (this *notExp) checkBand(store *Storehouse, band float64) bool {
+	return !checkBand(this.cond, store, -band)
+}
 
-	return
+// 命名条件：由RegisterCond注册，在其它表达式中以“@名字”引用
+type namedCond struct {
+	name string
+	exp  string
+	cond CondExp
+	refs []*namedCond
 }
 
-func (this *condParser) parseCompExp() CondExp {
-	leftStr := this.getCompLeftFloat64()
-	if leftStr == "" {
-		this.doError("parseCompExp 缺失比较左值")
+var condOfName = make(map[string]*namedCond)
+
+// 引用路径，不存在时返回nil
+func (this *namedCond) pathTo(target *namedCond) []string {
+	if this == target {
+		return []string{this.name}
 	}
+	for _, ref := range this.refs {
+		if path := ref.pathTo(target); path != nil {
+			return append([]string{this.name}, path...)
+		}
+	}
+	return nil
+}
 
-	symbol := this.getCompSymbol()
-	if symbol == "" {
-		this.doError(fmt.Sprintf("左值“%s”后面缺失比较符", leftStr))
+// 注册命名条件，已注册的同名条件被替换，已解析的引用（包括已建立的监听）仍使用解析时的定义，
+// 重新解析后才使用新定义，避免检查与监听的数据不一致；
+// 定义中可以引用其它已注册的命名条件，形成循环引用时返回错误
+func RegisterCond(name string, exp string) (err error) {
+	if name == "" {
+		return &CodeError{Flag: "RegisterCond", Code: "cond_name_empty"}
 	}
 
-	rightStr := this.getCompRightFloat64()
-	if rightStr == "" {
-		this.doError(fmt.Sprintf("比较符“%s”缺失右值", symbol))
+	ret := condOfName[name]
+	isNew := ret == nil
+	if isNew {
+		ret = &namedCond{name: name}
+		condOfName[name] = ret
 	}
 
 	defer func() {
-		if err := recover(); err != nil {
-			this.doError(fmt.Sprintf("%v", err))
+		if (err != nil) && isNew {
+			delete(condOfName, name)
 		}
 	}()
+	defer recoverParse(&err)
 
-	left := parseFrmlExpByNames(leftStr, this.names)
-	right := parseFrmlExpByNames(rightStr, this.names)
-
-	switch symbol {
-	case ">":
-		{
-			ret := &compExpG{}
-			ret.oper = symbol
-			ret.names = this.names
-			ret.left = left
-			ret.right = right
-			return ret
-		}
-	case "<=":
-		{
-			ret := &compExpNG{}
-			ret.oper = symbol
-			ret.names = this.names
-			ret.left = left
-			ret.right = right
-			return ret
-		}
-	case "<":
-		{
-			ret := &compExpL{}
-			ret.oper = symbol
-			ret.names = this.names
-			ret.left = left
-			ret.right = right
-			return ret
-		}
-	case ">=":
-		{
-			ret := &compExpNL{}
-			ret.oper = symbol
-			ret.names = this.names
-			ret.left = left
-			ret.right = right
-			return ret
-		}
-	case "=":
-		{
-			ret := &compExpE{}
-			ret.oper = symbol
-			ret.names = this.names
-			ret.left = left
-			ret.right = right
-			return ret
-		}
-	case "!=":
-		{
-			ret := &compExpNE{}
-			ret.oper = symbol
-			ret.names = this.names
-			ret.left = left
-			ret.right = right
-			return ret
-		}
-	default:
-		{
-			this.doError("无效比较符：" + symbol)
+	perser := &exprParser{}
+	perser.init(exp, Names, "Condition")
+	cond := asCond(perser.parseOr())
+	perser.checkEnd()
+
+	for _, ref := range perser.refs {
+		if path := ref.pathTo(ret); path != nil {
+			return &CodeError{Flag: "RegisterCond", Code: "cond_cycle", Args: []any{name, strings.Join(path, " → ")}}
 		}
 	}
 
+	ret.exp = exp
+	ret.cond = cond
+	ret.refs = perser.refs
 	return nil
 }
 
-func (this *condParser) parseLogicExp(left CondExp, char rune) CondExp {
-	if left == nil {
-		this.doError(fmt.Sprintf("逻辑符号“%s”缺失左项", string(char)))
-	}
-
-	if this.index >= this.end {
-		this.doError("缺失比较符")
-	}
-
-	i := this.index
-	ret1 := this.exp[i+1]
-	if ret1 != char {
-		this.doError("缺失符号：" + string(char))
-	}
-
-	this.index += 2
-	logic := string([]rune{char, char})
+// 命名条件引用
+type condRefExp struct {
+	named *namedCond
+	// 解析时的定义，非全局名字系统（如流程临时变量）下为按该名字系统重新解析的定义
+	cond CondExp
+}
 
-	right := this.doParse()
-	if right == nil {
-		this.doError(fmt.Sprintf("逻辑符号“%s”缺失右项", string(char)))
+func newCondRefExp(named *namedCond, names INames) *condRefExp {
+	ret := &condRefExp{named: named, cond: named.cond}
+	if (names != INames(Names)) && (named.cond != nil) {
+		ret.cond = parseCondExpByNames(named.exp, names)
 	}
+	return ret
+}
 
-	if logic == "&&" {
-		ret := &logicExpAnd{}
-		ret.logic = logic
-		ret.names = this.names
-		ret.left = left
-		ret.right = right
-		return ret
-	} else {
-		ret := &logicExpOr{}
-		ret.logic = logic
-		ret.names = this.names
-		ret.left = left
-		ret.right = right
-		return ret
-	}
+func (this *condRefExp) get() CondExp {
+	return this.cond
 }
 
-func (this *condParser) doParse() (ret CondExp) {
+// 按解析时的定义展开，之后重新注册的定义不影响已建立的监听
+func (this *condRefExp) EachId(fn func(id uint32)) {
+	this.get().EachId(fn)
+}
 
-	ret = nil
+func (this *condRefExp) NameExp() string {
+	return "@" + this.named.name
+}
 
-	for {
-		this.pass()
-		if this.index >= this.end {
-			break
-		}
+func (this *condRefExp) ValueExp(store *Storehouse) string {
+	return this.get().ValueExp(store)
+}
 
-		char := this.exp[this.index]
-
-		switch char {
-		case '(':
-			{
-				if ret != nil {
-					this.doError(fmt.Sprintf("表达式“%s”后面有多余符号", ret.NameExp()))
-				}
-
-				this.index++
-				ret = this.doParse()
-				this.pass()
-				if (this.index >= this.end) || (this.exp[this.index] != ')') {
-					this.doError("缺失右括号")
-				}
-				this.index++
-				continue
-			}
-		case '&', '|':
-			{
-				ret = this.parseLogicExp(ret, char)
-				continue
-			}
-		case ')':
-			{
-				return
-			}
-		default:
-			{
-				if ret != nil {
-					this.doError(fmt.Sprintf("表达式“%s”后面有多余符号", ret.NameExp()))
-				}
-
-				ret = this.parseCompExp()
-				continue
-			}
-		}
-	}
+func (this *condRefExp) Check(store *Storehouse) bool {
+	return this.get().Check(store)
+}
 
-	return
+func (this *condRefExp) checkBand(store *Storehouse, band float64) bool {
+	return checkBand(this.get(), store, band)
 }
 
 func parseCondExpByNames(exp string, names INames) CondExp {
@@ -420,20 +473,12 @@ func parseCondExpByNames(exp string, names INames) CondExp {
 		return nil
 	}
 
-	perser := &condParser{}
-	perser.init(exp, names, "Condition")
-	ret := perser.doParse()
-	perser.checkEnd()
-
-	return ret
+	return asCond(parseExpByNames(exp, names, "Condition"))
 }
 
-func ParseCondExpByNames(exp string, names INames) (CondExp, error) {
-	if err := recover(); err != nil {
-		return nil, errors.New(fmt.Sprintf("%v", err))
-	}
-	ret := parseCondExpByNames(exp, names)
-	return ret, nil
+func ParseCondExpByNames(exp string, names INames) (ret CondExp, err error) {
+	defer recoverParse(&err)
+	return parseCondExpByNames(exp, names), nil
 }
 
 func ParseCondExp(exp string) (CondExp, error) {
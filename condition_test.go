@@ -0,0 +1,270 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"errors"
+	"testing"
+)
+
+// 测试条件所用的数据：条甲、条乙、条丙
+func testCondStore(a, b, c float64) *Storehouse {
+	store := NewStorehouse(nil)
+	store.Set(testName("测试条件", "条甲"), a)
+	store.Set(testName("测试条件", "条乙"), b)
+	store.Set(testName("测试条件", "条丙"), c)
+	return store
+}
+
+type condCase struct {
+	a, b, c float64
+	want    bool
+}
+
+func testCondCases(t *testing.T, cond CondExp, cases []condCase) {
+	t.Helper()
+	for _, cs := range cases {
+		store := testCondStore(cs.a, cs.b, cs.c)
+		if got := cond.Check(store); got != cs.want {
+			t.Fatalf("%s在%v,%v,%v时为%v，应为%v", cond.NameExp(), cs.a, cs.b, cs.c, got, cs.want)
+		}
+	}
+}
+
+func TestCondNotAndPrecedence(t *testing.T) {
+	testCondStore(0, 0, 0)
+
+	tests := []struct {
+		name     string
+		exp      string
+		wantName string
+		cases    []condCase
+	}{
+		{"与优先于或", "条甲>1 || 条乙>1 && 条丙>1", "((条甲>1)||((条乙>1)&&(条丙>1)))",
+			[]condCase{{2, 0, 0, true}, {0, 2, 0, false}, {0, 2, 2, true}}},
+		{"括号分组", "(条甲>1 || 条乙>1) && 条丙>1", "(((条甲>1)||(条乙>1))&&(条丙>1))",
+			[]condCase{{2, 0, 0, false}, {2, 0, 2, true}, {0, 2, 2, true}}},
+		{"取反比较", "!条甲>1", "!(条甲>1)",
+			[]condCase{{2, 0, 0, false}, {1, 0, 0, true}}},
+		{"取反分组", "!(条甲>1 && 条乙>1)", "!((条甲>1)&&(条乙>1))",
+			[]condCase{{2, 2, 0, false}, {2, 0, 0, true}}},
+		{"双重取反", "!!条甲>1", "!!(条甲>1)",
+			[]condCase{{2, 0, 0, true}, {0, 0, 0, false}}},
+		{"取反优先于与或", "条甲>=1&&条乙<=2||!条丙<3", "(((条甲>=1)&&(条乙<=2))||!(条丙<3))",
+			[]condCase{{1, 2, 5, true}, {0, 0, 5, true}, {0, 0, 1, false}}},
+		{"==别名", "条甲==1", "(条甲=1)",
+			[]condCase{{1, 0, 0, true}, {2, 0, 0, false}}},
+		{"<>别名", "条甲<>1", "(条甲!=1)",
+			[]condCase{{1, 0, 0, false}, {2, 0, 0, true}}},
+		{"!=", "条甲!=1", "(条甲!=1)",
+			[]condCase{{1, 0, 0, false}, {2, 0, 0, true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond, err := ParseCondExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cond.NameExp() != tt.wantName {
+				t.Fatalf("%s，应为%s", cond.NameExp(), tt.wantName)
+			}
+			testCondCases(t, cond, tt.cases)
+
+			// 输出的分组表达式可以重新解析，且含义不变
+			again, err := ParseCondExp(cond.NameExp())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again.NameExp() != tt.wantName {
+				t.Fatalf("重新解析：%s，应为%s", again.NameExp(), tt.wantName)
+			}
+		})
+	}
+}
+
+func TestCondInBetween(t *testing.T) {
+	testCondStore(0, 0, 0)
+
+	tests := []struct {
+		name     string
+		exp      string
+		wantName string
+		wantIds  []string
+		cases    []condCase
+	}{
+		{"in", "条甲 in (1,3,7)", "(条甲 in (1,3,7))", []string{"条甲"},
+			[]condCase{{1, 0, 0, true}, {7, 0, 0, true}, {2, 0, 0, false}}},
+		{"not in", "条甲 not in (1,3,7)", "(条甲 not in (1,3,7))", []string{"条甲"},
+			[]condCase{{1, 0, 0, false}, {2, 0, 0, true}}},
+		{"集合引用名字", "条甲 in (1,条乙,条丙+1)", "(条甲 in (1,条乙,(条丙+1)))", []string{"条甲", "条乙", "条丙"},
+			[]condCase{{5, 5, 0, true}, {5, 0, 4, true}, {5, 0, 0, false}}},
+		{"between", "条甲 between 10 and 20", "(条甲 between 10 and 20)", []string{"条甲"},
+			[]condCase{{10, 0, 0, true}, {20, 0, 0, true}, {9, 0, 0, false}, {21, 0, 0, false}}},
+		{"between引用名字", "条甲 between 条乙 and 条丙", "(条甲 between 条乙 and 条丙)", []string{"条甲", "条乙", "条丙"},
+			[]condCase{{5, 1, 9, true}, {5, 6, 9, false}}},
+		{"与逻辑组合", "条甲 in (1,2) && !条乙 between 1 and 2", "((条甲 in (1,2))&&!(条乙 between 1 and 2))", []string{"条甲", "条乙"},
+			[]condCase{{1, 3, 0, true}, {1, 2, 0, false}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond, err := ParseCondExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cond.NameExp() != tt.wantName {
+				t.Fatalf("%s，应为%s", cond.NameExp(), tt.wantName)
+			}
+			testCondCases(t, cond, tt.cases)
+
+			ids := make(map[uint32]bool)
+			cond.EachId(func(id uint32) {
+				ids[id] = true
+			})
+			if len(ids) != len(tt.wantIds) {
+				t.Fatalf("EachId：%v，应为%v", ids, tt.wantIds)
+			}
+			for _, name := range tt.wantIds {
+				if !ids[Names.GetIdByName(name)] {
+					t.Fatalf("EachId缺少%s", name)
+				}
+			}
+		})
+	}
+}
+
+// 集合中引用的数据变化时，条件监听同样回调
+func TestCondInListen(t *testing.T) {
+	store := testCondStore(5, 0, 0)
+	cond, err := ParseCondExp("条甲 in (1,条乙)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []bool
+	WorkStat.ListenCondOpt(store, cond, CondOpt{Edge: CE_BOTH}, func(store *Storehouse, state bool, ctx any) {
+		got = append(got, state)
+	}, nil)
+	store.Set(Names.GetIdByName("条乙"), 5)
+	store.Set(Names.GetIdByName("条乙"), 6)
+	if len(got) != 2 || !got[0] || got[1] {
+		t.Fatalf("%v，应为[true false]", got)
+	}
+}
+
+func TestCondInBetweenError(t *testing.T) {
+	testCondStore(0, 0, 0)
+
+	tests := []struct {
+		name string
+		exp  string
+		key  string
+	}{
+		{"空集合", "条甲 in ()", "in_empty"},
+		{"集合缺失右括号", "条甲 in (1,2", "in_missing_rparen"},
+		{"between缺失and", "条甲 between 1", "between_missing_and"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCondExp(tt.exp)
+			var pe *ParseError
+			if !errors.As(err, &pe) || (pe.Key != tt.key) {
+				t.Fatalf("错误：%v，应为%s", err, tt.key)
+			}
+		})
+	}
+}
+
+// 重新注册命名条件不影响已解析的引用及其监听，重新解析后使用新定义
+func TestRegisterCondReplace(t *testing.T) {
+	store := testCondStore(0, 0, 0)
+	a := Names.GetIdByName("条甲")
+	b := Names.GetIdByName("条乙")
+	if err := RegisterCond("测试替换", "条甲>1"); err != nil {
+		t.Fatal(err)
+	}
+	cond, err := ParseCondExp("@测试替换")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []bool
+	WorkStat.ListenCondOpt(store, cond, CondOpt{Edge: CE_BOTH}, func(store *Storehouse, state bool, ctx any) {
+		got = append(got, state)
+	}, nil)
+
+	if err := RegisterCond("测试替换", "条乙>1"); err != nil {
+		t.Fatal(err)
+	}
+	store.Set(b, 5)
+	if cond.Check(store) || (len(got) != 0) {
+		t.Fatalf("已解析的引用应使用原定义：%v %v", cond.Check(store), got)
+	}
+	store.Set(a, 5)
+	if !cond.Check(store) || (len(got) != 1) || !got[0] {
+		t.Fatalf("原定义的监听：%v %v", cond.Check(store), got)
+	}
+
+	again, err := ParseCondExp("@测试替换")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []uint32{}
+	again.EachId(func(id uint32) {
+		ids = append(ids, id)
+	})
+	if (len(ids) != 1) || (ids[0] != b) {
+		t.Fatalf("重新解析后的EachId：%v", ids)
+	}
+	store.Set(b, 0)
+	if again.Check(store) {
+		t.Fatal("重新解析后应使用新定义")
+	}
+}
+
+func TestRegisterCondError(t *testing.T) {
+	testCondStore(0, 0, 0)
+	if err := RegisterCond("测试循环甲", "条甲>1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterCond("测试循环乙", "@测试循环甲 && 条乙>1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cond string
+		exp  string
+		key  string
+	}{
+		{"循环引用", "测试循环甲", "@测试循环乙", "cond_cycle"},
+		{"引用自身", "测试循环丙", "@测试循环丙", "cond_cycle"},
+		{"未注册", "测试循环丁", "@未注册的条件", "undefined_cond"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RegisterCond(tt.cond, tt.exp)
+			if err == nil {
+				t.Fatal("应返回错误")
+			}
+			var pe *ParseError
+			var ce *CodeError
+			if !(errors.As(err, &pe) && (pe.Key == tt.key)) && !(errors.As(err, &ce) && (ce.Code == tt.key)) {
+				t.Fatalf("错误：%v，应为%s", err, tt.key)
+			}
+		})
+	}
+	if _, ok := condOfName["测试循环丙"]; ok {
+		t.Fatal("注册失败的新条件应移除")
+	}
+	// 注册失败时原定义不变
+	if condOfName["测试循环甲"].exp != "条甲>1" {
+		t.Fatalf("原定义被修改：%s", condOfName["测试循环甲"].exp)
+	}
+}
@@ -0,0 +1,425 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+// 定点数(Decimal)
+//
+// 定点数据（如钱包、价格）仍以float64存储，但值总是其十进制定点值最接近的浮点数；
+// 运算时按浮点数的最短十进制表示取精确值，以定点整数（big.Int）精确计算后按数据的精度和舍入方式舍入，
+// 因此“钱包-=0.1”执行10次后恰好回到原值。
+// 含定点数据的+、-、*、/、%运算在解析时确定为定点运算，结果精度取运算项中定点数据的最大精度，
+// 其中%与浮点运算一样按截断后的整数取余；
+// 定点数据不能直接作为浮点函数（如Sin）的参数，须用Float(x)显式转换，浮点结果可用Decimal(x,精度)转为定点数
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+type RoundMode uint
+
+const (
+	// 四舍五入（0.5远离0）
+	RM_HALF_UP RoundMode = iota
+	// 四舍六入五成双（银行家舍入）
+	RM_HALF_EVEN
+	// 趋向0截断
+	RM_DOWN
+	// 远离0进位
+	RM_UP
+	// 向下（负无穷）
+	RM_FLOOR
+	// 向上（正无穷）
+	RM_CEIL
+)
+
+// 定点数精度上限，超过后float64不能精确表示
+const maxDecimalScale = 15
+
+// 定点数的精度（小数位数）及舍入方式
+type Decimal struct {
+	Scale int
+	Round RoundMode
+}
+
+// 引擎定点模式，非nil时没有Get、Set函数的数据均按此精度作为定点数
+var decimalMode *Decimal
+
+func checkDecimal(scale int, flag string) {
+	if (scale < 0) || (scale > maxDecimalScale) {
+		panic(dmpText(flag, "decimal_scale", maxDecimalScale, scale))
+	}
+}
+
+// 设置引擎的定点模式，nil为关闭；须在解析表达式之前设置
+func SetDecimalMode(value *Decimal) {
+	if value != nil {
+		checkDecimal(value.Scale, "SetDecimalMode")
+	}
+	decimalMode = value
+}
+
+func (this *nameCfg) decimal() *Decimal {
+	if this.dec != nil {
+		return this.dec
+	}
+	if (decimalMode != nil) && (this.getFunc == nil) && (this.setFunc == nil) {
+		return decimalMode
+	}
+	return nil
+}
+
+var bigOne = big.NewInt(1)
+
+func pow10Big(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// 浮点数按最短十进制表示的精确值：value = mant / 10^scale，scale >= 0
+func exactDecimal(value float64) (mant *big.Int, scale int) {
+	str := strconv.FormatFloat(value, 'e', -1, 64)
+	pos := strings.IndexByte(str, 'e')
+	exp, _ := strconv.Atoi(str[pos+1:])
+	digits := strings.Replace(str[:pos], ".", "", 1)
+	mant, _ = new(big.Int).SetString(digits, 10)
+
+	scale = len(strings.TrimPrefix(digits, "-")) - 1 - exp
+	if scale < 0 {
+		mant.Mul(mant, pow10Big(-scale))
+		scale = 0
+	}
+	return
+}
+
+// 按舍入方式计算 num/den（den>0）
+func roundQuo(num, den *big.Int, mode RoundMode) *big.Int {
+	rem := new(big.Int)
+	quo, rem := new(big.Int).QuoRem(num, den, rem)
+	if rem.Sign() == 0 {
+		return quo
+	}
+
+	neg := num.Sign() < 0
+	away := false
+	switch mode {
+	case RM_DOWN:
+		away = false
+	case RM_UP:
+		away = true
+	case RM_FLOOR:
+		away = neg
+	case RM_CEIL:
+		away = !neg
+	default:
+		half := new(big.Int).Abs(rem)
+		half.Lsh(half, 1)
+		switch half.Cmp(den) {
+		case 1:
+			away = true
+		case 0:
+			away = (mode != RM_HALF_EVEN) || (quo.Bit(0) == 1)
+		}
+	}
+
+	if away {
+		if neg {
+			quo.Sub(quo, bigOne)
+		} else {
+			quo.Add(quo, bigOne)
+		}
+	}
+	return quo
+}
+
+// 精确值按精度舍入为定点整数
+func (this *Decimal) scaled(mant *big.Int, scale int) *big.Int {
+	if scale <= this.Scale {
+		return new(big.Int).Mul(mant, pow10Big(this.Scale-scale))
+	}
+	return roundQuo(mant, pow10Big(scale-this.Scale), this.Round)
+}
+
+// 定点整数转为最接近的浮点数
+func (this *Decimal) float(value *big.Int) float64 {
+	ret, _ := strconv.ParseFloat(value.String()+"e-"+strconv.Itoa(this.Scale), 64)
+	return ret
+}
+
+// 按精度及舍入方式舍入
+func (this *Decimal) RoundValue(value float64) float64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return value
+	}
+	return this.float(this.scaled(exactDecimal(value)))
+}
+
+// 定点运算：运算项按精确值计算，结果按精度舍入；除数为0时与浮点运算一致
+func (this *Decimal) oper(store *Storehouse, exp anyExp, oper string, left, right float64) float64 {
+	if math.IsNaN(left) || math.IsInf(left, 0) || math.IsNaN(right) || math.IsInf(right, 0) {
+		return floatOper(store, exp, oper, left, right)
+	}
+
+	lm, ls := exactDecimal(left)
+	rm, rs := exactDecimal(right)
+	// 对齐到相同的小数位数
+	align := func() int {
+		if ls < rs {
+			lm.Mul(lm, pow10Big(rs-ls))
+			return rs
+		}
+		rm.Mul(rm, pow10Big(ls-rs))
+		return ls
+	}
+
+	switch oper {
+	case "+":
+		{
+			scale := align()
+			return this.float(this.scaled(lm.Add(lm, rm), scale))
+		}
+	case "-":
+		{
+			scale := align()
+			return this.float(this.scaled(lm.Sub(lm, rm), scale))
+		}
+	case "*":
+		{
+			return this.float(this.scaled(lm.Mul(lm, rm), ls+rs))
+		}
+	case "/":
+		{
+			if rm.Sign() == 0 {
+				return floatOper(store, exp, oper, left, right)
+			}
+			// left/right*10^Scale = lm*10^(rs+Scale) / (rm*10^ls)
+			num := lm.Mul(lm, pow10Big(rs+this.Scale))
+			den := rm.Mul(rm, pow10Big(ls))
+			if den.Sign() < 0 {
+				num.Neg(num)
+				den.Neg(den)
+			}
+			return this.float(roundQuo(num, den, this.Round))
+		}
+	case "%":
+		{
+			// 与浮点运算一致，按截断后的整数取余
+			return this.RoundValue(modValue(store, exp, left, right))
+		}
+	}
+	return floatOper(store, exp, oper, left, right)
+}
+
+func floatOper(store *Storehouse, exp anyExp, oper string, left, right float64) float64 {
+	switch oper {
+	case "+":
+		return left + right
+	case "-":
+		return left - right
+	case "*":
+		return left * right
+	case "/":
+		return divValue(store, exp, left, right)
+	case "%":
+		return modValue(store, exp, left, right)
+	}
+	return math.NaN()
+}
+
+// 合并两个定点精度，取较大的精度，精度相同时取左边的舍入方式
+func mergeDecimal(left, right *Decimal) *Decimal {
+	if (left == nil) || ((right != nil) && (right.Scale > left.Scale)) {
+		return right
+	}
+	return left
+}
+
+// 可直接使用定点数参数的函数，其余函数须用Float转换
+var decimalFuncs = map[string]bool{
+	"If":      true,
+	"Min":     true,
+	"Max":     true,
+	"Abs":     true,
+	"Floor":   true,
+	"Ceil":    true,
+	"Trunc":   true,
+	"Float":   true,
+	"Decimal": true,
+}
+
+// 表达式的定点精度，nil为浮点表达式
+func decimalOf(exp FrmlExp) *Decimal {
+	switch v := exp.(type) {
+	case *idenExp:
+		{
+			if cfg := v.names.GetCfgById(v.id); cfg != nil {
+				return cfg.decimal()
+			}
+		}
+	case *negExp:
+		{
+			return decimalOf(v.value)
+		}
+	case *compiledFrml:
+		{
+			return decimalOf(v.src)
+		}
+	case interface{ getFrmlExp() *frmlExp }:
+		{
+			return v.getFrmlExp().dec
+		}
+	case interface{ getFuncExp() *funcExp }:
+		{
+			base := v.getFuncExp()
+			if base.name == "Decimal" {
+				if c, ok := base.params[1].(*constExp); ok {
+					return &Decimal{Scale: int(c.value), Round: RM_HALF_UP}
+				}
+				return nil
+			}
+			if (base.name == "Float") || !decimalFuncs[base.name] {
+				return nil
+			}
+			var ret *Decimal
+			for _, param := range base.params {
+				ret = mergeDecimal(ret, decimalOf(param))
+			}
+			return ret
+		}
+	}
+	return nil
+}
+
+// 浮点函数的定点数参数，没有时返回nil
+func decimalParamOf(exp FrmlExp) (param FrmlExp, funcName string) {
+	fn, ok := exp.(interface{ getFuncExp() *funcExp })
+	if !ok {
+		return nil, ""
+	}
+	base := fn.getFuncExp()
+	if decimalFuncs[base.name] {
+		return nil, ""
+	}
+	for _, param := range base.params {
+		if decimalOf(param) != nil {
+			return param, base.name
+		}
+	}
+	return nil, ""
+}
+
+// 常量精度超出范围的Decimal函数，返回该精度；精度为变量时在运算时检查
+func badDecimalScale(exp FrmlExp) (scale int, bad bool) {
+	fn, ok := exp.(interface{ getFuncExp() *funcExp })
+	if !ok || (fn.getFuncExp().name != "Decimal") {
+		return 0, false
+	}
+	c, ok := fn.getFuncExp().params[1].(*constExp)
+	if !ok {
+		return 0, false
+	}
+	scale = int(c.value)
+	return scale, (scale < 0) || (scale > maxDecimalScale)
+}
+
+// 浮点函数的参数不能为定点数，Decimal函数的常量精度须在范围内
+func checkDecimalParams(exp FrmlExp) {
+	if param, name := decimalParamOf(exp); param != nil {
+		parseFail("decimal_float_func", param.NameExp(), name, param.NameExp())
+	}
+	if scale, bad := badDecimalScale(exp); bad {
+		parseFail("decimal_scale", maxDecimalScale, scale)
+	}
+}
+
+// 定点运算的运算符
+func isDecimalOper(oper string) bool {
+	switch oper {
+	case "+", "-", "*", "/", "%":
+		return true
+	}
+	return false
+}
+
+func (this *frmlExp) decimalValue(store *Storehouse) float64 {
+	return this.dec.oper(store, this, this.oper, this.left.Float64(store), this.right.Float64(store))
+}
+
+// 存储运算的定点结果，newValue为浮点运算的结果；除数为0时与浮点运算一致
+func (this *Decimal) operSymbol(store *Storehouse, operSymbol OperSymbol, oldValue, value, newValue float64) float64 {
+	switch operSymbol {
+	case OS_INC:
+		return this.oper(store, nil, "+", oldValue, value)
+	case OS_DEC:
+		return this.oper(store, nil, "-", oldValue, value)
+	case OS_MUL:
+		return this.oper(store, nil, "*", oldValue, value)
+	case OS_DIV:
+		if value != 0 {
+			return this.oper(store, nil, "/", oldValue, value)
+		}
+	}
+	return this.RoundValue(newValue)
+}
+
+func (this *names) RegisterDecimalByType(typeName string, scale int, round RoundMode) {
+	tcfg := this.typeCfgOfName[typeName]
+	if tcfg == nil {
+		panic(dmpText("names.RegisterDecimalByType", "type_not_registered", typeName))
+	}
+	checkDecimal(scale, "names.RegisterDecimalByType")
+	tcfg.dec = &Decimal{Scale: scale, Round: round}
+
+	for _, cfg := range this.nameCfgsOfType[typeName] {
+		cfg.dec = tcfg.dec
+	}
+}
+
+func (this *names) RegisterDecimalByName(name string, scale int, round RoundMode) {
+	id := this.GetIdByName(name)
+	if id == 0 {
+		panic(dmpText("names.RegisterDecimalByName", "name_not_registered", name))
+	}
+
+	this.RegisterDecimalById(id, scale, round)
+}
+
+// 设置数据为定点数，须在解析引用该数据的表达式之前设置
+func (this *names) RegisterDecimalById(id uint32, scale int, round RoundMode) {
+	cfg := this.nameCfgOfId[id]
+	if cfg == nil {
+		panic(dmpText("names.RegisterDecimalById", "id_not_registered", id))
+	}
+	checkDecimal(scale, "names.RegisterDecimalById")
+	cfg.dec = &Decimal{Scale: scale, Round: round}
+}
+
+func init() {
+	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
+		return params[0].Float64(store)
+	}
+	RegisterPureFunc("Float", funcExec, 1)
+}
+
+func init() {
+	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
+		scale := int(params[1].Float64(store))
+		if (scale < 0) || (scale > maxDecimalScale) {
+			writeLog(&LogEntry{Level: LL_WARN, Code: "decimal_scale", Args: []any{maxDecimalScale, scale}, Flag: "Decimal"})
+			return params[0].Float64(store)
+		}
+		dec := &Decimal{Scale: scale, Round: RM_HALF_UP}
+		return dec.RoundValue(params[0].Float64(store))
+	}
+	RegisterPureFunc("Decimal", funcExec, 2)
+}
@@ -0,0 +1,192 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"errors"
+	"testing"
+)
+
+// 定点测试所用的数据：钱包、定价为2位小数四舍五入，汇率为4位小数银行家舍入
+func testDecimalStore() *Storehouse {
+	testName("测试定点", "钱包")
+	testName("测试定点", "定价")
+	testName("测试定点", "汇率")
+	testName("测试定点", "浮点")
+	Names.RegisterDecimalByName("钱包", 2, RM_HALF_UP)
+	Names.RegisterDecimalByName("定价", 2, RM_HALF_UP)
+	Names.RegisterDecimalByName("汇率", 4, RM_HALF_EVEN)
+	return NewStorehouse(nil)
+}
+
+// 钱包-=0.1执行10次后恰好回到原值
+func TestDecimalWallet(t *testing.T) {
+	store := testDecimalStore()
+	id := Names.GetIdByName("钱包")
+
+	store.Set(id, 1)
+	for i := 0; i < 10; i++ {
+		store.Oper(id, OS_DEC, 0.1)
+	}
+	if got := store.Get(id); got != 0 {
+		t.Fatalf("Oper：%v，应为0", got)
+	}
+
+	oper, err := ParseOperExp("钱包 += 0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		WorkStat.ExecOper(store, oper, false)
+	}
+	if got := store.Get(id); got != 0.3 {
+		t.Fatalf("运算集合：%v，应为0.3", got)
+	}
+	cond, err := ParseCondExp("钱包 = 0.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cond.Check(store) {
+		t.Fatal("钱包 = 0.3 应成立")
+	}
+}
+
+func TestDecimalRound(t *testing.T) {
+	tests := []struct {
+		name  string
+		round RoundMode
+		value float64
+		want  float64
+	}{
+		{"四舍五入", RM_HALF_UP, 2.345, 2.35},
+		{"四舍五入负数", RM_HALF_UP, -2.345, -2.35},
+		{"银行家舍入偶", RM_HALF_EVEN, 2.345, 2.34},
+		{"银行家舍入奇", RM_HALF_EVEN, 2.355, 2.36},
+		{"截断", RM_DOWN, -2.349, -2.34},
+		{"进位", RM_UP, 2.341, 2.35},
+		{"向下", RM_FLOOR, -2.341, -2.35},
+		{"向上", RM_CEIL, -2.349, -2.34},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := &Decimal{Scale: 2, Round: tt.round}
+			if got := dec.RoundValue(tt.value); got != tt.want {
+				t.Fatalf("%v，应为%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecimalFrml(t *testing.T) {
+	store := testDecimalStore()
+	store.Set(Names.GetIdByName("钱包"), 10)
+	store.Set(Names.GetIdByName("定价"), 0.1)
+	store.Set(Names.GetIdByName("汇率"), 7.1234)
+	store.Set(Names.GetIdByName("浮点"), 0.1)
+
+	tests := []struct {
+		exp  string
+		want float64
+	}{
+		{"定价*3", 0.3},
+		{"定价+0.2", 0.3},
+		{"钱包/3", 3.33},
+		{"钱包%3", 1},
+		{"定价*汇率", 0.7123},
+		{"浮点*3", 0.30000000000000004},
+		{"Max(定价, 0.05)*3", 0.3},
+		{"Sin(Float(定价))*0", 0},
+		{"Decimal(浮点*3, 2)", 0.3},
+		{"Decimal(2.5, 0)", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			frml, err := ParseFrmlExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := frml.Float64(store); got != tt.want {
+				t.Fatalf("%v，应为%v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 定点数据与浮点数据的取余含义相同：截断为整数后取余
+func TestDecimalMod(t *testing.T) {
+	store := testDecimalStore()
+	SetLogger(LogFunc(func(entry *LogEntry) {}))
+	defer SetLogger(nil)
+
+	tests := []struct {
+		left  float64
+		right string
+		want  float64
+	}{
+		{5.5, "2", 1},
+		{5.5, "2.5", 1},
+		{9.99, "4", 1},
+		{7.25, "-3.9", 1},
+		{5.5, "0.5", 5.5},
+	}
+	for _, tt := range tests {
+		for _, name := range []string{"钱包", "浮点"} {
+			exp := name + "%" + tt.right
+			t.Run(exp, func(t *testing.T) {
+				store.Set(Names.GetIdByName(name), tt.left)
+				frml, err := ParseFrmlExp(exp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := frml.Float64(store); got != tt.want {
+					t.Fatalf("%v%%%s：%v，应为%v", tt.left, tt.right, got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestDecimalParseError(t *testing.T) {
+	testDecimalStore()
+
+	tests := []struct {
+		exp  string
+		key  string
+		kind ParseErrorKind
+	}{
+		{"Sin(定价)", "decimal_float_func", PE_TYPE},
+		{"Sqrt(钱包+1)", "decimal_float_func", PE_TYPE},
+		{"Decimal(浮点, 20)", "decimal_scale", PE_FUNC},
+		{"Decimal(浮点, -1)", "decimal_scale", PE_FUNC},
+		{"1+Decimal(浮点, 16)", "decimal_scale", PE_FUNC},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			_, err := ParseFrmlExp(tt.exp)
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("应返回解析错误：%v", err)
+			}
+			if (pe.Key != tt.key) || (pe.Kind != tt.kind) {
+				t.Fatalf("错误：%s(%v)，应为%s(%v)", pe.Key, pe.Kind, tt.key, tt.kind)
+			}
+		})
+	}
+
+	// 精度为变量时在运算时检查
+	if _, err := ParseFrmlExp("Decimal(浮点, 浮点)"); err != nil {
+		t.Fatal(err)
+	}
+	mustPanic(t, func() {
+		Names.RegisterDecimalByName("浮点", maxDecimalScale+1, RM_HALF_UP)
+	})
+}
@@ -0,0 +1,157 @@
+package main
+
+// 表达式配置文件格式化工具，用法：
+//   go run ./demo/defmt [-w] [-l] [-kind proc] [-names names.txt] 文件或目录...
+// 文件类型按扩展名确定：.frml 公式、.cond 条件、.oper 运算集合、.proc 流程（其它扩展名按-kind，默认流程），
+// 公式与条件文件整个文件为一个表达式；目录下只处理以上扩展名的文件。
+// 公式、条件及运算集合中的数据名须已注册，可用-names指定名字文件（以空白分隔的数据名）
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"lib/data-e"
+)
+
+var (
+	write = flag.Bool("w", false, "将结果写回源文件（默认输出到标准输出）")
+	list  = flag.Bool("l", false, "只列出格式需要改变的文件")
+	kind  = flag.String("kind", "proc", "未知扩展名的文件类型：frml、cond、oper、proc")
+	names = flag.String("names", "", "名字文件，其中以空白分隔的数据名注册后再解析")
+)
+
+var formatOfKind = map[string]func(string) (string, error){
+	"frml": formatLine(de.FormatFrmlExp),
+	"cond": formatLine(de.FormatCondExp),
+	"oper": de.FormatOperExp,
+	"proc": de.FormatProcExp,
+}
+
+// 公式与条件的格式化结果补上换行
+func formatLine(fn func(string) (string, error)) func(string) (string, error) {
+	return func(src string) (string, error) {
+		ret, err := fn(strings.TrimSpace(src))
+		if err != nil {
+			return "", err
+		}
+		return ret + "\n", nil
+	}
+}
+
+func kindOf(path string) (string, bool) {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	if _, ok := formatOfKind[ext]; ok {
+		return ext, true
+	}
+	return *kind, false
+}
+
+func registerNames(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for _, name := range strings.Fields(string(data)) {
+		if de.Names.GetIdByName(name) == 0 {
+			de.Names.RegisterName("defmt", name, 0)
+		}
+	}
+	return nil
+}
+
+func formatFile(path string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	k, _ := kindOf(path)
+	format := formatOfKind[k]
+	if format == nil {
+		return fmt.Errorf("未知文件类型：%s", k)
+	}
+	ret, err := format(string(src))
+	if err != nil {
+		return err
+	}
+
+	changed := !bytes.Equal(src, []byte(ret))
+	if *list {
+		if changed {
+			fmt.Println(path)
+		}
+		return nil
+	}
+	if *write {
+		if changed {
+			return os.WriteFile(path, []byte(ret), 0644)
+		}
+		return nil
+	}
+	_, err = os.Stdout.WriteString(ret)
+	return err
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "用法：defmt [-w] [-l] [-kind proc] [-names names.txt] 文件或目录...")
+		os.Exit(2)
+	}
+	if _, ok := formatOfKind[*kind]; !ok {
+		fmt.Fprintf(os.Stderr, "无效的-kind：%s\n", *kind)
+		os.Exit(2)
+	}
+	if *names != "" {
+		if err := registerNames(*names); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	failed := false
+	report := func(path string, err error) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		failed = true
+	}
+
+	for _, arg := range flag.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			report(arg, err)
+			continue
+		}
+		if !info.IsDir() {
+			if err := formatFile(arg); err != nil {
+				report(arg, err)
+			}
+			continue
+		}
+
+		filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				report(path, err)
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if _, ok := kindOf(path); !ok {
+				return nil
+			}
+			if err := formatFile(path); err != nil {
+				report(path, err)
+			}
+			return nil
+		})
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
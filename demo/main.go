@@ -64,7 +64,7 @@ func main() {
 	if err != nil {
 		panic(err.Error())
 	}
-	onCond := func(store *de.Storehouse, extParam uintptr) {
+	onCond := func(store *de.Storehouse, state bool, ctx any) {
 		log.Println("**************************************************************")
 		log.Println("当" + cond.NameExp() + "时打印：【钱包极度膨胀】")
 		log.Println("**************************************************************")
@@ -73,8 +73,8 @@ func main() {
 	store := de.NewStorehouse(nil)
 
 	workstat := de.NewWorkstat()
-	// 设置监听条件：当条件满足时，执行OnCond
-	workstat.ListenCond(store, cond, onCond, 0)
+	// 设置监听条件：当条件由不满足变为满足时，执行OnCond（钱包再变化不会重复执行）
+	workstat.ListenCondOpt(store, cond, de.CondOpt{Edge: de.CE_RISING}, onCond, nil)
 
 	// 执行运算集合（初始化基础数据：生年=2002 年份=2022 年龄=年份-生年 名字=卢益贵）
 	workstat.ExecOper(store, oper, false)
@@ -0,0 +1,759 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+// 表达式JSON序列化(Expression JSON Serialization)
+//
+// 表达式按语法树编码为JSON节点，不依赖文本语法：
+//   {"kind":"binary","oper":"+","args":[{"kind":"name","name":"年龄","id":16777217},{"kind":"num","value":1}]}
+// 数据名按名字编码并附带ID，解码时优先按名字查找，名字为空时按ID查找；
+// 函数按注册名编码，参数在args中；非有限数值编码为字符串"NaN"、"+Inf"、"-Inf"；
+// 编译后的表达式按源表达式编码，解码得到未编译的表达式
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// 节点类型
+const (
+	ekNum     = "num"
+	ekName    = "name"
+	ekBinary  = "binary"
+	ekNeg     = "neg"
+	ekBitNot  = "bitnot"
+	ekFunc    = "func"
+	ekIf      = "if"
+	ekCompare = "compare"
+	ekAnd     = "and"
+	ekOr      = "or"
+	ekNot     = "not"
+	ekIn      = "in"
+	ekBetween = "between"
+	ekRef     = "ref"
+	ekOper    = "oper"
+	ekReturn  = "return"
+	ekOpers   = "opers"
+	ekProc    = "proc"
+)
+
+// 数值，非有限值编码为字符串
+type jsonFloat float64
+
+func (this jsonFloat) MarshalJSON() ([]byte, error) {
+	value := float64(this)
+	switch {
+	case math.IsNaN(value):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(value, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(value, -1):
+		return []byte(`"-Inf"`), nil
+	}
+	return []byte(strconv.FormatFloat(value, 'g', -1, 64)), nil
+}
+
+func (this *jsonFloat) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if (len(data) > 0) && (data[0] == '"') {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return &CodeError{Flag: "UnmarshalJSON", Code: "json_invalid_number", Args: []any{string(data)}}
+	}
+	*this = jsonFloat(value)
+	return nil
+}
+
+type expNode struct {
+	Kind  string     `json:"kind"`
+	Oper  string     `json:"oper,omitempty"`
+	Name  string     `json:"name,omitempty"`
+	Id    uint32     `json:"id,omitempty"`
+	Value *jsonFloat `json:"value,omitempty"`
+	Not   bool       `json:"not,omitempty"`
+	Tol   *Tolerance `json:"tol,omitempty"`
+	Cond  *expNode   `json:"cond,omitempty"`
+	Args  []*expNode `json:"args,omitempty"`
+	Steps []*expNode `json:"steps,omitempty"`
+}
+
+func nameNode(kind string, names INames, id uint32) *expNode {
+	return &expNode{Kind: kind, Name: names.GetNameById(id), Id: id}
+}
+
+func argNodes(exps ...anyExp) []*expNode {
+	ret := make([]*expNode, len(exps))
+	for i, exp := range exps {
+		ret[i] = toNode(exp)
+	}
+	return ret
+}
+
+func frmlNodes(exps []FrmlExp) []*expNode {
+	ret := make([]*expNode, len(exps))
+	for i, exp := range exps {
+		ret[i] = toNode(exp)
+	}
+	return ret
+}
+
+// 表达式转为节点，条件与数值之间的转换（boolExp、truthExp）不单独编码，解码时按所处位置还原
+func toNode(exp interface{}) *expNode {
+	switch v := exp.(type) {
+	case *constExp:
+		{
+			value := jsonFloat(v.value)
+			return &expNode{Kind: ekNum, Value: &value}
+		}
+	case *idenExp:
+		{
+			return nameNode(ekName, v.names, v.id)
+		}
+	case *negExp:
+		{
+			return &expNode{Kind: ekNeg, Args: argNodes(v.value)}
+		}
+	case *bitNotExp:
+		{
+			return &expNode{Kind: ekBitNot, Args: argNodes(v.value)}
+		}
+	case *boolExp:
+		{
+			return toNode(v.cond)
+		}
+	case *truthExp:
+		{
+			return toNode(v.frml)
+		}
+	case *compiledFrml:
+		{
+			return toNode(v.src)
+		}
+	case *compiledCond:
+		{
+			return toNode(v.src)
+		}
+	case *ifFuncExp:
+		{
+			return &expNode{Kind: ekIf, Cond: toNode(v.cond), Args: frmlNodes(v.params)}
+		}
+	case interface{ getFuncExp() *funcExp }:
+		{
+			base := v.getFuncExp()
+			return &expNode{Kind: ekFunc, Name: base.name, Args: frmlNodes(base.params)}
+		}
+	case interface{ getFrmlExp() *frmlExp }:
+		{
+			base := v.getFrmlExp()
+			return &expNode{Kind: ekBinary, Oper: base.oper, Args: argNodes(base.left, base.right)}
+		}
+	case interface{ getCondExp() *condExp }:
+		{
+			base := v.getCondExp()
+			return &expNode{Kind: ekCompare, Oper: base.oper, Tol: base.tol, Args: argNodes(base.left, base.right)}
+		}
+	case interface{ getLogicExp() *logicExp }:
+		{
+			base := v.getLogicExp()
+			kind := ekAnd
+			if base.logic == "||" {
+				kind = ekOr
+			}
+			return &expNode{Kind: kind, Args: argNodes(base.left, base.right)}
+		}
+	case *notExp:
+		{
+			return &expNode{Kind: ekNot, Args: argNodes(v.cond)}
+		}
+	case *inExp:
+		{
+			return &expNode{Kind: ekIn, Not: v.not, Tol: v.tol, Args: frmlNodes(append([]FrmlExp{v.left}, v.items...))}
+		}
+	case *betweenExp:
+		{
+			return &expNode{Kind: ekBetween, Tol: v.tol, Args: argNodes(v.left, v.low, v.high)}
+		}
+	case *condRefExp:
+		{
+			return &expNode{Kind: ekRef, Name: v.named.name}
+		}
+	case *incOperExp:
+		{
+			return operNode(&v.operExp, "+=")
+		}
+	case *decOperExp:
+		{
+			return operNode(&v.operExp, "-=")
+		}
+	case *mulOperExp:
+		{
+			return operNode(&v.operExp, "*=")
+		}
+	case *divOperExp:
+		{
+			return operNode(&v.operExp, "/=")
+		}
+	case *andOperExp:
+		{
+			return operNode(&v.operExp, "&=")
+		}
+	case *orOperExp:
+		{
+			return operNode(&v.operExp, "|=")
+		}
+	case *setOperExp:
+		{
+			return operNode(&v.operExp, "=")
+		}
+	case *returnExp:
+		{
+			return &expNode{Kind: ekReturn, Args: argNodes(v.value)}
+		}
+	case *ifReturnExp:
+		{
+			return &expNode{Kind: ekReturn, Cond: toNode(v.cond), Args: argNodes(v.value)}
+		}
+	case *procExp:
+		{
+			return &expNode{Kind: ekProc, Steps: stepNodes(v.operSet)}
+		}
+	case *operSet:
+		{
+			return &expNode{Kind: ekOpers, Steps: stepNodes(*v)}
+		}
+	case operSet:
+		{
+			return &expNode{Kind: ekOpers, Steps: stepNodes(v)}
+		}
+	}
+	panic(dmpText("MarshalJSON", "json_unsupported", exp))
+}
+
+func operNode(exp *operExp, symbol string) *expNode {
+	ret := nameNode(ekOper, exp.names, exp.nameId)
+	ret.Oper = symbol
+	ret.Args = argNodes(exp.value)
+	return ret
+}
+
+func stepNodes(steps []OperExp) []*expNode {
+	ret := make([]*expNode, len(steps))
+	for i, step := range steps {
+		ret[i] = toNode(step)
+	}
+	return ret
+}
+
+func marshalExp(exp interface{}) (ret []byte, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = errors.New(fmt.Sprintf("%v", e))
+		}
+	}()
+	return json.Marshal(toNode(exp))
+}
+
+func (this *constExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *idenExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *frmlExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *negExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *bitNotExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *funcExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *ifFuncExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *boolExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *truthExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *compiledFrml) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *compiledCond) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *condExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *logicExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *notExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *inExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *betweenExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *condRefExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *incOperExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *decOperExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *mulOperExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *divOperExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *andOperExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *orOperExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *setOperExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *returnExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *ifReturnExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this operSet) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+func (this *procExp) MarshalJSON() ([]byte, error) {
+	return marshalExp(this)
+}
+
+type jsonDecoder struct {
+	names INames
+}
+
+func (this *jsonDecoder) doError(code string, args ...any) {
+	panic(dmpText("UnmarshalJSON", code, args...))
+}
+
+func (this *jsonDecoder) checkArgs(node *expNode, count int) {
+	if len(node.Args) != count {
+		this.doError("json_arg_count", node.Kind, count, len(node.Args))
+	}
+}
+
+// 按名字取ID，名字为空时按ID
+func (this *jsonDecoder) nameId(node *expNode) uint32 {
+	if node.Name != "" {
+		id := this.names.GetIdByName(node.Name)
+		if id == 0 {
+			this.doError("invalid_name", node.Name)
+		}
+		return id
+	}
+	if (node.Id == 0) || (this.names.GetNameById(node.Id) == "") {
+		this.doError("invalid_data_id", node.Id)
+	}
+	return node.Id
+}
+
+func (this *jsonDecoder) frml(node *expNode) FrmlExp {
+	return asFrml(this.exp(node))
+}
+
+func (this *jsonDecoder) cond(node *expNode) CondExp {
+	return asCond(this.exp(node))
+}
+
+func (this *jsonDecoder) frmls(nodes []*expNode) []FrmlExp {
+	ret := make([]FrmlExp, len(nodes))
+	for i, node := range nodes {
+		ret[i] = this.frml(node)
+	}
+	return ret
+}
+
+func (this *jsonDecoder) exp(node *expNode) anyExp {
+	if node == nil {
+		this.doError("json_missing_node")
+	}
+
+	switch node.Kind {
+	case ekNum:
+		{
+			if node.Value == nil {
+				this.doError("json_missing_value")
+			}
+			return &constExp{value: float64(*node.Value)}
+		}
+	case ekName:
+		{
+			id := this.nameId(node)
+			return &idenExp{names: this.names, id: id, name: this.names.GetNameById(id)}
+		}
+	case ekBinary:
+		{
+			this.checkArgs(node, 2)
+			ret := newFrmlExpOf(node.Oper, this.names, this.frml(node.Args[0]), this.frml(node.Args[1]))
+			if ret == nil {
+				this.doError("invalid_operator", node.Oper)
+			}
+			return ret
+		}
+	case ekNeg:
+		{
+			this.checkArgs(node, 1)
+			return &negExp{value: this.frml(node.Args[0])}
+		}
+	case ekBitNot:
+		{
+			this.checkArgs(node, 1)
+			return &bitNotExp{value: this.frml(node.Args[0])}
+		}
+	case ekFunc:
+		{
+			return this.funcExp(node)
+		}
+	case ekIf:
+		{
+			this.checkArgs(node, 2)
+			ret := &ifFuncExp{}
+			ret.name = "If"
+			ret.cond = this.cond(node.Cond)
+			ret.params = this.frmls(node.Args)
+			return ret
+		}
+	case ekCompare:
+		{
+			this.checkArgs(node, 2)
+			ret := newCompExpOf(node.Oper, this.names, this.frml(node.Args[0]), this.frml(node.Args[1]))
+			if ret == nil {
+				this.doError("invalid_comparator", node.Oper)
+			}
+			ret.(compExp).getCondExp().tol = node.Tol
+			return ret
+		}
+	case ekAnd, ekOr:
+		{
+			this.checkArgs(node, 2)
+			logic := "&&"
+			if node.Kind == ekOr {
+				logic = "||"
+			}
+			return newLogicExpOf(logic, this.names, this.cond(node.Args[0]), this.cond(node.Args[1]))
+		}
+	case ekNot:
+		{
+			this.checkArgs(node, 1)
+			return &notExp{names: this.names, cond: this.cond(node.Args[0])}
+		}
+	case ekIn:
+		{
+			if len(node.Args) < 2 {
+				this.doError("json_in_missing_set")
+			}
+			args := this.frmls(node.Args)
+			return &inExp{names: this.names, not: node.Not, left: args[0], items: args[1:], tol: node.Tol}
+		}
+	case ekBetween:
+		{
+			this.checkArgs(node, 3)
+			args := this.frmls(node.Args)
+			return &betweenExp{names: this.names, left: args[0], low: args[1], high: args[2], tol: node.Tol}
+		}
+	case ekRef:
+		{
+			named := condOfName[node.Name]
+			if named == nil {
+				this.doError("undefined_cond", node.Name)
+			}
+			return newCondRefExp(named, this.names)
+		}
+	}
+
+	this.doError("json_expect_exp", node.Kind)
+	return nil
+}
+
+// 按注册名还原函数，自定义解析的函数（如Random）按参数的名字表达式解析
+func (this *jsonDecoder) funcExp(node *expNode) FrmlExp {
+	fp := getFuncParser(node.Name)
+	if fp == nil {
+		this.doError("undefined_func", node.Name)
+	}
+
+	var ret FrmlExp
+	params := this.frmls(node.Args)
+	if p, ok := fp.(*funcParser); ok {
+		if len(params) != p.pcount {
+			this.doError("func_param_count", p.name, p.pcount, len(params))
+		}
+		ret = &funcExp{name: p.name, params: params, exec: p.exec, pure: p.pure}
+	} else {
+		strs := make([]string, len(params))
+		for i, param := range params {
+			strs[i] = param.NameExp()
+		}
+		ret = this.doParseFunc(fp, strs)
+	}
+	if param, name := decimalParamOf(ret); param != nil {
+		this.doError("decimal_float_func", param.NameExp(), name, param.NameExp())
+	}
+	if scale, bad := badDecimalScale(ret); bad {
+		this.doError("decimal_scale", maxDecimalScale, scale)
+	}
+	return ret
+}
+
+// 自定义解析的函数参数有误时按解析错误的消息报告
+func (this *jsonDecoder) doParseFunc(fp FuncParser, params []string) FrmlExp {
+	defer func() {
+		if e := recover(); e != nil {
+			if perr, ok := e.(*ParseError); ok {
+				this.doError(perr.Key, perr.Args...)
+			}
+			panic(e)
+		}
+	}()
+	return fp.doParse(this.names, params)
+}
+
+func (this *jsonDecoder) oper(node *expNode) OperExp {
+	if node == nil {
+		this.doError("json_missing_oper")
+	}
+
+	if node.Kind == ekReturn {
+		this.checkArgs(node, 1)
+		if node.Cond == nil {
+			return &returnExp{names: this.names, value: this.frml(node.Args[0])}
+		}
+		return &ifReturnExp{names: this.names, cond: this.cond(node.Cond), value: this.frml(node.Args[0])}
+	}
+	if node.Kind != ekOper {
+		this.doError("json_expect_oper", node.Kind)
+	}
+
+	this.checkArgs(node, 1)
+	var ret OperExp
+	var base *operExp
+	switch node.Oper {
+	case "+=":
+		{
+			v := &incOperExp{}
+			ret, base = v, &v.operExp
+		}
+	case "-=":
+		{
+			v := &decOperExp{}
+			ret, base = v, &v.operExp
+		}
+	case "*=":
+		{
+			v := &mulOperExp{}
+			ret, base = v, &v.operExp
+		}
+	case "/=":
+		{
+			v := &divOperExp{}
+			ret, base = v, &v.operExp
+		}
+	case "&=":
+		{
+			v := &andOperExp{}
+			ret, base = v, &v.operExp
+		}
+	case "|=":
+		{
+			v := &orOperExp{}
+			ret, base = v, &v.operExp
+		}
+	case "=", ":":
+		{
+			v := &setOperExp{}
+			ret, base = v, &v.operExp
+		}
+	default:
+		{
+			this.doError("invalid_operator", node.Oper)
+		}
+	}
+	base.names = this.names
+	base.nameId = this.nameId(node)
+	base.value = this.frml(node.Args[0])
+	return ret
+}
+
+func (this *jsonDecoder) steps(node *expNode, kind string) operSet {
+	if node.Kind != kind {
+		this.doError("json_expect_kind", kind, node.Kind)
+	}
+	if len(node.Steps) == 0 {
+		this.doError("empty_oper")
+	}
+	ret := make(operSet, len(node.Steps))
+	for i, step := range node.Steps {
+		ret[i] = this.oper(step)
+	}
+	return ret
+}
+
+func unmarshalNode(data []byte) *expNode {
+	ret := &expNode{}
+	if err := json.Unmarshal(data, ret); err != nil {
+		panic(dmpText("UnmarshalJSON", "json_decode", err))
+	}
+	return ret
+}
+
+func recoverJSON(err *error) {
+	if e := recover(); e != nil {
+		*err = errors.New(fmt.Sprintf("%v", e))
+	}
+}
+
+func UnmarshalFrmlByNames(data []byte, names INames) (ret FrmlExp, err error) {
+	defer recoverJSON(&err)
+	decoder := &jsonDecoder{names: names}
+	return decoder.frml(unmarshalNode(data)), nil
+}
+
+func UnmarshalFrml(data []byte) (FrmlExp, error) {
+	return UnmarshalFrmlByNames(data, Names)
+}
+
+func UnmarshalCondByNames(data []byte, names INames) (ret CondExp, err error) {
+	defer recoverJSON(&err)
+	decoder := &jsonDecoder{names: names}
+	return decoder.cond(unmarshalNode(data)), nil
+}
+
+func UnmarshalCond(data []byte) (CondExp, error) {
+	return UnmarshalCondByNames(data, Names)
+}
+
+func UnmarshalOper(data []byte) (ret OperSet, err error) {
+	defer recoverJSON(&err)
+	decoder := &jsonDecoder{names: Names}
+	opers := decoder.steps(unmarshalNode(data), ekOpers)
+	return &opers, nil
+}
+
+// 流程的临时变量按名字重新注册
+func UnmarshalProc(data []byte) (ret ProcExp, err error) {
+	defer recoverJSON(&err)
+	nms := newProcNames()
+	decoder := &jsonDecoder{names: nms}
+	proc := &procExp{operSet: decoder.steps(unmarshalNode(data), ekProc)}
+	proc.store = &Storehouse{}
+	proc.store.init(nil, nil)
+	proc.setNames(nms)
+	return proc, nil
+}
+
+// 可嵌入结构体参与JSON编解码的表达式容器
+
+type FrmlJSON struct {
+	FrmlExp
+}
+
+func (this FrmlJSON) MarshalJSON() ([]byte, error) {
+	return marshalExp(this.FrmlExp)
+}
+
+func (this *FrmlJSON) UnmarshalJSON(data []byte) (err error) {
+	this.FrmlExp, err = UnmarshalFrml(data)
+	return
+}
+
+type CondJSON struct {
+	CondExp
+}
+
+func (this CondJSON) MarshalJSON() ([]byte, error) {
+	return marshalExp(this.CondExp)
+}
+
+func (this *CondJSON) UnmarshalJSON(data []byte) (err error) {
+	this.CondExp, err = UnmarshalCond(data)
+	return
+}
+
+type OperJSON struct {
+	OperSet
+}
+
+func (this OperJSON) MarshalJSON() ([]byte, error) {
+	return marshalExp(this.OperSet)
+}
+
+func (this *OperJSON) UnmarshalJSON(data []byte) (err error) {
+	this.OperSet, err = UnmarshalOper(data)
+	return
+}
+
+type ProcJSON struct {
+	ProcExp
+}
+
+func (this ProcJSON) MarshalJSON() ([]byte, error) {
+	return marshalExp(this.ProcExp)
+}
+
+func (this *ProcJSON) UnmarshalJSON(data []byte) (err error) {
+	this.ProcExp, err = UnmarshalProc(data)
+	return
+}
@@ -0,0 +1,188 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestFrmlJSONRoundTrip(t *testing.T) {
+	store := testCondStore(2, 10, 0)
+	if _, ok := condOfName["测试JSON条件"]; !ok {
+		if err := RegisterCond("测试JSON条件", "条甲>1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []string{
+		"条乙*(1+(条甲>0)*0.5)",
+		"-条甲^2+Div(条乙, 3)*(条甲&3)~(1<<条甲)",
+		"If(@测试JSON条件 && 条乙 in (1,10), Max(条甲, 条乙), Sin(PI/2))",
+		"Decimal(条乙/3, 2)+条丙",
+		"(条甲 between 1 and 3)+(条乙 not in (2,条甲))",
+	}
+	for _, str := range tests {
+		t.Run(str, func(t *testing.T) {
+			frml, err := ParseFrmlExp(str)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := json.Marshal(frml)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := UnmarshalFrml(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.NameExp() != frml.NameExp() {
+				t.Fatalf("%s，应为%s", got.NameExp(), frml.NameExp())
+			}
+			if got.Float64(store) != frml.Float64(store) {
+				t.Fatalf("%v，应为%v", got.Float64(store), frml.Float64(store))
+			}
+			again, _ := json.Marshal(got)
+			if !bytes.Equal(again, data) {
+				t.Fatalf("再次编码不一致：\n%s\n%s", again, data)
+			}
+
+			// 编译后的表达式按源表达式编码
+			compiled, _ := json.Marshal(CompileFrml(frml))
+			if !bytes.Equal(compiled, data) {
+				t.Fatalf("编译后编码不一致：%s", compiled)
+			}
+		})
+	}
+}
+
+func TestCondJSONRoundTrip(t *testing.T) {
+	store := testCondStore(2, 10, 0)
+
+	tests := []string{
+		"条甲>1 || 条乙>1 && !条丙<3",
+		"条甲 in (1,条乙) && 条乙 between 条甲 and 20",
+		"条甲 <> 2 || 条丙",
+	}
+	for _, str := range tests {
+		t.Run(str, func(t *testing.T) {
+			cond, err := ParseCondExp(str)
+			if err != nil {
+				t.Fatal(err)
+			}
+			SetCondTolerance(cond, Tolerance{Mode: CM_REL, Epsilon: 1e-6})
+			data, err := json.Marshal(cond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := UnmarshalCond(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got.NameExp() != cond.NameExp()) || (got.Check(store) != cond.Check(store)) {
+				t.Fatalf("%s，应为%s", got.NameExp(), cond.NameExp())
+			}
+			again, _ := json.Marshal(got)
+			if !bytes.Equal(again, data) {
+				t.Fatalf("再次编码不一致：\n%s\n%s", again, data)
+			}
+		})
+	}
+}
+
+func TestOperProcJSONRoundTrip(t *testing.T) {
+	opers, err := ParseOperExp("条丙 = 条甲*2\n条丙 += 1\n条乙 |= 4\n条乙 &= ~2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(opers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotOpers, err := UnmarshalOper(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotOpers.NameExp() != opers.NameExp() {
+		t.Fatalf("%s，应为%s", gotOpers.NameExp(), opers.NameExp())
+	}
+	want, got := testCondStore(2, 10, 0), testCondStore(2, 10, 0)
+	WorkStat.ExecOper(want, opers, false)
+	WorkStat.ExecOper(got, gotOpers, false)
+	if !bytes.Equal(snapshotBytes(t, got), snapshotBytes(t, want)) {
+		t.Fatal("运算结果不一致")
+	}
+
+	proc, err := ParseProcExp("JSON临时 = 条甲*2\nreturn(JSON临时>30, JSON临时)\n条乙 += Div(JSON临时, 3)\nreturn(条乙)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = json.Marshal(proc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotProc, err := UnmarshalProc(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotProc.NameExp() != proc.NameExp() {
+		t.Fatalf("%s，应为%s", gotProc.NameExp(), proc.NameExp())
+	}
+	for _, a := range []float64{2, 20} {
+		want, got := testCondStore(a, 10, 0), testCondStore(a, 10, 0)
+		if g, w := WorkStat.ExecProc(got, gotProc, false), WorkStat.ExecProc(want, proc, false); g != w {
+			t.Fatalf("条甲=%v：流程返回%v，应为%v", a, g, w)
+		}
+	}
+}
+
+func snapshotBytes(t *testing.T, store *Storehouse) []byte {
+	buf := &bytes.Buffer{}
+	if _, err := store.Snapshot().WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestJSONDecode(t *testing.T) {
+	id := testName("测试条件", "条甲")
+	store := testCondStore(2, 0, 0)
+
+	tests := []struct {
+		name string
+		data string
+		want float64
+		err  bool
+	}{
+		{"按名字", `{"kind":"name","name":"条甲"}`, 2, false},
+		{"按ID", `{"kind":"name","id":` + strconv.Itoa(int(id)) + `}`, 2, false},
+		{"非有限数值", `{"kind":"num","value":"+Inf"}`, math.Inf(1), false},
+		{"未注册的名字", `{"kind":"name","name":"未注册的JSON名字"}`, 0, true},
+		{"未注册的函数", `{"kind":"func","name":"未注册的函数","args":[]}`, 0, true},
+		{"无效运算符", `{"kind":"binary","oper":"//","args":[{"kind":"num","value":1},{"kind":"num","value":2}]}`, 0, true},
+		{"无效JSON", `{"kind":`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frml, err := UnmarshalFrml([]byte(tt.data))
+			if (err != nil) != tt.err {
+				t.Fatalf("错误：%v", err)
+			}
+			if (err == nil) && (frml.Float64(store) != tt.want) {
+				t.Fatalf("%v，应为%v", frml.Float64(store), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+// 条件检查解释(Condition Check Explanation)
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// 条件检查的解释树：记录每个比较的各项值和结果，以及逻辑运算的短路情况
+type CondTrace struct {
+	// 名字表达式
+	Exp string `json:"exp"`
+	// 节点类别：compare、and、or、not、in、between、value、ref（命名条件引用）、
+	// custom（未实现CondExplainer的自定义条件）
+	Kind string `json:"kind,omitempty"`
+	// 比较符或逻辑符
+	Oper string `json:"oper,omitempty"`
+	// 参与比较的各项值，依次为：左值、右值（集合各项、上下限）
+	Values []string `json:"values,omitempty"`
+	Result bool     `json:"result"`
+	// 因逻辑短路而未检查
+	Skipped  bool         `json:"skipped,omitempty"`
+	Children []*CondTrace `json:"children,omitempty"`
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func skippedTrace(cond CondExp) *CondTrace {
+	return &CondTrace{Exp: cond.NameExp(), Skipped: true}
+}
+
+// 导致结果为target的第一个叶子
+func (this *CondTrace) cause(target bool) *CondTrace {
+	if this.Skipped {
+		return nil
+	}
+	if len(this.Children) == 0 {
+		if this.Result == target {
+			return this
+		}
+		return nil
+	}
+	if this.Kind == "not" {
+		return this.Children[0].cause(!target)
+	}
+	for _, child := range this.Children {
+		if child.Skipped || (child.Result != target) {
+			continue
+		}
+		if ret := child.cause(target); ret != nil {
+			return ret
+		}
+	}
+	return nil
+}
+
+// 第一个导致条件不成立的比较，条件成立时返回nil
+func (this *CondTrace) FirstFailed() *CondTrace {
+	if this.Result {
+		return nil
+	}
+	return this.cause(false)
+}
+
+func (this *CondTrace) detail() string {
+	switch this.Kind {
+	case "compare":
+		{
+			return this.Values[0] + " " + this.Oper + " " + this.Values[1]
+		}
+	case "in":
+		{
+			return this.Values[0] + " " + this.Oper + " (" + strings.Join(this.Values[1:], ",") + ")"
+		}
+	case "between":
+		{
+			return this.Values[0] + " between " + this.Values[1] + " and " + this.Values[2]
+		}
+	case "value":
+		{
+			return this.Values[0]
+		}
+	}
+	return ""
+}
+
+// 表达式及其取值说明
+func (this *CondTrace) withDetail() string {
+	if detail := this.detail(); detail != "" {
+		return Message("explain_detail", this.Exp, detail)
+	}
+	return this.Exp
+}
+
+func (this *CondTrace) writeText(sb *strings.Builder, indent string) {
+	sb.WriteString(indent)
+	if this.Skipped {
+		sb.WriteString("- " + Message("explain_skipped", this.Exp) + "\n")
+		return
+	}
+	if this.Result {
+		sb.WriteString("✓ ")
+	} else {
+		sb.WriteString("✗ ")
+	}
+	sb.WriteString(this.withDetail() + "\n")
+	for _, child := range this.Children {
+		child.writeText(sb, indent+"  ")
+	}
+}
+
+// 文本形式，每行一个节点，子节点缩进两格，条件不成立时最后一行为第一个导致不成立的比较
+func (this *CondTrace) Text() string {
+	sb := &strings.Builder{}
+	this.writeText(sb, "")
+	if failed := this.FirstFailed(); failed != nil {
+		sb.WriteString(Message("explain_failed", failed.withDetail()) + "\n")
+	}
+	return sb.String()
+}
+
+func (this *CondTrace) String() string {
+	return this.Text()
+}
+
+// JSON形式，根节点附带firstFailed（第一个导致不成立的比较的名字表达式）
+func (this *CondTrace) JSON() ([]byte, error) {
+	root := struct {
+		*CondTrace
+		FirstFailed string `json:"firstFailed,omitempty"`
+	}{CondTrace: this}
+	if failed := this.FirstFailed(); failed != nil {
+		root.FirstFailed = failed.Exp
+	}
+
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(root); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+func explainCond(cond CondExp, store *Storehouse) *CondTrace {
+	if explainer, ok := cond.(CondExplainer); ok {
+		return explainer.ExplainCheck(store)
+	}
+	return &CondTrace{Exp: cond.NameExp(), Kind: "custom", Result: cond.Check(store)}
+}
+
+func (this *Storehouse) ExplainCheck(cond CondExp) *CondTrace {
+	return explainCond(cond, this)
+}
+
+func explainComp(exp compExp, store *Storehouse) *CondTrace {
+	base := exp.getCondExp()
+	left := base.left.Float64(store)
+	right := base.right.Float64(store)
+	return &CondTrace{
+		Exp:    exp.NameExp(),
+		Kind:   "compare",
+		Oper:   base.oper,
+		Values: []string{formatValue(left), formatValue(right)},
+		Result: exp.compare(left, right),
+	}
+}
+
+func (this *compExpG) ExplainCheck(store *Storehouse) *CondTrace {
+	return explainComp(this, store)
+}
+
+func (this *compExpNG) ExplainCheck(store *Storehouse) *CondTrace {
+	return explainComp(this, store)
+}
+
+func (this *compExpL) ExplainCheck(store *Storehouse) *CondTrace {
+	return explainComp(this, store)
+}
+
+func (this *compExpNL) ExplainCheck(store *Storehouse) *CondTrace {
+	return explainComp(this, store)
+}
+
+func (this *compExpE) ExplainCheck(store *Storehouse) *CondTrace {
+	return explainComp(this, store)
+}
+
+func (this *compExpNE) ExplainCheck(store *Storehouse) *CondTrace {
+	return explainComp(this, store)
+}
+
+func (this *logicExpAnd) ExplainCheck(store *Storehouse) *CondTrace {
+	ret := &CondTrace{Exp: this.NameExp(), Kind: "and", Oper: this.logic}
+	left := explainCond(this.left, store)
+	if left.Result {
+		right := explainCond(this.right, store)
+		ret.Result = right.Result
+		ret.Children = []*CondTrace{left, right}
+	} else {
+		ret.Children = []*CondTrace{left, skippedTrace(this.right)}
+	}
+	return ret
+}
+
+func (this *logicExpOr) ExplainCheck(store *Storehouse) *CondTrace {
+	ret := &CondTrace{Exp: this.NameExp(), Kind: "or", Oper: this.logic}
+	left := explainCond(this.left, store)
+	if !left.Result {
+		right := explainCond(this.right, store)
+		ret.Result = right.Result
+		ret.Children = []*CondTrace{left, right}
+	} else {
+		ret.Result = true
+		ret.Children = []*CondTrace{left, skippedTrace(this.right)}
+	}
+	return ret
+}
+
+func (this *inExp) ExplainCheck(store *Storehouse) *CondTrace {
+	value := this.left.Float64(store)
+	ret := &CondTrace{
+		Exp:    this.NameExp(),
+		Kind:   "in",
+		Oper:   strings.TrimSpace(this.symbol()),
+		Values: []string{formatValue(value)},
+		Result: this.not,
+	}
+	found := false
+	tol := this.tolerance()
+	for _, item := range this.items {
+		v := item.Float64(store)
+		ret.Values = append(ret.Values, formatValue(v))
+		if !found && tol.equal(v, value) {
+			found = true
+			ret.Result = !this.not
+		}
+	}
+	return ret
+}
+
+func (this *betweenExp) ExplainCheck(store *Storehouse) *CondTrace {
+	value := this.left.Float64(store)
+	low := this.low.Float64(store)
+	high := this.high.Float64(store)
+	return &CondTrace{
+		Exp:    this.NameExp(),
+		Kind:   "between",
+		Oper:   "between",
+		Values: []string{formatValue(value), formatValue(low), formatValue(high)},
+		Result: this.compare(value, low, high),
+	}
+}
+
+func (this *notExp) ExplainCheck(store *Storehouse) *CondTrace {
+	child := explainCond(this.cond, store)
+	return &CondTrace{
+		Exp:      this.NameExp(),
+		Kind:     "not",
+		Oper:     "!",
+		Result:   !child.Result,
+		Children: []*CondTrace{child},
+	}
+}
+
+func (this *truthExp) ExplainCheck(store *Storehouse) *CondTrace {
+	value := this.frml.Float64(store)
+	return &CondTrace{
+		Exp:    this.NameExp(),
+		Kind:   "value",
+		Values: []string{formatValue(value)},
+		Result: value != 0,
+	}
+}
+
+func (this *condRefExp) ExplainCheck(store *Storehouse) *CondTrace {
+	child := explainCond(this.get(), store)
+	return &CondTrace{
+		Exp:      this.NameExp(),
+		Kind:     "ref",
+		Result:   child.Result,
+		Children: []*CondTrace{child},
+	}
+}
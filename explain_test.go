@@ -0,0 +1,122 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"testing"
+)
+
+// 未实现CondExplainer的自定义条件
+type testCustomCond struct {
+	result bool
+}
+
+func (this *testCustomCond) Check(store *Storehouse) bool      { return this.result }
+func (this *testCustomCond) NameExp() string                   { return "自定义" }
+func (this *testCustomCond) ValueExp(store *Storehouse) string { return "自定义" }
+func (this *testCustomCond) EachId(fn func(id uint32))         {}
+
+func TestExplainCheck(t *testing.T) {
+	store := testCondStore(2, 10, 0)
+
+	tests := []struct {
+		name        string
+		exp         string
+		result      bool
+		firstFailed string
+		text        string
+	}{
+		{"比较成立", "条甲>1", true, "", "✓ (条甲>1)：2 > 1\n"},
+		{"与短路", "条甲>5 && 条乙>1", false, "(条甲>5)",
+			"✗ ((条甲>5)&&(条乙>1))\n  ✗ (条甲>5)：2 > 5\n  - (条乙>1)：短路未检查\n不成立原因：(条甲>5)：2 > 5\n"},
+		{"或", "条甲>5 || 条丙 in (1,2)", false, "(条甲>5)",
+			"✗ ((条甲>5)||(条丙 in (1,2)))\n  ✗ (条甲>5)：2 > 5\n  ✗ (条丙 in (1,2))：0 in (1,2)\n不成立原因：(条甲>5)：2 > 5\n"},
+		{"取反", "!(条乙 between 1 and 20)", false, "(条乙 between 1 and 20)",
+			"✗ !(条乙 between 1 and 20)\n  ✓ (条乙 between 1 and 20)：10 between 1 and 20\n不成立原因：(条乙 between 1 and 20)：10 between 1 and 20\n"},
+		{"数值", "条丙", false, "条丙", "✗ 条丙：0\n不成立原因：条丙：0\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond, err := ParseCondExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trace := store.ExplainCheck(cond)
+			if trace.Result != tt.result || trace.Result != cond.Check(store) {
+				t.Fatalf("结果%v，应为%v", trace.Result, tt.result)
+			}
+			failed := ""
+			if f := trace.FirstFailed(); f != nil {
+				failed = f.Exp
+			}
+			if failed != tt.firstFailed {
+				t.Fatalf("不成立原因：%s，应为%s", failed, tt.firstFailed)
+			}
+			if trace.Text() != tt.text {
+				t.Fatalf("文本：\n%s应为：\n%s", trace.Text(), tt.text)
+			}
+		})
+	}
+}
+
+func TestExplainCustomCond(t *testing.T) {
+	store := testCondStore(0, 0, 0)
+	var cond CondExp = &testCustomCond{result: true}
+	if _, ok := cond.(CondExplainer); ok {
+		t.Fatal("测试条件不应实现CondExplainer")
+	}
+	trace := store.ExplainCheck(cond)
+	if (trace.Kind != "custom") || !trace.Result || (trace.Exp != "自定义") {
+		t.Fatalf("%+v", trace)
+	}
+	if trace.FirstFailed() != nil {
+		t.Fatal("成立时不应有不成立原因")
+	}
+
+	cond = &testCustomCond{result: false}
+	if failed := store.ExplainCheck(cond).FirstFailed(); (failed == nil) || (failed.Exp != "自定义") {
+		t.Fatalf("不成立原因：%+v", failed)
+	}
+}
+
+func TestExplainJSON(t *testing.T) {
+	store := testCondStore(2, 10, 0)
+	cond, err := ParseCondExp("条甲>5 && 条乙>1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := store.ExplainCheck(cond).JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"exp":"((条甲>5)&&(条乙>1))","kind":"and","oper":"&&","result":false,` +
+		`"children":[{"exp":"(条甲>5)","kind":"compare","oper":">","values":["2","5"],"result":false},` +
+		`{"exp":"(条乙>1)","result":false,"skipped":true}],"firstFailed":"(条甲>5)"}`
+	if string(data) != want {
+		t.Fatalf("%s，应为%s", data, want)
+	}
+}
+
+// 解释文本按引擎语言生成
+func TestExplainTextLang(t *testing.T) {
+	store := testCondStore(2, 10, 0)
+	cond, err := ParseCondExp("条甲>5 && 条乙>1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetLang(LANG_EN)
+	defer SetLang(LANG_ZH)
+	want := "✗ ((条甲>5)&&(条乙>1))\n  ✗ (条甲>5): 2 > 5\n  - (条乙>1): not checked (short-circuited)\nfailed because: (条甲>5): 2 > 5\n"
+	if got := store.ExplainCheck(cond).Text(); got != want {
+		t.Fatalf("文本：\n%s应为：\n%s", got, want)
+	}
+}
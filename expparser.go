@@ -0,0 +1,827 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+// 表达式解析器(Expression Parser)：公式与条件共用一套语法
+//
+// 优先级由低到高：
+//   or      := and {"||" and}
+//   and     := not {"&&" not}
+//   not     := "!" not | compare
+//   compare := bitor [cmp bitor | ["not"] "in" "(" bitor {"," bitor} ")" | "between" bitor "and" bitor]
+//   bitor   := bitxor {"|" bitxor}
+//   bitxor  := bitand {"~" bitand}
+//   bitand  := shift {"&" shift}
+//   shift   := sum {("<<"|">>") sum}
+//   sum     := product {("+"|"-") product}
+//   product := unary {("*"|"/"|"%") unary}
+//   unary   := ("+"|"-"|"~") unary | power
+//   power   := value ["^" unary]（右结合：2^3^2 = 2^(3^2)，-2^2 = -(2^2)）
+//   value   := 数值 | 数据名 | "@"命名条件 | 函数名 "(" 参数 ")" | "(" or ")"
+//
+// 条件用作数值时成立为1、不成立为0，数值用作条件时非0为成立；
+// 单个“&”“|”为按位与、或，“&&”“||”为逻辑与、或；位运算及整除按int64运算
+
+import (
+	"errors"
+	"strconv"
+)
+
+// 公式与条件的公共部分
+type anyExp interface {
+	NameExp() string
+	ValueExp(store *Storehouse) string
+	EachId(fn func(id uint32))
+}
+
+// 条件作为数值：成立为1，不成立为0
+type boolExp struct {
+	cond CondExp
+}
+
+func (this *boolExp) EachId(fn func(id uint32)) {
+	this.cond.EachId(fn)
+}
+
+// 取反条件作为运算项时加括号，避免与后面的运算结合
+func (this *boolExp) NameExp() string {
+	if _, ok := this.cond.(*notExp); ok {
+		return "(" + this.cond.NameExp() + ")"
+	}
+	return this.cond.NameExp()
+}
+
+func (this *boolExp) ValueExp(store *Storehouse) string {
+	if _, ok := this.cond.(*notExp); ok {
+		return "(" + this.cond.ValueExp(store) + ")"
+	}
+	return this.cond.ValueExp(store)
+}
+
+func (this *boolExp) Float64(store *Storehouse) float64 {
+	if this.cond.Check(store) {
+		return 1
+	}
+	return 0
+}
+
+// 数值作为条件：非0为成立
+type truthExp struct {
+	frml FrmlExp
+}
+
+func (this *truthExp) EachId(fn func(id uint32)) {
+	this.frml.EachId(fn)
+}
+
+func (this *truthExp) NameExp() string {
+	return this.frml.NameExp()
+}
+
+func (this *truthExp) ValueExp(store *Storehouse) string {
+	return this.frml.ValueExp(store)
+}
+
+func (this *truthExp) Check(store *Storehouse) bool {
+	return this.frml.Float64(store) != 0
+}
+
+func asFrml(exp anyExp) FrmlExp {
+	switch v := exp.(type) {
+	case *truthExp:
+		return v.frml
+	case FrmlExp:
+		return v
+	case CondExp:
+		return &boolExp{cond: v}
+	}
+	return nil
+}
+
+func asCond(exp anyExp) CondExp {
+	switch v := exp.(type) {
+	case *boolExp:
+		return v.cond
+	case CondExp:
+		return v
+	case FrmlExp:
+		return &truthExp{frml: v}
+	}
+	return nil
+}
+
+// 遍历直接子表达式，命名条件引用不展开
+func eachSubExp(exp anyExp, fn func(sub anyExp)) {
+	switch v := exp.(type) {
+	case *boolExp:
+		{
+			fn(v.cond)
+		}
+	case *truthExp:
+		{
+			fn(v.frml)
+		}
+	case *notExp:
+		{
+			fn(v.cond)
+		}
+	case *negExp:
+		{
+			fn(v.value)
+		}
+	case *bitNotExp:
+		{
+			fn(v.value)
+		}
+	case *inExp:
+		{
+			fn(v.left)
+			for _, item := range v.items {
+				fn(item)
+			}
+		}
+	case *betweenExp:
+		{
+			fn(v.left)
+			fn(v.low)
+			fn(v.high)
+		}
+	case *ifFuncExp:
+		{
+			fn(v.cond)
+			for _, param := range v.params {
+				fn(param)
+			}
+		}
+	case interface{ getCondExp() *condExp }:
+		{
+			base := v.getCondExp()
+			fn(base.left)
+			fn(base.right)
+		}
+	case interface{ getLogicExp() *logicExp }:
+		{
+			base := v.getLogicExp()
+			fn(base.left)
+			fn(base.right)
+		}
+	case interface{ getFrmlExp() *frmlExp }:
+		{
+			base := v.getFrmlExp()
+			fn(base.left)
+			fn(base.right)
+		}
+	case interface{ getFuncExp() *funcExp }:
+		{
+			for _, param := range v.getFuncExp().params {
+				fn(param)
+			}
+		}
+	}
+}
+
+type exprParser struct {
+	parser
+	// 引用的命名条件
+	refs []*namedCond
+}
+
+// 运算符及分隔符，数据名遇到这些字符或空白即结束
+func (this *exprParser) isSymbol(char rune) bool {
+	switch char {
+	case '+', '-', '*', '/', '%', '^', '~', '(', ')', '<', '>', '=', '!', '&', '|', ':', ',':
+		{
+			return true
+		}
+	}
+	return (char <= 32) || (char == 127) || (char == paramSeparator)
+}
+
+func (this *exprParser) peek() rune {
+	this.pass()
+	if this.index >= this.end {
+		return 0
+	}
+	return this.exp[this.index]
+}
+
+// 当前是否为两个相同字符组成的符号，如：&&、||、<<、>>、//
+func (this *exprParser) isDouble(char rune) bool {
+	return (this.peek() == char) && (this.index+1 < this.end) && (this.exp[this.index+1] == char)
+}
+
+// 当前是否为单个字符的符号（其后不是相同字符或等号）
+func (this *exprParser) isSingle(char rune) bool {
+	if this.peek() != char {
+		return false
+	}
+	if this.index+1 >= this.end {
+		return true
+	}
+	next := this.exp[this.index+1]
+	return (next != char) && (next != '=')
+}
+
+func (this *exprParser) isLogic(char rune) bool {
+	if !this.isDouble(char) {
+		return false
+	}
+	this.index += 2
+	return true
+}
+
+// 优先级：! 高于 && 高于 ||，同级从左到右结合
+func (this *exprParser) parseOr() anyExp {
+	ret := this.parseAnd()
+	for this.isLogic('|') {
+		ret = this.newLogicExp("||", ret, this.parseAnd())
+	}
+	return ret
+}
+
+func (this *exprParser) parseAnd() anyExp {
+	ret := this.parseNot()
+	for this.isLogic('&') {
+		ret = this.newLogicExp("&&", ret, this.parseNot())
+	}
+	return ret
+}
+
+func (this *exprParser) newLogicExp(logic string, left, right anyExp) CondExp {
+	return newLogicExpOf(logic, this.names, asCond(left), asCond(right))
+}
+
+func newLogicExpOf(logic string, names INames, left, right CondExp) CondExp {
+	if logic == "&&" {
+		ret := &logicExpAnd{}
+		ret.logic = logic
+		ret.names = names
+		ret.left = left
+		ret.right = right
+		return ret
+	} else {
+		ret := &logicExpOr{}
+		ret.logic = logic
+		ret.names = names
+		ret.left = left
+		ret.right = right
+		return ret
+	}
+}
+
+func (this *exprParser) parseNot() anyExp {
+	if this.peek() != '!' {
+		return this.parseCompare()
+	}
+	if (this.index+1 < this.end) && (this.exp[this.index+1] == '=') {
+		this.doError("compare_missing_left", "!=")
+	}
+	this.index++
+	ret := &notExp{}
+	ret.names = this.names
+	ret.cond = asCond(this.parseNot())
+	return ret
+}
+
+func (this *exprParser) isCompChar(char rune) bool {
+	switch char {
+	case '>', '<', '=', '!':
+		{
+			return true
+		}
+	}
+	return false
+}
+
+func (this *exprParser) getCompSymbol() string {
+	this.pass()
+	start := this.index
+	for (this.index < this.end) && this.isCompChar(this.exp[this.index]) {
+		this.index++
+	}
+	return string(this.exp[start:this.index])
+}
+
+func (this *exprParser) parseCompare() anyExp {
+	left := this.parseBitOr()
+
+	this.pass()
+	if ok, next := this.isWord(this.index, "in"); ok {
+		this.index = next
+		return this.parseInExp(left, false)
+	}
+	if ok, next := this.isWord(this.index, "not"); ok {
+		this.index = next
+		this.pass()
+		ok, next = this.isWord(this.index, "in")
+		if !ok {
+			this.doError("not_without_in")
+		}
+		this.index = next
+		return this.parseInExp(left, true)
+	}
+	if ok, next := this.isWord(this.index, "between"); ok {
+		this.index = next
+		return this.parseBetweenExp(left)
+	}
+
+	start := this.index
+	symbol := this.getCompSymbol()
+	if symbol == "" {
+		return left
+	}
+	if this.index >= this.end {
+		this.doError("compare_missing_right", symbol)
+	}
+	ret := this.buildCompExp(start, symbol, asFrml(left), asFrml(this.parseBitOr()))
+
+	if this.isCompChar(this.peek()) {
+		this.doError("compare_chained")
+	}
+	return ret
+}
+
+func (this *exprParser) buildCompExp(start int, symbol string, left, right FrmlExp) CondExp {
+	ret := newCompExpOf(symbol, this.names, left, right)
+	if ret == nil {
+		this.index = start
+		this.doError("invalid_comparator", symbol)
+	}
+	return ret
+}
+
+// 按比较符构建比较表达式，无效比较符返回nil
+func newCompExpOf(symbol string, names INames, left, right FrmlExp) CondExp {
+	switch symbol {
+	case ">":
+		{
+			ret := &compExpG{}
+			ret.oper = symbol
+			ret.names = names
+			ret.left = left
+			ret.right = right
+			return ret
+		}
+	case "<=":
+		{
+			ret := &compExpNG{}
+			ret.oper = symbol
+			ret.names = names
+			ret.left = left
+			ret.right = right
+			return ret
+		}
+	case "<":
+		{
+			ret := &compExpL{}
+			ret.oper = symbol
+			ret.names = names
+			ret.left = left
+			ret.right = right
+			return ret
+		}
+	case ">=":
+		{
+			ret := &compExpNL{}
+			ret.oper = symbol
+			ret.names = names
+			ret.left = left
+			ret.right = right
+			return ret
+		}
+	case "=", "==":
+		{
+			ret := &compExpE{}
+			ret.oper = "="
+			ret.names = names
+			ret.left = left
+			ret.right = right
+			return ret
+		}
+	case "!=", "<>":
+		{
+			ret := &compExpNE{}
+			ret.oper = "!="
+			ret.names = names
+			ret.left = left
+			ret.right = right
+			return ret
+		}
+	}
+
+	return nil
+}
+
+func (this *exprParser) parseInExp(left anyExp, not bool) CondExp {
+	if this.peek() != '(' {
+		this.doError("in_missing_lparen")
+	}
+	open := this.index
+	this.index++
+
+	ret := &inExp{}
+	ret.names = this.names
+	ret.not = not
+	ret.left = asFrml(left)
+	if this.peek() == ')' {
+		this.doError("in_empty")
+	}
+	for {
+		ret.items = append(ret.items, asFrml(this.parseBitOr()))
+		char := this.peek()
+		if char == paramSeparator {
+			this.index++
+			continue
+		}
+		if char != ')' {
+			if this.index >= this.end {
+				this.index = open
+			}
+			this.doError("in_missing_rparen")
+		}
+		this.index++
+		return ret
+	}
+}
+
+func (this *exprParser) parseBetweenExp(left anyExp) CondExp {
+	ret := &betweenExp{}
+	ret.names = this.names
+	ret.left = asFrml(left)
+	ret.low = asFrml(this.parseBitOr())
+
+	this.pass()
+	ok, next := this.isWord(this.index, "and")
+	if !ok {
+		this.doError("between_missing_and")
+	}
+	this.index = next
+
+	ret.high = asFrml(this.parseBitOr())
+	return ret
+}
+
+func (this *exprParser) newFrmlExp(symbol string, left, right anyExp) FrmlExp {
+	return newFrmlExpOf(symbol, this.names, asFrml(left), asFrml(right))
+}
+
+// 按运算符构建二元公式，无效运算符返回nil
+func newFrmlExpOf(symbol string, names INames, left, right FrmlExp) FrmlExp {
+	var ret *frmlExp
+	var exp FrmlExp
+	switch symbol {
+	case "+":
+		{
+			v := &incExp{}
+			ret, exp = &v.frmlExp, v
+		}
+	case "-":
+		{
+			v := &decExp{}
+			ret, exp = &v.frmlExp, v
+		}
+	case "*":
+		{
+			v := &mulExp{}
+			ret, exp = &v.frmlExp, v
+		}
+	case "/":
+		{
+			v := &divExp{}
+			ret, exp = &v.frmlExp, v
+		}
+	case "%":
+		{
+			v := &modExp{}
+			ret, exp = &v.frmlExp, v
+		}
+	case "^":
+		{
+			v := &powExp{}
+			ret, exp = &v.frmlExp, v
+		}
+	case "&":
+		{
+			v := &bitAndExp{}
+			ret, exp = &v.frmlExp, v
+		}
+	case "|":
+		{
+			v := &bitOrExp{}
+			ret, exp = &v.frmlExp, v
+		}
+	case "~":
+		{
+			v := &bitXorExp{}
+			ret, exp = &v.frmlExp, v
+		}
+	case "<<":
+		{
+			v := &shlExp{}
+			ret, exp = &v.frmlExp, v
+		}
+	case ">>":
+		{
+			v := &shrExp{}
+			ret, exp = &v.frmlExp, v
+		}
+	case "Div":
+		{
+			v := &intDivExp{}
+			ret, exp = &v.frmlExp, v
+		}
+	default:
+		{
+			return nil
+		}
+	}
+	ret.oper = symbol
+	ret.names = names
+	ret.left = left
+	ret.right = right
+	if isDecimalOper(symbol) {
+		ret.dec = mergeDecimal(decimalOf(left), decimalOf(right))
+	}
+	return exp
+}
+
+func (this *exprParser) parseBitOr() anyExp {
+	ret := this.parseBitXor()
+	for this.isSingle('|') {
+		this.index++
+		ret = this.newFrmlExp("|", ret, this.parseBitXor())
+	}
+	return ret
+}
+
+func (this *exprParser) parseBitXor() anyExp {
+	ret := this.parseBitAnd()
+	for this.isSingle('~') {
+		this.index++
+		ret = this.newFrmlExp("~", ret, this.parseBitAnd())
+	}
+	return ret
+}
+
+func (this *exprParser) parseBitAnd() anyExp {
+	ret := this.parseShift()
+	for this.isSingle('&') {
+		this.index++
+		ret = this.newFrmlExp("&", ret, this.parseShift())
+	}
+	return ret
+}
+
+func (this *exprParser) parseShift() anyExp {
+	ret := this.parseSum()
+	for {
+		symbol := ""
+		if this.isDouble('<') {
+			symbol = "<<"
+		} else if this.isDouble('>') {
+			symbol = ">>"
+		} else {
+			return ret
+		}
+		this.index += 2
+		ret = this.newFrmlExp(symbol, ret, this.parseSum())
+	}
+}
+
+func (this *exprParser) parseSum() anyExp {
+	ret := this.parseProduct()
+	for {
+		char := this.peek()
+		if (char != '+') && (char != '-') {
+			return ret
+		}
+		this.index++
+		ret = this.newFrmlExp(string(char), ret, this.parseProduct())
+	}
+}
+
+func (this *exprParser) parseProduct() anyExp {
+	ret := this.parseUnary()
+	for {
+		char := this.peek()
+		if (char != '*') && (char != '/') && (char != '%') {
+			return ret
+		}
+		if this.isDouble('/') {
+			this.doError("double_slash")
+		}
+		this.index++
+		ret = this.newFrmlExp(string(char), ret, this.parseUnary())
+	}
+}
+
+func (this *exprParser) parseUnary() anyExp {
+	switch this.peek() {
+	case '+':
+		{
+			this.index++
+			return asFrml(this.parseUnary())
+		}
+	case '-':
+		{
+			this.index++
+			value := asFrml(this.parseUnary())
+			// 负数直接作为常量
+			if v, ok := value.(*constExp); ok {
+				return &constExp{value: -v.value}
+			}
+			return &negExp{value: value}
+		}
+	case '~':
+		{
+			this.index++
+			return &bitNotExp{value: asFrml(this.parseUnary())}
+		}
+	}
+	return this.parsePower()
+}
+
+func (this *exprParser) parsePower() anyExp {
+	ret := this.parseValue()
+	if this.peek() != '^' {
+		return ret
+	}
+	this.index++
+	return this.newFrmlExp("^", ret, this.parseUnary())
+}
+
+func (this *exprParser) parseFunc(fp FuncParser) FrmlExp {
+	params := this.readFuncParams()
+	if this.unclosed >= 0 {
+		this.unclosedError(this.unclosed, "missing_rparen", func() {
+			this.parseParams(params, func() {
+				fp.doParse(this.names, params)
+			})
+		})
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			this.rethrow(err, this.paramOffset(params))
+		}
+	}()
+	ret := fp.doParse(this.names, params)
+	checkDecimalParams(ret)
+	return ret
+}
+
+func (this *exprParser) parseValue() anyExp {
+	char := this.peek()
+	switch char {
+	case 0:
+		{
+			this.doError("missing_value")
+		}
+	case '(':
+		{
+			open := this.index
+			this.index++
+			ret := this.parseOr()
+			if this.peek() != ')' {
+				// 到结尾仍未闭合时指向左括号
+				if this.index >= this.end {
+					this.index = open
+				}
+				this.doError("missing_rparen")
+			}
+			this.index++
+			return ret
+		}
+	case ')':
+		{
+			this.doError("unexpected_rparen")
+		}
+	}
+
+	start := this.index
+	str := this.readWord()
+	if str == "" {
+		this.doError("oper_missing_left", string(char))
+	}
+	if this.isNum(str) {
+		// 科学计数法的指数符号，如：1.5e-3
+		last := str[len(str)-1]
+		if ((last == 'e') || (last == 'E')) && !this.isIntPrefix(str) && (this.index < this.end) &&
+			((this.exp[this.index] == '+') || (this.exp[this.index] == '-')) {
+			str = str + string(this.exp[this.index])
+			this.index++
+			str = str + this.readWord()
+		}
+		return this.parseNum(str, start)
+	}
+	if str[0] == '@' {
+		return this.parseCondRef(str[1:], start)
+	}
+
+	if this.peek() == '(' {
+		fp := getFuncParser(str)
+		if fp == nil {
+			this.index = start
+			this.doError("undefined_func", str)
+		}
+		ret := this.parseFunc(fp)
+		this.index++
+		return ret
+	}
+
+	id := this.names.GetIdByName(str)
+	if id == 0 {
+		this.index = start
+		this.doError("invalid_value_name", str)
+	}
+	return &idenExp{id: id, name: str, names: this.names}
+}
+
+func (this *exprParser) parseCondRef(name string, start int) CondExp {
+	named := condOfName[name]
+	if named == nil {
+		this.index = start
+		this.doError("undefined_cond", name)
+	}
+	this.refs = append(this.refs, named)
+
+	defer func() {
+		if err := recover(); err != nil {
+			this.index = start
+			this.rethrow(err, nil)
+		}
+	}()
+	return newCondRefExp(named, this.names)
+}
+
+func (this *exprParser) readWord() string {
+	start := this.index
+	for (this.index < this.end) && !this.isSymbol(this.exp[this.index]) {
+		this.index++
+	}
+	return string(this.exp[start:this.index])
+}
+
+// 以数字或“.数字”开头的为数值字面量：十进制（可带小数及科学计数法，如1.5e-3）、
+// 十六进制（0x1F）、二进制（0b101），数字间可用下划线分隔（1_000_000）
+func (this *exprParser) isNum(str string) bool {
+	if (str[0] >= '0') && (str[0] <= '9') {
+		return true
+	}
+	return (str[0] == '.') && (len(str) > 1) && (str[1] >= '0') && (str[1] <= '9')
+}
+
+func (this *exprParser) isIntPrefix(str string) bool {
+	if (len(str) < 2) || (str[0] != '0') {
+		return false
+	}
+	switch str[1] {
+	case 'x', 'X', 'b', 'B':
+		{
+			return true
+		}
+	}
+	return false
+}
+
+func (this *exprParser) parseNum(str string, start int) FrmlExp {
+	var v float64
+	var e error
+	if this.isIntPrefix(str) {
+		var i uint64
+		i, e = strconv.ParseUint(str, 0, 64)
+		v = float64(i)
+	} else {
+		v, e = strconv.ParseFloat(str, 64)
+	}
+	if e == nil {
+		return &constExp{value: v}
+	}
+
+	// 兼容以数字开头的数据名，如：1号位
+	if id := this.names.GetIdByName(str); id != 0 {
+		return &idenExp{id: id, name: str, names: this.names}
+	}
+	this.index = start
+	if errors.Is(e, strconv.ErrRange) {
+		this.doError("number_out_of_range", str)
+	}
+	this.doError("invalid_number", str)
+	return nil
+}
+
+func parseExpByNames(exp string, names INames, errFlag string) anyExp {
+	perser := &exprParser{}
+	perser.init(exp, names, errFlag)
+	ret := perser.parseOr()
+	perser.checkEnd()
+	return ret
+}
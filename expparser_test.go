@@ -0,0 +1,110 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"errors"
+	"testing"
+)
+
+// 比较、逻辑用于公式时值为1/0
+func TestFrmlBoolValue(t *testing.T) {
+	store := testCondStore(2, 10, 0)
+
+	tests := []struct {
+		exp      string
+		wantName string
+		want     float64
+	}{
+		{"条乙*(1+(条甲>0)*0.5)", "(条乙*(1+((条甲>0)*0.5)))", 15},
+		{"(条甲>1 && 条乙>1)+1", "(((条甲>1)&&(条乙>1))+1)", 2},
+		{"条甲>1", "(条甲>1)", 1},
+		{"!条甲>1", "(!(条甲>1))", 0},
+		{"条甲>1 || 条丙", "((条甲>1)||条丙)", 1},
+		{"1+2>2", "((1+2)>2)", 1},
+		{"(条甲 in (1,2))*3", "((条甲 in (1,2))*3)", 3},
+		{"If(条甲-2, 1, 2)", "If((条甲-2),1,2)", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			frml, err := ParseFrmlExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if frml.NameExp() != tt.wantName {
+				t.Fatalf("%s，应为%s", frml.NameExp(), tt.wantName)
+			}
+			if got := frml.Float64(store); got != tt.want {
+				t.Fatalf("%v，应为%v", got, tt.want)
+			}
+		})
+	}
+
+	opers, err := ParseOperExp("条丙 = 条乙*(1+(条甲>0)*0.5)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	WorkStat.ExecOper(store, opers, false)
+	if got := store.Get(Names.GetIdByName("条丙")); got != 15 {
+		t.Fatalf("运算结果%v，应为15", got)
+	}
+}
+
+// 公式用作条件时非0为成立
+func TestFrmlAsCond(t *testing.T) {
+	store := testCondStore(2, 10, 0)
+
+	tests := []struct {
+		exp  string
+		want bool
+	}{
+		{"条甲", true},
+		{"条丙", false},
+		{"条甲-2", false},
+		{"!条丙", true},
+		{"条甲*条乙 && 条丙+1", true},
+		{"条甲 && 条丙", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			cond, err := ParseCondExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cond.Check(store); got != tt.want {
+				t.Fatalf("%v，应为%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpParseError(t *testing.T) {
+	testCondStore(0, 0, 0)
+
+	tests := []struct {
+		exp  string
+		kind ParseErrorKind
+	}{
+		{"条甲+条乙>11==1", PE_SYNTAX},
+		{"条甲 in (1,2)*3", PE_SYNTAX},
+		{"未注册的名字>1", PE_NAME},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			_, err := ParseFrmlExp(tt.exp)
+			var pe *ParseError
+			if !errors.As(err, &pe) || (pe.Kind != tt.kind) {
+				t.Fatalf("错误：%v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+// 表达式格式化(Expression Formatter)
+//
+// 按运算优先级输出最少的括号，运算符两侧各一个空格，参数分隔符后一个空格；
+// 运算集合与流程每行一个运算，保留注释与空行（连续空行合并为一行）；
+// 格式化的结果重新解析后与原表达式一致，再次格式化结果不变
+
+import (
+	"strconv"
+	"strings"
+)
+
+// 运算优先级，由低到高
+const (
+	precOr = iota + 1
+	precAnd
+	precNot
+	precCompare
+	precBitOr
+	precBitXor
+	precBitAnd
+	precShift
+	precSum
+	precProduct
+	precUnary
+	precPower
+	precValue
+)
+
+var precOfOper = map[string]int{
+	"|":  precBitOr,
+	"~":  precBitXor,
+	"&":  precBitAnd,
+	"<<": precShift,
+	">>": precShift,
+	"+":  precSum,
+	"-":  precSum,
+	"*":  precProduct,
+	"/":  precProduct,
+	"%":  precProduct,
+	"^":  precPower,
+}
+
+func paren(text string, prec, min int) string {
+	if prec < min {
+		return "(" + text + ")"
+	}
+	return text
+}
+
+// 子表达式的优先级不低于min时不加括号
+func formatSub(exp anyExp, min int) string {
+	text, prec := formatExp(exp)
+	return paren(text, prec, min)
+}
+
+func formatList(exps []FrmlExp) string {
+	ret := make([]string, len(exps))
+	for i, exp := range exps {
+		ret[i], _ = formatExp(exp)
+	}
+	return strings.Join(ret, string(paramSeparator)+" ")
+}
+
+// 返回格式化文本及其优先级
+func formatExp(exp anyExp) (string, int) {
+	switch v := exp.(type) {
+	case *constExp:
+		{
+			text := strconv.FormatFloat(v.value, 'f', -1, 64)
+			if v.value < 0 {
+				return text, precUnary
+			}
+			return text, precValue
+		}
+	case *idenExp:
+		{
+			return v.names.GetNameById(v.id), precValue
+		}
+	case *negExp:
+		{
+			// 负常量直接作为常量，与解析结果一致
+			if c, ok := v.value.(*constExp); ok {
+				return formatExp(&constExp{value: -c.value})
+			}
+			text := formatSub(v.value, precUnary)
+			if strings.HasPrefix(text, "-") {
+				text = "(" + text + ")"
+			}
+			return "-" + text, precUnary
+		}
+	case *bitNotExp:
+		{
+			return "~" + formatSub(v.value, precUnary), precUnary
+		}
+	case *boolExp:
+		{
+			return formatExp(v.cond)
+		}
+	case *truthExp:
+		{
+			return formatExp(v.frml)
+		}
+	case *compiledFrml:
+		{
+			return formatExp(v.src)
+		}
+	case *compiledCond:
+		{
+			return formatExp(v.src)
+		}
+	case *ifFuncExp:
+		{
+			cond, _ := formatExp(v.cond)
+			return "If(" + cond + string(paramSeparator) + " " + formatList(v.params) + ")", precValue
+		}
+	case interface{ getFuncExp() *funcExp }:
+		{
+			base := v.getFuncExp()
+			return base.name + "(" + formatList(base.params) + ")", precValue
+		}
+	case *intDivExp:
+		{
+			return "Div(" + formatList([]FrmlExp{v.left, v.right}) + ")", precValue
+		}
+	case interface{ getFrmlExp() *frmlExp }:
+		{
+			return formatBinary(v.getFrmlExp())
+		}
+	case interface{ getCondExp() *condExp }:
+		{
+			base := v.getCondExp()
+			return formatSub(base.left, precBitOr) + " " + base.oper + " " + formatSub(base.right, precBitOr), precCompare
+		}
+	case interface{ getLogicExp() *logicExp }:
+		{
+			base := v.getLogicExp()
+			prec := precAnd
+			if base.logic == "||" {
+				prec = precOr
+			}
+			return formatSub(base.left, prec) + " " + base.logic + " " + formatSub(base.right, prec+1), prec
+		}
+	case *notExp:
+		{
+			return "!" + formatSub(v.cond, precUnary), precNot
+		}
+	case *inExp:
+		{
+			symbol := " in "
+			if v.not {
+				symbol = " not in "
+			}
+			items := make([]string, len(v.items))
+			for i, item := range v.items {
+				items[i] = formatSub(item, precBitOr)
+			}
+			return formatSub(v.left, precBitOr) + symbol + "(" + strings.Join(items, string(paramSeparator)+" ") + ")", precCompare
+		}
+	case *betweenExp:
+		{
+			return formatSub(v.left, precBitOr) + " between " + formatSub(v.low, precBitOr) + " and " + formatSub(v.high, precBitOr), precCompare
+		}
+	case *condRefExp:
+		{
+			return "@" + v.named.name, precValue
+		}
+	}
+	return "(" + exp.NameExp() + ")", precValue
+}
+
+// 左结合的运算右侧同级加括号；乘方右结合，右侧为一元表达式
+func formatBinary(exp *frmlExp) (string, int) {
+	prec := precOfOper[exp.oper]
+	if prec == precPower {
+		return formatSub(exp.left, precValue) + " ^ " + formatSub(exp.right, precUnary), prec
+	}
+	return formatSub(exp.left, prec) + " " + exp.oper + " " + formatSub(exp.right, prec+1), prec
+}
+
+func FormatFrml(exp FrmlExp) string {
+	ret, _ := formatExp(exp)
+	return ret
+}
+
+func FormatCond(exp CondExp) string {
+	ret, _ := formatExp(exp)
+	return ret
+}
+
+// 运算集合及流程中的值表达式：条件加括号，避免其中的关键字（in、between等）被当作下一个运算
+func formatStepValue(exp FrmlExp) string {
+	if _, ok := exp.(*boolExp); ok {
+		return formatSub(exp, precValue)
+	}
+	return FormatFrml(exp)
+}
+
+func formatStep(step OperExp) string {
+	switch v := step.(type) {
+	case *returnExp:
+		{
+			return "return(" + FormatFrml(v.value) + ")"
+		}
+	case *ifReturnExp:
+		{
+			return "return(" + FormatCond(v.cond) + string(paramSeparator) + " " + FormatFrml(v.value) + ")"
+		}
+	case *incOperExp:
+		{
+			return formatOper(&v.operExp, "+=")
+		}
+	case *decOperExp:
+		{
+			return formatOper(&v.operExp, "-=")
+		}
+	case *mulOperExp:
+		{
+			return formatOper(&v.operExp, "*=")
+		}
+	case *divOperExp:
+		{
+			return formatOper(&v.operExp, "/=")
+		}
+	case *andOperExp:
+		{
+			return formatOper(&v.operExp, "&=")
+		}
+	case *orOperExp:
+		{
+			return formatOper(&v.operExp, "|=")
+		}
+	case *setOperExp:
+		{
+			return formatOper(&v.operExp, "=")
+		}
+	}
+	return step.NameExp()
+}
+
+func formatOper(exp *operExp, symbol string) string {
+	return exp.names.GetNameById(exp.nameId) + " " + symbol + " " + formatStepValue(exp.value)
+}
+
+func formatSteps(steps []OperExp) string {
+	ret := make([]string, len(steps))
+	for i, step := range steps {
+		ret[i] = formatStep(step)
+	}
+	return strings.Join(ret, "\n")
+}
+
+func FormatOper(exp OperSet) string {
+	return formatSteps(exp.Opers())
+}
+
+func FormatProc(exp ProcExp) string {
+	return formatSteps(exp.Steps())
+}
+
+// 格式化公式文本
+func FormatFrmlExp(exp string) (ret string, err error) {
+	defer recoverParse(&err)
+	return FormatFrml(parseFrmlExpByNames(exp, Names)), nil
+}
+
+// 格式化条件文本
+func FormatCondExp(exp string) (ret string, err error) {
+	defer recoverParse(&err)
+	return FormatCond(parseCondExpByNames(exp, Names)), nil
+}
+
+// 格式化运算集合文本，保留注释与空行
+func FormatOperExp(exp string) (ret string, err error) {
+	defer recoverParse(&err)
+	opers, lines := parseOperLines(exp)
+	return formatSource(exp, opers.Opers(), lines), nil
+}
+
+// 格式化流程文本，保留注释与空行
+func FormatProcExp(exp string) (ret string, err error) {
+	defer recoverParse(&err)
+	proc, lines := parseProcLines(exp)
+	return formatSource(exp, proc.Steps(), lines), nil
+}
+
+// 拆分行中的代码与注释，“//”到行尾为注释
+func splitComment(line []rune) (code string, comment string, hasComment bool) {
+	for i := 0; i < len(line)-1; i++ {
+		if (line[i] == '/') && (line[i+1] == '/') {
+			return string(line[:i]), strings.TrimSpace(string(line[i+2:])), true
+		}
+	}
+	return string(line), "", false
+}
+
+// 按源文本的行排列格式化后的运算：每个运算占一行，注释保留在原来的位置，
+// 代码后的注释跟在该行最后一个运算之后，连续空行合并为一行
+func formatSource(exp string, steps []OperExp, lines []int) string {
+	stepsOfLine := make(map[int][]string)
+	for i, step := range steps {
+		stepsOfLine[lines[i]] = append(stepsOfLine[lines[i]], formatStep(step))
+	}
+
+	out := []string{}
+	// 最后一个运算所在的输出行，-1表示没有或其后已有注释
+	last := -1
+	blank := false
+	for i, line := range strings.Split(exp, "\n") {
+		code, comment, hasComment := splitComment([]rune(strings.TrimRight(line, "\r")))
+		hasCode := strings.Trim(code, " \t\r"+string(stepSeparator)) != ""
+		steps := stepsOfLine[i+1]
+
+		if !hasCode && !hasComment && (len(steps) == 0) {
+			blank = len(out) > 0
+			continue
+		}
+		if blank {
+			out = append(out, "")
+			blank = false
+		}
+
+		if len(steps) > 0 {
+			out = append(out, steps...)
+			last = len(out) - 1
+		}
+		if !hasComment {
+			continue
+		}
+		if comment != "" {
+			comment = "// " + comment
+		} else {
+			comment = "//"
+		}
+		if hasCode && (last >= 0) {
+			out[last] = out[last] + " " + comment
+		} else {
+			out = append(out, comment)
+		}
+		last = -1
+	}
+
+	return strings.Join(out, "\n") + "\n"
+}
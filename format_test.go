@@ -0,0 +1,147 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"errors"
+	"testing"
+)
+
+// 函数参数中含括号时按配对的括号拆分参数
+func TestFuncNestedParams(t *testing.T) {
+	store := testCondStore(2, 3, 4)
+
+	tests := []struct {
+		exp  string
+		want string
+		val  float64
+	}{
+		{"Max((条甲)+1, 条乙)", "Max(条甲 + 1, 条乙)", 3},
+		{"If((条甲>1) && (条乙>1), (1), (2))", "If(条甲 > 1 && 条乙 > 1, 1, 2)", 1},
+		{"Max(Min((1),(2)), ((3)))", "Max(Min(1, 2), 3)", 3},
+		{"Min(Max(条甲,(条乙)*2)-1, (条丙))", "Min(Max(条甲, 条乙 * 2) - 1, 条丙)", 4},
+		{"Max((条甲+1)*(条乙-1), 2)", "Max((条甲 + 1) * (条乙 - 1), 2)", 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			frml, err := ParseFrmlExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := FormatFrml(frml); got != tt.want {
+				t.Fatalf("%s，应为%s", got, tt.want)
+			}
+			if got := frml.Float64(store); got != tt.val {
+				t.Fatalf("%v，应为%v", got, tt.val)
+			}
+		})
+	}
+}
+
+func TestFuncParamCount(t *testing.T) {
+	tests := []string{"If(条甲>1, 1)", "If(1, 2, 3, 4)", "Div(条甲)"}
+	for _, exp := range tests {
+		t.Run(exp, func(t *testing.T) {
+			_, err := ParseFrmlExp(exp)
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("应返回解析错误：%v", err)
+			}
+			if pe.Key != "func_param_count" {
+				t.Fatalf("错误：%s，应为func_param_count", pe.Key)
+			}
+		})
+	}
+}
+
+// 格式化结果可再次解析且值不变，再次格式化结果不变
+func TestFormatRoundTrip(t *testing.T) {
+	store := testCondStore(2, 3, 4)
+
+	frmls := []string{
+		"条甲+条乙*条丙",
+		"(条甲+条乙)*条丙",
+		"条甲-(条乙-条丙)",
+		"条甲/(条乙/条丙)",
+		"-条甲^2",
+		"2^3^2",
+		"(2^3)^2",
+		"Div(条丙+5, 条乙)",
+		"条甲 & 3 | 条乙 ~ 1",
+		"If(条甲>1, Max(条乙, 条丙), -1)",
+	}
+	for _, exp := range frmls {
+		t.Run(exp, func(t *testing.T) {
+			frml, err := ParseFrmlExp(exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			text := FormatFrml(frml)
+			again, err := ParseFrmlExp(text)
+			if err != nil {
+				t.Fatalf("%s：%v", text, err)
+			}
+			if got, want := again.Float64(store), frml.Float64(store); got != want {
+				t.Fatalf("%s：%v，应为%v", text, got, want)
+			}
+			if got := FormatFrml(again); got != text {
+				t.Fatalf("再次格式化：%s，应为%s", got, text)
+			}
+		})
+	}
+
+	conds := []string{
+		"条甲 > 1 && !(条乙<2 || 条丙=4)",
+		"(条甲>1 || 条乙>5) && 条丙=4",
+		"Max((条甲),条乙) between 1 and 5",
+		"条丙 in (1, 2, 4)",
+	}
+	for _, exp := range conds {
+		t.Run(exp, func(t *testing.T) {
+			cond, err := ParseCondExp(exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			text := FormatCond(cond)
+			again, err := ParseCondExp(text)
+			if err != nil {
+				t.Fatalf("%s：%v", text, err)
+			}
+			if got, want := again.Check(store), cond.Check(store); got != want {
+				t.Fatalf("%s：%v，应为%v", text, got, want)
+			}
+			if got := FormatCond(again); got != text {
+				t.Fatalf("再次格式化：%s，应为%s", got, text)
+			}
+		})
+	}
+}
+
+// 流程格式化保留注释与空行，连续空行合并为一行
+func TestFormatProcExp(t *testing.T) {
+	src := "// 头部注释\n条甲=条甲+1 条乙=Max((条甲),2) // 行尾\n\n\n\nreturn(条甲>2, 条乙)\n条丙+=1\n"
+	got, err := FormatProcExp(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "// 头部注释\n条甲 = 条甲 + 1\n条乙 = Max(条甲, 2) // 行尾\n\nreturn(条甲 > 2, 条乙)\n条丙 += 1\n"
+	if got != want {
+		t.Fatalf("%q，应为%q", got, want)
+	}
+	again, err := FormatProcExp(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != got {
+		t.Fatalf("再次格式化：%q，应为%q", again, got)
+	}
+}
@@ -14,8 +14,7 @@ package de
 // 四则运算公式系统(Formula System)
 
 import (
-	"errors"
-	"fmt"
+	"math"
 	"strconv"
 )
 
@@ -26,8 +25,6 @@ type FrmlExp interface {
 	EachId(fn func(id uint32))
 }
 
-const ERR_COMPENSATION = float64(0.000000000000000000001)
-
 type constExp struct {
 	value float64
 }
@@ -75,6 +72,12 @@ type frmlExp struct {
 	left  FrmlExp
 	right FrmlExp
 	value float64
+	// 定点运算的精度，nil为浮点运算
+	dec *Decimal
+}
+
+func (this *frmlExp) getFrmlExp() *frmlExp {
+	return this
 }
 
 func (this *frmlExp) NameExp() string {
@@ -95,6 +98,9 @@ type incExp struct {
 }
 
 func (this *incExp) Float64(store *Storehouse) float64 {
+	if this.dec != nil {
+		return this.decimalValue(store)
+	}
 	return this.left.Float64(store) + this.right.Float64(store)
 }
 
@@ -103,6 +109,9 @@ type decExp struct {
 }
 
 func (this *decExp) Float64(store *Storehouse) float64 {
+	if this.dec != nil {
+		return this.decimalValue(store)
+	}
 	return this.left.Float64(store) - this.right.Float64(store)
 }
 
@@ -111,6 +120,9 @@ type mulExp struct {
 }
 
 func (this *mulExp) Float64(store *Storehouse) float64 {
+	if this.dec != nil {
+		return this.decimalValue(store)
+	}
 	return this.left.Float64(store) * this.right.Float64(store)
 }
 
@@ -118,302 +130,232 @@ type divExp struct {
 	frmlExp
 }
 
+// 除数为0时按运算异常策略处理，默认返回被除数
+func divValue(store *Storehouse, exp anyExp, left, right float64) float64 {
+	if right == 0 {
+		return store.arithFault(AF_DIV_ZERO, exp, left, right, left/right)
+	}
+	return left / right
+}
+
 func (this *divExp) Float64(store *Storehouse) float64 {
-	rv := this.right.Float64(store)
-	if rv == 0 {
-		return this.left.Float64(store)
+	if this.dec != nil {
+		return this.decimalValue(store)
 	}
-	return this.left.Float64(store) / rv
+	return divValue(store, this, this.left.Float64(store), this.right.Float64(store))
 }
 
 type modExp struct {
 	frmlExp
 }
 
+// 取余：两个操作数截断为整数后取余，截断后的除数为0（如5%0.5）时按运算异常策略处理，默认返回被除数
+func modValue(store *Storehouse, exp anyExp, left, right float64) float64 {
+	r := int64(right)
+	if r == 0 {
+		return store.arithFault(AF_MOD_ZERO, exp, left, right, math.NaN())
+	}
+	return float64(int64(left) % r)
+}
+
 func (this *modExp) Float64(store *Storehouse) float64 {
-	if this.right.Float64(store) == 0 {
-		return this.left.Float64(store)
+	if this.dec != nil {
+		return this.decimalValue(store)
 	}
-	return float64(int64(this.left.Float64(store)) % int64(this.right.Float64(store)))
+	return modValue(store, this, this.left.Float64(store), this.right.Float64(store))
 }
 
-type frmlParser struct {
-	parser
+// 乘方，右结合
+type powExp struct {
+	frmlExp
 }
 
-func (this *frmlParser) parseValueExp(value string, isNum bool) FrmlExp {
-	if isNum {
-		v, e := strconv.ParseFloat(value, 64)
-		if e != nil {
-			this.doError("无效数值：" + value)
-		}
-		return &constExp{value: v}
+// 负数底数加括号，避免与取负混淆：(-2)^2
+func (this *powExp) NameExp() string {
+	if v, ok := this.left.(*constExp); ok && (v.value < 0) {
+		return "((" + this.left.NameExp() + ")^" + this.right.NameExp() + ")"
 	}
+	return this.frmlExp.NameExp()
+}
+
+func (this *powExp) Float64(store *Storehouse) float64 {
+	return math.Pow(this.left.Float64(store), this.right.Float64(store))
+}
+
+// 取负
+type negExp struct {
+	value FrmlExp
+}
+
+func (this *negExp) EachId(fn func(id uint32)) {
+	this.value.EachId(fn)
+}
+
+func (this *negExp) NameExp() string {
+	return "-" + this.value.NameExp()
+}
+
+func (this *negExp) ValueExp(store *Storehouse) string {
+	return "-" + this.value.ValueExp(store)
+}
 
-	id := this.names.GetIdByName(value)
-	if id == 0 {
-		this.doError("无效值名：" + value)
+func (this *negExp) Float64(store *Storehouse) float64 {
+	return -this.value.Float64(store)
+}
+
+// 整数运算（位运算、整除）的操作数须为int64范围内的整数
+func toInt64(value float64) (int64, bool) {
+	if (value != math.Trunc(value)) || (value < math.MinInt64) || (value >= math.MaxInt64) {
+		return 0, false
 	}
-	return &idenExp{id: id, name: value, names: this.names}
+	return int64(value), true
 }
 
-func (this *frmlParser) parseFunc(fp FuncParser) FrmlExp {
-	defer func() {
-		if err := recover(); err != nil {
-			this.doError(fmt.Sprintf("%v", err))
+// 整数二元运算：两个操作数转换为int64后运算，转换失败时按运算异常策略处理，默认结果为0
+func intOper(store *Storehouse, exp anyExp, left, right float64, fn func(l, r int64) int64) float64 {
+	l, ok := toInt64(left)
+	if ok {
+		var r int64
+		if r, ok = toInt64(right); ok {
+			return float64(fn(l, r))
 		}
-	}()
-	return fp.doParse(this.names, this.readFuncParams())
+	}
+	if warnFault(AF_NOT_INT) {
+		writeLog(&LogEntry{Level: LL_WARN, Code: "int_operands", Args: []any{exp.NameExp(), left, right}, Flag: "Formula", Exp: exp.NameExp()})
+	}
+	return store.arithFault(AF_NOT_INT, exp, left, right, 0)
 }
 
-func (this *frmlParser) getValueExp() (ret FrmlExp) {
-	ret = nil
-	str := ""
-	leftParentheses := 0
-	isNum := true
-	count := 0
+type bitAndExp struct {
+	frmlExp
+}
 
-	defer func() {
-		if ret == nil {
-			ret = this.parseValueExp(str, isNum)
-		}
-	}()
+func andInt(l, r int64) int64 {
+	return l & r
+}
 
-	for {
-		this.pass()
+func (this *bitAndExp) Float64(store *Storehouse) float64 {
+	return intOper(store, this, this.left.Float64(store), this.right.Float64(store), andInt)
+}
 
-		if this.index >= this.end {
-			break
-		}
+type bitOrExp struct {
+	frmlExp
+}
 
-		char := this.exp[this.index]
-
-		if char == '(' {
-			if str != "" {
-				fp := getFuncParser(str)
-				if fp == nil {
-					this.doError("未定义的函数：" + str)
-				}
-				ret = this.parseFunc(fp)
-				this.index++
-				return
-			}
-
-			if count == 0 {
-				this.index++
-				ret = this.doParse()
-				this.pass()
-				if (this.index >= this.end) || (this.exp[this.index] != ')') {
-					this.doError("缺失右括号")
-				}
-				this.index++
-				return
-			}
-			leftParentheses++
-		} else if char == ')' {
-			if leftParentheses == 0 {
-				return
-			}
-			leftParentheses--
-		}
+func orInt(l, r int64) int64 {
+	return l | r
+}
 
-		if leftParentheses == 0 {
-			switch char {
-			case '+', '*', '/', '%':
-				{
-					return
-				}
-			case '-':
-				{
-					if str != "" {
-						return
-					}
-				}
-			case '<', '>', '=', '!', ':':
-				{
-					this.doError(fmt.Sprintf("名称不能含有字符“%s”", string(char)))
-				}
-			}
-		}
+func (this *bitOrExp) Float64(store *Storehouse) float64 {
+	return intOper(store, this, this.left.Float64(store), this.right.Float64(store), orInt)
+}
 
-		if (char < '0' || char > '9') && (char != '.') && (char != '-') {
-			isNum = false
-		}
+// 按位异或，“^”已用于乘方，异或用“~”
+type bitXorExp struct {
+	frmlExp
+}
 
-		count++
-		str = str + string(char)
-		this.index++
+func xorInt(l, r int64) int64 {
+	return l ^ r
+}
+
+func (this *bitXorExp) Float64(store *Storehouse) float64 {
+	return intOper(store, this, this.left.Float64(store), this.right.Float64(store), xorInt)
+}
+
+type shlExp struct {
+	frmlExp
+}
+
+// 位移数为负时反向位移
+func shlInt(l, r int64) int64 {
+	if r < 0 {
+		return l >> uint64(-r)
 	}
+	return l << uint64(r)
+}
 
-	return
+func (this *shlExp) Float64(store *Storehouse) float64 {
+	return intOper(store, this, this.left.Float64(store), this.right.Float64(store), shlInt)
 }
 
-func (this *frmlParser) buildExp(left FrmlExp, symbol rune, right FrmlExp) FrmlExp {
-	switch symbol {
-	case '+':
-		{
-			ret := &incExp{}
-			ret.oper = string(symbol)
-			ret.names = this.names
-			ret.left = left
-			ret.right = right
-			return ret
-		}
-	case '-':
-		{
-			ret := &decExp{}
-			ret.oper = string(symbol)
-			ret.names = this.names
-			ret.left = left
-			ret.right = right
-			return ret
-		}
-	case '*':
-		{
-			ret := &mulExp{}
-			ret.oper = string(symbol)
-			ret.names = this.names
-			ret.left = left
-			ret.right = right
-			return ret
-		}
-	case '/':
-		{
-			ret := &divExp{}
-			ret.oper = string(symbol)
-			ret.names = this.names
-			ret.left = left
-			ret.right = right
-			return ret
-		}
-	case '%':
-		{
-			ret := &modExp{}
-			ret.oper = string(symbol)
-			ret.names = this.names
-			ret.left = left
-			ret.right = right
-			return ret
-		}
-	default:
-		{
-			return nil
-		}
+type shrExp struct {
+	frmlExp
+}
+
+func shrInt(l, r int64) int64 {
+	if r < 0 {
+		return l << uint64(-r)
 	}
+	return l >> uint64(r)
 }
 
-func (this *frmlParser) doParse() (ret FrmlExp) {
+func (this *shrExp) Float64(store *Storehouse) float64 {
+	return intOper(store, this, this.left.Float64(store), this.right.Float64(store), shrInt)
+}
 
-	left := FrmlExp(nil)
-	right := FrmlExp(nil)
-	symbol := rune(0)
+// 整除（向零取整）：Div(a,b)，除数为0时同除法按运算异常策略处理；
+// 不使用“//”，以免与运算集合中的注释混淆
+type intDivExp struct {
+	frmlExp
+}
 
-	defer func() {
-		if left == nil {
-			this.doError("无效表达式")
-		}
-		if symbol == 0 {
-			ret = left
-		} else if right == nil {
-			this.doError(fmt.Sprintf("运算符“%s”缺失右项", string(symbol)))
-		} else {
-			ret = this.buildExp(left, symbol, right)
-		}
-	}()
-
-	operPriority := func(symbol rune) int {
-		switch symbol {
-		case '+', '-':
-			{
-				return 0
-			}
-		default:
-			{
-				return 1
-			}
-		}
+func (this *intDivExp) NameExp() string {
+	return "Div(" + this.left.NameExp() + "," + this.right.NameExp() + ")"
+}
+
+func (this *intDivExp) ValueExp(store *Storehouse) string {
+	return "Div(" + this.left.ValueExp(store) + "," + this.right.ValueExp(store) + ")"
+}
+
+func intDivInt(l, r int64) int64 {
+	if r == 0 {
+		return l
 	}
+	return l / r
+}
 
-	setRight := func(char rune, exp FrmlExp) {
-		if right == nil {
-			symbol = char
-			right = exp
-		} else {
-			if operPriority(char) <= operPriority(symbol) {
-				left = this.buildExp(left, symbol, right)
-				symbol = char
-				right = exp
-			} else {
-				right = this.buildExp(right, char, exp)
-			}
-		}
+func intDivValue(store *Storehouse, exp anyExp, left, right float64) float64 {
+	if right == 0 {
+		return store.arithFault(AF_DIV_ZERO, exp, left, right, left/right)
 	}
+	return intOper(store, exp, left, right, intDivInt)
+}
 
-	for {
-		this.pass()
-		if this.index >= this.end {
-			break
-		}
+func (this *intDivExp) Float64(store *Storehouse) float64 {
+	return intDivValue(store, this, this.left.Float64(store), this.right.Float64(store))
+}
 
-		char := this.exp[this.index]
-
-		switch char {
-		case '(':
-			{
-				this.index++
-				cur := this.doParse()
-				if cur == nil {
-					this.doError(fmt.Sprintf("运算符“%s”缺失右值", string(char)))
-				}
-
-				if left == nil {
-					left = cur
-				} else {
-					setRight(char, cur)
-				}
-
-				this.pass()
-				if (this.index >= this.end) || (this.exp[this.index] != ')') {
-					this.doError("缺失右括号")
-				}
-				this.index++
-				continue
-			}
-		case '+', '-', '*', '/', '%':
-			{
-				if left == nil {
-					if char != '-' {
-						this.doError(fmt.Sprintf("运算符“%s”缺失左值", string(char)))
-					}
-					// 负数
-					left = this.getValueExp()
-					continue
-				}
-
-				this.index++
-				cur := this.getValueExp()
-				if cur == nil {
-					this.doError(fmt.Sprintf("运算符“%s”缺失右值", string(char)))
-				}
-				setRight(char, cur)
-				continue
-			}
-		case ')':
-			{
-				return
-			}
-		default:
-			{
-				if left != nil {
-					this.doError("此处有多余符号")
-				}
-				left = this.getValueExp()
-				continue
-			}
+// 按位取反
+type bitNotExp struct {
+	value FrmlExp
+}
+
+func (this *bitNotExp) EachId(fn func(id uint32)) {
+	this.value.EachId(fn)
+}
+
+func (this *bitNotExp) NameExp() string {
+	return "~" + this.value.NameExp()
+}
+
+func (this *bitNotExp) ValueExp(store *Storehouse) string {
+	return "~" + this.value.ValueExp(store)
+}
+
+func bitNotValue(store *Storehouse, exp anyExp, value float64) float64 {
+	v, ok := toInt64(value)
+	if !ok {
+		if warnFault(AF_NOT_INT) {
+			writeLog(&LogEntry{Level: LL_WARN, Code: "int_operand", Args: []any{exp.NameExp(), value}, Flag: "Formula", Exp: exp.NameExp()})
 		}
+		return store.arithFault(AF_NOT_INT, exp, value, 0, 0)
 	}
+	return float64(^v)
+}
 
-	return
+func (this *bitNotExp) Float64(store *Storehouse) float64 {
+	return bitNotValue(store, this, this.value.Float64(store))
 }
 
 func parseFrmlExpByNames(exp string, names INames) FrmlExp {
@@ -421,20 +363,12 @@ func parseFrmlExpByNames(exp string, names INames) FrmlExp {
 		return nil
 	}
 
-	perser := &frmlParser{}
-	perser.init(exp, names, "Formula")
-	ret := perser.doParse()
-	perser.checkEnd()
-
-	return ret
+	return asFrml(parseExpByNames(exp, names, "Formula"))
 }
 
-func ParseFrmlExpByNames(exp string, names INames) (FrmlExp, error) {
-	if err := recover(); err != nil {
-		return nil, errors.New(fmt.Sprintf("%v", err))
-	}
-	ret := parseFrmlExpByNames(exp, names)
-	return ret, nil
+func ParseFrmlExpByNames(exp string, names INames) (ret FrmlExp, err error) {
+	defer recoverParse(&err)
+	return parseFrmlExpByNames(exp, names), nil
 }
 
 func ParseFrmlExp(exp string) (FrmlExp, error) {
@@ -0,0 +1,184 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"errors"
+	"testing"
+)
+
+// 测试公式所用的数据：数甲=3
+func testFrmlStore() *Storehouse {
+	store := NewStorehouse(nil)
+	store.Set(testName("测试公式", "数甲"), 3)
+	return store
+}
+
+func TestFrmlPowerAndUnary(t *testing.T) {
+	store := testFrmlStore()
+
+	tests := []struct {
+		exp      string
+		wantName string
+		want     float64
+	}{
+		{"2^3^2", "(2^(3^2))", 512},
+		{"-2^2", "-(2^2)", -4},
+		{"(-2)^2", "((-2)^2)", 4},
+		{"2^-1", "(2^-1)", 0.5},
+		{"数甲^2%5", "((数甲^2)%5)", 4},
+		{"10%3^2", "(10%(3^2))", 1},
+		{"数甲 ^ 2 * 2", "((数甲^2)*2)", 18},
+		{"-(数甲+1)", "-(数甲+1)", -4},
+		{"+数甲", "数甲", 3},
+		{"--数甲", "--数甲", 3},
+		{"-数甲*2", "(-数甲*2)", -6},
+		{"2*-数甲", "(2*-数甲)", -6},
+		{"2+-+-3", "(2+3)", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			frml, err := ParseFrmlExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if frml.NameExp() != tt.wantName {
+				t.Fatalf("%s，应为%s", frml.NameExp(), tt.wantName)
+			}
+			if got := frml.Float64(store); got != tt.want {
+				t.Fatalf("%v，应为%v", got, tt.want)
+			}
+
+			// 输出的名字表达式重新解析后结果不变
+			again, err := ParseFrmlExp(frml.NameExp())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := again.Float64(store); got != tt.want {
+				t.Fatalf("重新解析：%v，应为%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrmlNumberLiteral(t *testing.T) {
+	tests := []struct {
+		exp  string
+		want float64
+	}{
+		{"1e6", 1e6},
+		{"1.5e-3", 0.0015},
+		{"1E+2", 100},
+		{"0x1F", 31},
+		{"0XfF", 255},
+		{"0x_FF", 255},
+		{"0b101", 5},
+		{"1_000_000", 1000000},
+		{"1_0.5_5", 10.55},
+		{".5", 0.5},
+		{"5.", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			frml, err := ParseFrmlExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := frml.Float64(nil); got != tt.want {
+				t.Fatalf("%v，应为%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrmlNumberError(t *testing.T) {
+	testFrmlStore()
+
+	tests := []struct {
+		exp  string
+		kind ParseErrorKind
+		key  string
+	}{
+		{"1__0", PE_NUMBER, "invalid_number"},
+		{"1_", PE_NUMBER, "invalid_number"},
+		{"1e", PE_NUMBER, "invalid_number"},
+		{"0x", PE_NUMBER, "invalid_number"},
+		{"0xG", PE_NUMBER, "invalid_number"},
+		{"0x1.8", PE_NUMBER, "invalid_number"},
+		{"1.2.3", PE_NUMBER, "invalid_number"},
+		{"1e400", PE_NUMBER, "number_out_of_range"},
+		{"_1", PE_NAME, "invalid_value_name"},
+		{"2^", PE_SYNTAX, "missing_value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			_, err := ParseFrmlExp(tt.exp)
+			var pe *ParseError
+			if !errors.As(err, &pe) || (pe.Kind != tt.kind) || (pe.Key != tt.key) {
+				t.Fatalf("错误：%v，应为%s", err, tt.key)
+			}
+		})
+	}
+}
+
+func TestFrmlBitwise(t *testing.T) {
+	store := NewStorehouse(nil)
+	store.Set(testName("测试公式", "位甲"), 12)
+
+	tests := []struct {
+		exp        string
+		wantFormat string
+		want       float64
+	}{
+		{"位甲&10", "位甲 & 10", 8},
+		{"位甲|3", "位甲 | 3", 15},
+		{"位甲~10", "位甲 ~ 10", 6},
+		{"~位甲", "~位甲", -13},
+		{"位甲&~4", "位甲 & ~4", 8},
+		{"1<<4", "1 << 4", 16},
+		{"位甲>>2", "位甲 >> 2", 3},
+		{"1|2&3", "1 | 2 & 3", 3},
+		{"1<<2+1", "1 << 2 + 1", 8},
+		{"Div(7,2)", "Div(7, 2)", 3},
+		{"Div(-7,2)", "Div(-7, 2)", -3},
+		{"Div(位甲+1, 2)*2", "Div(位甲 + 1, 2) * 2", 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			frml, err := ParseFrmlExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := FormatFrml(frml); got != tt.wantFormat {
+				t.Fatalf("格式化：%s，应为%s", got, tt.wantFormat)
+			}
+			if got := frml.Float64(store); got != tt.want {
+				t.Fatalf("%v，应为%v", got, tt.want)
+			}
+			for _, exp := range []string{frml.NameExp(), tt.wantFormat} {
+				again, err := ParseFrmlExp(exp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := again.Float64(store); got != tt.want {
+					t.Fatalf("重新解析%s：%v，应为%v", exp, got, tt.want)
+				}
+			}
+		})
+	}
+
+	_, err := ParseFrmlExp("7//2")
+	var pe *ParseError
+	if !errors.As(err, &pe) || (pe.Key != "double_slash") {
+		t.Fatalf("“//”应提示使用Div：%v", err)
+	}
+}
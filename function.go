@@ -14,7 +14,6 @@ package de
 // 可自定义函数系统（User Defined Function System）
 
 import (
-	"fmt"
 	"math/rand"
 	"time"
 )
@@ -29,6 +28,12 @@ type funcExp struct {
 	name   string
 	params []FrmlExp
 	exec   FuncExec
+	// 纯函数：结果只取决于参数且无副作用
+	pure bool
+}
+
+func (this *funcExp) getFuncExp() *funcExp {
+	return this
 }
 
 func (this *funcExp) Float64(store *Storehouse) float64 {
@@ -71,18 +76,20 @@ type funcParser struct {
 	name   string
 	pcount int
 	exec   FuncExec
+	pure   bool
 }
 
 func (this *funcParser) doParse(names INames, params []string) FrmlExp {
 	len := len(params)
 	if len != this.pcount {
-		panic(fmt.Sprintf("%s函数参数必须为%d个，当前为：%d", this.name, this.pcount, len))
+		parseFail("func_param_count", this.name, this.pcount, len)
 	}
 
 	ret := &funcExp{
 		name:   this.name,
 		exec:   this.exec,
 		params: make([]FrmlExp, len),
+		pure:   this.pure,
 	}
 	for i, p := range params {
 		ret.params[i] = parseFrmlExpByNames(p, names)
@@ -95,7 +102,7 @@ var funcParserOfName = make(map[string]FuncParser)
 
 func RegisterFunc(name string, exec FuncExec, paramCount int) {
 	if funcParserOfName[name] != nil {
-		panic(fmt.Sprintf("函数“%s”已经被注册", name))
+		panic(Message("func_registered", name))
 	}
 	funcParserOfName[name] = &funcParser{
 		name:   name,
@@ -104,6 +111,12 @@ func RegisterFunc(name string, exec FuncExec, paramCount int) {
 	}
 }
 
+// 注册纯函数：结果只取决于参数且无副作用，参数均为常量时优化表达式可直接求值
+func RegisterPureFunc(name string, exec FuncExec, paramCount int) {
+	RegisterFunc(name, exec, paramCount)
+	funcParserOfName[name].(*funcParser).pure = true
+}
+
 func getFuncParser(name string) FuncParser {
 	return funcParserOfName[name]
 }
@@ -119,7 +132,7 @@ func (this *ifFuncExp) EachId(fn func(id uint32)) {
 }
 
 func (this *ifFuncExp) NameExp() string {
-	return "if(" + this.cond.NameExp() + "," + this.params[0].NameExp() + "," + this.params[1].NameExp() + ")"
+	return "If(" + this.cond.NameExp() + "," + this.params[0].NameExp() + "," + this.params[1].NameExp() + ")"
 }
 
 func (this *ifFuncExp) ValueExp(store *Storehouse) string {
@@ -140,7 +153,7 @@ type ifFuncParser struct {
 func (this *ifFuncParser) doParse(names INames, params []string) FrmlExp {
 	len := len(params)
 	if len != 3 {
-		panic(fmt.Sprintf("%s函数参数必须为%d个，当前为：%d", this.name, this.pcount, len))
+		parseFail("func_param_count", this.name, this.pcount, len)
 	}
 
 	ret := &ifFuncExp{}
@@ -154,9 +167,30 @@ func (this *ifFuncParser) doParse(names INames, params []string) FrmlExp {
 	return ret
 }
 
+// 整除函数Div(a,b)，构建为整除表达式
+type intDivFuncParser struct {
+	funcParser
+}
+
+func (this *intDivFuncParser) doParse(names INames, params []string) FrmlExp {
+	len := len(params)
+	if len != this.pcount {
+		parseFail("func_param_count", this.name, this.pcount, len)
+	}
+	return newFrmlExpOf("Div", names, parseFrmlExpByNames(params[0], names), parseFrmlExpByNames(params[1], names))
+}
+
+func init() {
+	parser := &intDivFuncParser{}
+	parser.name = "Div"
+	parser.pcount = 2
+	funcParserOfName["Div"] = parser
+}
+
 func init() {
 	parser := &ifFuncParser{}
 	parser.name = "If"
+	parser.pcount = 3
 	funcParserOfName["If"] = parser
 }
 
@@ -201,10 +235,11 @@ func (this *randomFuncParser) doParse(names INames, params []string) FrmlExp {
 		}
 	default:
 		{
-			panic(fmt.Sprintf("不合法的random函数参数个数：%d", len(params)))
+			parseFail("random_param_count", len(params))
 		}
 
 	}
+	return nil
 }
 
 func init() {
@@ -215,24 +250,30 @@ func init() {
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
-		v1 := params[0].Float64(store)
-		v2 := params[1].Float64(store)
-		if v1 < v2 {
-			return v1
-		}
-		return v2
+		return minValue(params[0].Float64(store), params[1].Float64(store))
 	}
-	RegisterFunc("Min", funcExec, 2)
+	RegisterPureFunc("Min", funcExec, 2)
+}
+
+// 较小值，相等或有NaN时取v2
+func minValue(v1, v2 float64) float64 {
+	if v1 < v2 {
+		return v1
+	}
+	return v2
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
-		v1 := params[0].Float64(store)
-		v2 := params[1].Float64(store)
-		if v1 > v2 {
-			return v1
-		}
-		return v2
+		return maxValue(params[0].Float64(store), params[1].Float64(store))
+	}
+	RegisterPureFunc("Max", funcExec, 2)
+}
+
+// 较大值，相等或有NaN时取v2
+func maxValue(v1, v2 float64) float64 {
+	if v1 > v2 {
+		return v1
 	}
-	RegisterFunc("Max", funcExec, 2)
+	return v2
 }
@@ -13,58 +13,443 @@ package de
 
 // 数据变化监听系统(Data Status Monitoring System)
 
+import (
+	"sync/atomic"
+	"time"
+)
+
 type ListenFuncById = func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64)
 
+// 监听优先级：数值越大越先执行，优先级相同的按注册先后执行
+const (
+	LP_LOW    = -100
+	LP_NORMAL = 0
+	LP_HIGH   = 100
+)
+
+// 监听选项
+type ListenOpt struct {
+	// 监听器名称（可选），同一个ID下不能重复
+	Name     string
+	Priority int
+	// 异步执行：由仓库的异步监听队列按变化顺序投递，见SetListenQueue
+	Async bool
+	// 防抖：数据静默该时长（引擎时钟）后才以最新值回调一次；
+	// 回调在仓库拥有者的协程中执行：节拍时钟在Advance中回调，其它时钟须由拥有者调用Storehouse.Pump
+	Debounce time.Duration
+	// 节流：每个ThrottleInterval内最多回调ThrottleCount次，超出的变化被忽略
+	ThrottleCount    int
+	ThrottleInterval time.Duration
+	// 批次合并：ExecOper/ExecProc执行期间的多次变化，于执行结束后以最终值回调一次
+	Coalesce bool
+	// 时钟（可选），默认使用引擎时钟
+	Clock Clock
+}
+
+func (this *ListenOpt) clock() Clock {
+	if this.Clock != nil {
+		return this.Clock
+	}
+	return clock
+}
+
+// 监听类别
+const (
+	lkId = iota
+	lkType
+	lkTag
+	lkAll
+)
+
+type Listener struct {
+	kind     int
+	key      uint32
+	tag      string
+	opt      ListenOpt
+	seq      uint64
+	fn       ListenFuncById
+	owner    *lister
+	limit    *listenLimit
+	canceled int32
+}
+
+// 按ID监听时返回数据ID，按类型监听时返回类型ID（高8位），按标签监听及监听全部时返回0
+func (this *Listener) Id() uint32 {
+	return this.key
+}
+
+// 按标签监听时返回标签
+func (this *Listener) Tag() string {
+	return this.tag
+}
+
+func (this *Listener) Name() string {
+	return this.opt.Name
+}
+
+func (this *Listener) Priority() int {
+	return this.opt.Priority
+}
+
+func (this *Listener) Cancel() {
+	this.owner.Del(this)
+}
+
+func (this *Listener) before(other *Listener) bool {
+	if this.opt.Priority != other.opt.Priority {
+		return this.opt.Priority > other.opt.Priority
+	}
+	return this.seq < other.seq
+}
+
+func (this *Listener) cancel() {
+	atomic.StoreInt32(&this.canceled, 1)
+	if this.limit != nil {
+		this.limit.stop()
+	}
+}
+
+func (this *Listener) deliver(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+	if this.opt.Async {
+		store.pushAsyncListen(id, operSymbol, value, []*Listener{this})
+	} else {
+		this.call(store, id, operSymbol, value)
+	}
+}
+
+func (this *Listener) call(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+	if atomic.LoadInt32(&this.canceled) != 0 {
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			// 连锁触发超限交由最外层运算处理
+			if _, ok := err.(*CascadeError); ok {
+				panic(err)
+			}
+			rethrowArith(err)
+			writeLog(&LogEntry{Level: LL_ERROR, Code: "listen_callback_failed", Args: []any{err, this.opt.Name},
+				Flag: "Storehouse.Oper", Name: store.names.GetNameById(id), Id: id, Err: err})
+		}
+	}()
+	this.fn(store, id, operSymbol, value)
+}
+
+// 全局注册序号，保证全局监听和仓库监听合并后仍然有确定的执行顺序
+var listenSeq = uint64(0)
+
+// 数据ID的类型ID（有序ID的名字类型ID为0）
+func typeIdOf(id uint32) uint32 {
+	return id &^ RawIDMark
+}
+
 type lister struct {
-	funcsById map[uint32]map[*ListenFuncById]bool
+	funcsById   map[uint32][]*Listener
+	funcsByType map[uint32][]*Listener
+	funcsByTag  map[string][]*Listener
+	funcsOfAll  []*Listener
 }
 
 var Lister = newLister()
 
 func newLister() *lister {
 	return &lister{
-		funcsById: make(map[uint32]map[*ListenFuncById]bool),
+		funcsById:   make(map[uint32][]*Listener),
+		funcsByType: make(map[uint32][]*Listener),
+		funcsByTag:  make(map[string][]*Listener),
 	}
 }
 
-func (this *lister) trigger(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
-	funcsByIdOfId := this.funcsById[id]
-	if funcsByIdOfId != nil {
-		for fc, _ := range funcsByIdOfId {
-			(*fc)(store, id, operSymbol, value)
+func (this *lister) has() bool {
+	return (len(this.funcsById) > 0) || (len(this.funcsByType) > 0) || (len(this.funcsByTag) > 0) || (len(this.funcsOfAll) > 0)
+}
+
+// 按优先级合并全局和仓库的ID、类型、标签、全部监听后依次执行
+func triggerListen(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+	// 一般不超过8个监听列表，超过时才分配
+	var buf [8][]*Listener
+	lists := buf[:0]
+	for _, lst := range [2]*lister{Lister, store.Lister} {
+		if !lst.has() {
+			continue
+		}
+		if ls := lst.funcsById[id]; len(ls) > 0 {
+			lists = append(lists, ls)
+		}
+		if ls := lst.funcsByType[typeIdOf(id)]; len(ls) > 0 {
+			lists = append(lists, ls)
+		}
+		if len(lst.funcsByTag) > 0 {
+			if cfg := store.names.GetCfgById(id); cfg != nil {
+				for _, tag := range cfg.tags {
+					if ls := lst.funcsByTag[tag]; len(ls) > 0 {
+						lists = append(lists, ls)
+					}
+				}
+			}
+		}
+		if len(lst.funcsOfAll) > 0 {
+			lists = append(lists, lst.funcsOfAll)
+		}
+	}
+	count := len(lists)
+
+	var asyncs []*Listener
+	onListener := func(l *Listener) {
+		if l.limit != nil {
+			l.limit.onChg(store, id, operSymbol, value)
+		} else if l.opt.Async {
+			asyncs = append(asyncs, l)
+		} else {
+			l.call(store, id, operSymbol, value)
+		}
+	}
+	defer func() {
+		if len(asyncs) > 0 {
+			store.pushAsyncListen(id, operSymbol, value, asyncs)
+		}
+	}()
+
+	if count == 0 {
+		return
+	}
+	if count == 1 {
+		for _, l := range lists[0] {
+			onListener(l)
 		}
+		return
+	}
+
+	var posBuf [8]int
+	pos := posBuf[:]
+	if count > len(posBuf) {
+		pos = make([]int, count)
+	}
+	for {
+		sel := -1
+		for i := 0; i < count; i++ {
+			if pos[i] >= len(lists[i]) {
+				continue
+			}
+			if (sel == -1) || lists[i][pos[i]].before(lists[sel][pos[sel]]) {
+				sel = i
+			}
+		}
+		if sel == -1 {
+			return
+		}
+		l := lists[sel][pos[sel]]
+		pos[sel]++
+		onListener(l)
 	}
 }
 
-func (this *lister) AddById(id uint32, listerFunc ListenFuncById) *ListenFuncById {
-	if id == 0 {
-		panic("[dmp]lister.AddById => ID不能为0")
+// 写时复制，使触发过程中增删监听器不影响当前遍历
+func insertListener(olds []*Listener, l *Listener, errFlag string) []*Listener {
+	if l.opt.Name != "" {
+		for _, old := range olds {
+			if old.opt.Name == l.opt.Name {
+				panic(dmpText(errFlag, "listener_duplicate", l.opt.Name))
+			}
+		}
+	}
+
+	pos := len(olds)
+	for i, old := range olds {
+		if l.before(old) {
+			pos = i
+			break
+		}
+	}
+	news := make([]*Listener, 0, len(olds)+1)
+	news = append(news, olds[:pos]...)
+	news = append(news, l)
+	news = append(news, olds[pos:]...)
+	return news
+}
+
+func removeListener(olds []*Listener, l *Listener) ([]*Listener, bool) {
+	for i, old := range olds {
+		if old == l {
+			old.cancel()
+			news := make([]*Listener, 0, len(olds)-1)
+			news = append(news, olds[:i]...)
+			news = append(news, olds[i+1:]...)
+			return news, true
+		}
 	}
+	return olds, false
+}
 
-	funcsByIdOfId := this.funcsById[id]
-	if funcsByIdOfId == nil {
-		funcsByIdOfId = make(map[*ListenFuncById]bool)
-		this.funcsById[id] = funcsByIdOfId
+func (this *lister) newListener(kind int, key uint32, opt ListenOpt, listerFunc ListenFuncById) *Listener {
+	if listerFunc == nil {
+		panic(dmpText("lister.newListener", "listener_nil"))
+	}
+	listenSeq++
+	ret := &Listener{
+		kind:  kind,
+		key:   key,
+		opt:   opt,
+		seq:   listenSeq,
+		fn:    listerFunc,
+		owner: this,
+	}
+	if opt.limited() {
+		ret.limit = newListenLimit(opt, ret.deliver)
 	}
-	if funcsByIdOfId[&listerFunc] {
-		panic("[dmp]lister.AddById => 同一个ID不能重复相同的监听器")
+	return ret
+}
+
+func (this *lister) AddById(id uint32, listerFunc ListenFuncById) *Listener {
+	return this.AddByIdOpt(id, ListenOpt{}, listerFunc)
+}
+
+func (this *lister) AddByIdOpt(id uint32, opt ListenOpt, listerFunc ListenFuncById) *Listener {
+	if id == 0 {
+		panic(dmpText("lister.AddById", "listen_id_zero"))
 	}
 
-	funcsByIdOfId[&listerFunc] = true
-	return &listerFunc
+	ret := this.newListener(lkId, id, opt, listerFunc)
+	this.funcsById[id] = insertListener(this.funcsById[id], ret, "lister.AddById")
+	return ret
 }
 
-func (this *lister) AddByName(name string, listerFunc ListenFuncById) *ListenFuncById {
+func (this *lister) AddByName(name string, listerFunc ListenFuncById) *Listener {
 	return this.AddById(Names.GetIdByName(name), listerFunc)
 }
 
+func (this *lister) AddByNameOpt(name string, opt ListenOpt, listerFunc ListenFuncById) *Listener {
+	return this.AddByIdOpt(Names.GetIdByName(name), opt, listerFunc)
+}
+
+// 监听某类型的所有名字（包括监听之后才注册的名字）
+func (this *lister) AddByType(typ string, listerFunc ListenFuncById) *Listener {
+	return this.AddByTypeOpt(typ, ListenOpt{}, listerFunc)
+}
+
+func (this *lister) AddByTypeOpt(typ string, opt ListenOpt, listerFunc ListenFuncById) *Listener {
+	typeId := uint32(0)
+	if typ != OrderIdNameType {
+		typeId = Names.GetTypeId(typ)
+		if typeId == 0 {
+			panic(dmpText("lister.AddByType", "type_not_registered", typ))
+		}
+	}
+
+	ret := this.newListener(lkType, typeId, opt, listerFunc)
+	this.funcsByType[typeId] = insertListener(this.funcsByType[typeId], ret, "lister.AddByType")
+	return ret
+}
+
+// 监听带有某标签的所有名字（包括监听之后才加标签的名字）
+func (this *lister) AddByTag(tag string, listerFunc ListenFuncById) *Listener {
+	return this.AddByTagOpt(tag, ListenOpt{}, listerFunc)
+}
+
+func (this *lister) AddByTagOpt(tag string, opt ListenOpt, listerFunc ListenFuncById) *Listener {
+	if tag == "" {
+		panic(dmpText("lister.AddByTag", "listen_tag_empty"))
+	}
+
+	ret := this.newListener(lkTag, 0, opt, listerFunc)
+	ret.tag = tag
+	this.funcsByTag[tag] = insertListener(this.funcsByTag[tag], ret, "lister.AddByTag")
+	return ret
+}
+
+// 取消某标签的所有监听
+func (this *lister) DelByTag(tag string) {
+	for _, l := range this.funcsByTag[tag] {
+		l.cancel()
+	}
+	delete(this.funcsByTag, tag)
+}
+
+// 监听所有名字
+func (this *lister) AddAll(listerFunc ListenFuncById) *Listener {
+	return this.AddAllOpt(ListenOpt{}, listerFunc)
+}
+
+func (this *lister) AddAllOpt(opt ListenOpt, listerFunc ListenFuncById) *Listener {
+	ret := this.newListener(lkAll, 0, opt, listerFunc)
+	this.funcsOfAll = insertListener(this.funcsOfAll, ret, "lister.AddAll")
+	return ret
+}
+
 func (this *lister) Clear() {
-	this.funcsById = make(map[uint32]map[*ListenFuncById]bool)
+	for _, ls := range this.funcsById {
+		for _, l := range ls {
+			l.cancel()
+		}
+	}
+	for _, ls := range this.funcsByType {
+		for _, l := range ls {
+			l.cancel()
+		}
+	}
+	for _, ls := range this.funcsByTag {
+		for _, l := range ls {
+			l.cancel()
+		}
+	}
+	for _, l := range this.funcsOfAll {
+		l.cancel()
+	}
+	this.funcsById = make(map[uint32][]*Listener)
+	this.funcsByType = make(map[uint32][]*Listener)
+	this.funcsByTag = make(map[string][]*Listener)
+	this.funcsOfAll = nil
+}
+
+func (this *lister) DelById(id uint32, listerFunc *Listener) {
+	news, ok := removeListener(this.funcsById[id], listerFunc)
+	if !ok {
+		return
+	}
+	if len(news) == 0 {
+		delete(this.funcsById, id)
+	} else {
+		this.funcsById[id] = news
+	}
 }
 
-func (this *lister) DelById(id uint32, listerFunc *ListenFuncById) {
-	funcsByIdOfId := this.funcsById[id]
-	if funcsByIdOfId != nil {
-		delete(funcsByIdOfId, listerFunc)
+func (this *lister) Del(listerFunc *Listener) {
+	if (listerFunc == nil) || (listerFunc.owner != this) {
+		return
+	}
+
+	switch listerFunc.kind {
+	case lkId:
+		{
+			this.DelById(listerFunc.key, listerFunc)
+		}
+	case lkType:
+		{
+			news, ok := removeListener(this.funcsByType[listerFunc.key], listerFunc)
+			if !ok {
+				return
+			}
+			if len(news) == 0 {
+				delete(this.funcsByType, listerFunc.key)
+			} else {
+				this.funcsByType[listerFunc.key] = news
+			}
+		}
+	case lkTag:
+		{
+			news, ok := removeListener(this.funcsByTag[listerFunc.tag], listerFunc)
+			if !ok {
+				return
+			}
+			if len(news) == 0 {
+				delete(this.funcsByTag, listerFunc.tag)
+			} else {
+				this.funcsByTag[listerFunc.tag] = news
+			}
+		}
+	case lkAll:
+		{
+			this.funcsOfAll, _ = removeListener(this.funcsOfAll, listerFunc)
+		}
 	}
 }
@@ -0,0 +1,252 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// 测试用名字：名字表为全局的，已注册的直接返回ID，以便重复运行测试
+func testName(typ, name string) uint32 {
+	if id := Names.GetIdByName(name); id != 0 {
+		return id
+	}
+	return Names.RegisterName(typ, name, 0)
+}
+
+var testNameSeq = 0
+
+// 每次注册一个新名字，用于需要全新名字状态（如标签）的测试
+func newTestName(typ, prefix string) uint32 {
+	testNameSeq++
+	return Names.RegisterName(typ, fmt.Sprintf("%s%d", prefix, testNameSeq), 0)
+}
+
+func mustPanic(t *testing.T, fn func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("应该抛出异常")
+		}
+	}()
+	fn()
+}
+
+func TestListenOrder(t *testing.T) {
+	id := testName("测试监听", "监听顺序")
+
+	tests := []struct {
+		name  string
+		prios []int
+		want  []int
+	}{
+		{"注册顺序", []int{0, 0, 0}, []int{0, 1, 2}},
+		{"优先级", []int{LP_LOW, LP_HIGH, LP_NORMAL}, []int{1, 2, 0}},
+		{"同优先级按注册顺序", []int{LP_HIGH, LP_LOW, LP_HIGH, LP_LOW}, []int{0, 2, 1, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStorehouse(nil)
+			var got []int
+			for i, prio := range tt.prios {
+				i := i
+				store.Lister.AddByIdOpt(id, ListenOpt{Priority: prio}, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+					got = append(got, i)
+				})
+			}
+			for round := 0; round < 3; round++ {
+				got = nil
+				store.Set(id, float64(round))
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("第%d次：%v，应为%v", round, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestListenGlobalAndStoreMerged(t *testing.T) {
+	id := testName("测试监听", "监听合并")
+	store := NewStorehouse(nil)
+
+	var got []string
+	add := func(lst *lister, name string, prio int) *Listener {
+		return lst.AddByIdOpt(id, ListenOpt{Name: name, Priority: prio}, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+			got = append(got, name)
+		})
+	}
+	l1 := add(Lister, "全局普通", LP_NORMAL)
+	defer l1.Cancel()
+	add(store.Lister, "仓库高", LP_HIGH)
+	l2 := add(Lister, "全局低", LP_LOW)
+	defer l2.Cancel()
+	add(store.Lister, "仓库普通", LP_NORMAL)
+
+	store.Set(id, 1)
+	want := []string{"仓库高", "全局普通", "仓库普通", "全局低"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%v，应为%v", got, want)
+	}
+}
+
+func TestListenNameAndCancel(t *testing.T) {
+	id := testName("测试监听", "监听名称")
+	store := NewStorehouse(nil)
+
+	count := 0
+	fn := func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+		count++
+	}
+	l := store.Lister.AddByIdOpt(id, ListenOpt{Name: "界面刷新"}, fn)
+	if (l.Name() != "界面刷新") || (l.Id() != id) {
+		t.Fatalf("监听器信息错误：%s %d", l.Name(), l.Id())
+	}
+	mustPanic(t, func() {
+		store.Lister.AddByIdOpt(id, ListenOpt{Name: "界面刷新"}, fn)
+	})
+
+	store.Set(id, 1)
+	l.Cancel()
+	store.Set(id, 2)
+	if count != 1 {
+		t.Fatalf("回调%d次，应为1次", count)
+	}
+
+	// 取消后可以重新使用名称
+	store.Lister.AddByIdOpt(id, ListenOpt{Name: "界面刷新"}, fn)
+	store.Set(id, 3)
+	if count != 2 {
+		t.Fatalf("回调%d次，应为2次", count)
+	}
+}
+
+func TestListenCancelDuringTrigger(t *testing.T) {
+	id := testName("测试监听", "监听中取消")
+	store := NewStorehouse(nil)
+
+	var got []int
+	var second *Listener
+	store.Lister.AddById(id, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+		got = append(got, 1)
+		second.Cancel()
+	})
+	second = store.Lister.AddById(id, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+		got = append(got, 2)
+	})
+
+	store.Set(id, 1)
+	store.Set(id, 2)
+	if want := []int{1, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("%v，应为%v", got, want)
+	}
+}
+
+func TestListenWildcard(t *testing.T) {
+	testName("测试通配甲", "通配甲1")
+	testName("测试通配甲", "通配甲2")
+	testName("测试通配乙", "通配乙1")
+	Names.RegisterTagByName("通配甲1", "审计")
+	Names.RegisterTagByName("通配乙1", "审计", "同步")
+
+	tests := []struct {
+		name string
+		add  func(lst *lister, fn ListenFuncById) *Listener
+		want []string
+	}{
+		{"按类型", func(lst *lister, fn ListenFuncById) *Listener {
+			return lst.AddByType("测试通配甲", fn)
+		}, []string{"通配甲1", "通配甲2"}},
+		{"按标签", func(lst *lister, fn ListenFuncById) *Listener {
+			return lst.AddByTag("审计", fn)
+		}, []string{"通配甲1", "通配乙1"}},
+		{"按另一标签", func(lst *lister, fn ListenFuncById) *Listener {
+			return lst.AddByTag("同步", fn)
+		}, []string{"通配乙1"}},
+		{"全部", func(lst *lister, fn ListenFuncById) *Listener {
+			return lst.AddAll(fn)
+		}, []string{"通配甲1", "通配甲2", "通配乙1"}},
+	}
+	for _, tt := range tests {
+		for _, global := range []bool{false, true} {
+			t.Run(tt.name, func(t *testing.T) {
+				store := NewStorehouse(nil)
+				lst := store.Lister
+				if global {
+					lst = Lister
+				}
+				var got []string
+				l := tt.add(lst, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+					got = append(got, store.names.GetNameById(id))
+				})
+				for _, name := range []string{"通配甲1", "通配甲2", "通配乙1"} {
+					store.Set(Names.GetIdByName(name), 1)
+				}
+				l.Cancel()
+				store.Set(Names.GetIdByName("通配甲1"), 2)
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("%v，应为%v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestListenTagLateAndDel(t *testing.T) {
+	id := newTestName("测试标签", "标签后加")
+	store := NewStorehouse(nil)
+
+	count := 0
+	fn := func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+		count++
+	}
+	store.Lister.AddByTag("后加标签", fn)
+	store.Lister.AddByTagOpt("后加标签", ListenOpt{Priority: LP_HIGH}, fn)
+	store.Set(id, 1)
+	if count != 0 {
+		t.Fatalf("未加标签时回调了%d次", count)
+	}
+
+	Names.RegisterTagById(id, "后加标签", "后加标签")
+	if tags := Names.GetTagsById(id); !reflect.DeepEqual(tags, []string{"后加标签"}) {
+		t.Fatalf("标签：%v", tags)
+	}
+	store.Set(id, 2)
+	if count != 2 {
+		t.Fatalf("回调%d次，应为2次", count)
+	}
+
+	store.Lister.DelByTag("后加标签")
+	store.Set(id, 3)
+	if count != 2 {
+		t.Fatalf("取消后仍然回调，共%d次", count)
+	}
+}
+
+func TestListenTagByType(t *testing.T) {
+	before := testName("测试类型标签", "类型标签前")
+	Names.RegisterTagByType("测试类型标签", "类型标签")
+	after := testName("测试类型标签", "类型标签后")
+
+	for _, id := range []uint32{before, after} {
+		if tags := Names.GetTagsById(id); !reflect.DeepEqual(tags, []string{"类型标签"}) {
+			t.Fatalf("%s的标签：%v", Names.GetNameById(id), tags)
+		}
+	}
+	mustPanic(t, func() {
+		Names.RegisterTagByType("未注册的类型", "x")
+	})
+	mustPanic(t, func() {
+		Lister.AddByTag("", func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {})
+	})
+}
@@ -0,0 +1,186 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+// 监听防抖、节流及批次合并(Listening Debounce, Throttle and Coalescing)
+
+// 处理顺序：批次合并 => 防抖 => 节流 => 回调；经过合并或防抖的回调以OS_SET携带数据最新值
+
+import (
+	"sync"
+	"time"
+)
+
+func (this *ListenOpt) limited() bool {
+	return this.Coalesce || (this.Debounce > 0) || ((this.ThrottleCount > 0) && (this.ThrottleInterval > 0))
+}
+
+type limitKey struct {
+	store *Storehouse
+	id    uint32
+}
+
+type limitState struct {
+	limit       *listenLimit
+	key         limitKey
+	value       float64
+	timer       Timer
+	inBatch     bool
+	windowStart time.Time
+	count       int
+}
+
+type listenLimit struct {
+	mutex  sync.Mutex
+	opt    ListenOpt
+	states map[limitKey]*limitState
+	out    func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64)
+}
+
+func newListenLimit(opt ListenOpt, out func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64)) *listenLimit {
+	return &listenLimit{
+		opt:    opt,
+		states: make(map[limitKey]*limitState),
+		out:    out,
+	}
+}
+
+func (this *listenLimit) state(key limitKey) *limitState {
+	ret := this.states[key]
+	if ret == nil {
+		ret = &limitState{limit: this, key: key}
+		this.states[key] = ret
+	}
+	return ret
+}
+
+// 没有待处理的合并、防抖以及节流计数时释放状态
+func (this *listenLimit) release(st *limitState) {
+	if st.inBatch || (st.timer != nil) || (st.count > 0) {
+		return
+	}
+	delete(this.states, st.key)
+}
+
+func (this *listenLimit) onChg(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+	if this.opt.Coalesce && (store.batchDepth > 0) {
+		this.mutex.Lock()
+		st := this.state(limitKey{store: store, id: id})
+		if !st.inBatch {
+			st.inBatch = true
+			store.batchPending = append(store.batchPending, st)
+		}
+		this.mutex.Unlock()
+		return
+	}
+	this.debounce(store, id, operSymbol, value)
+}
+
+func (this *listenLimit) endBatch(st *limitState) {
+	this.mutex.Lock()
+	st.inBatch = false
+	this.release(st)
+	this.mutex.Unlock()
+
+	store := st.key.store
+	this.debounce(store, st.key.id, OS_SET, store.Get(st.key.id))
+}
+
+func (this *listenLimit) debounce(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+	if this.opt.Debounce <= 0 {
+		this.throttle(store, id, operSymbol, value)
+		return
+	}
+
+	if operSymbol != OS_SET {
+		value = store.Get(id)
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	st := this.state(limitKey{store: store, id: id})
+	st.value = value
+	if st.timer != nil {
+		st.timer.Stop()
+	}
+
+	var timer Timer
+	timer = store.afterFunc(this.opt.clock(), this.opt.Debounce, func() {
+		this.mutex.Lock()
+		if st.timer != timer {
+			this.mutex.Unlock()
+			return
+		}
+		st.timer = nil
+		value := st.value
+		this.release(st)
+		this.mutex.Unlock()
+
+		this.throttle(store, id, OS_SET, value)
+	})
+	st.timer = timer
+}
+
+func (this *listenLimit) throttle(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+	if (this.opt.ThrottleCount > 0) && (this.opt.ThrottleInterval > 0) {
+		now := this.opt.clock().Now()
+
+		this.mutex.Lock()
+		st := this.state(limitKey{store: store, id: id})
+		if (st.count == 0) || (now.Sub(st.windowStart) >= this.opt.ThrottleInterval) {
+			st.windowStart = now
+			st.count = 0
+		}
+		st.count++
+		over := st.count > this.opt.ThrottleCount
+		this.mutex.Unlock()
+
+		if over {
+			return
+		}
+	}
+
+	this.out(store, id, operSymbol, value)
+}
+
+func (this *listenLimit) stop() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for _, st := range this.states {
+		if st.timer != nil {
+			st.timer.Stop()
+			st.timer = nil
+		}
+	}
+	this.states = make(map[limitKey]*limitState)
+}
+
+// 批次：ExecOper/ExecProc执行期间的变化，合并监听在批次结束时统一回调
+func (this *Storehouse) beginBatch() {
+	this.batchDepth++
+}
+
+func (this *Storehouse) endBatch() {
+	this.batchDepth--
+	if this.batchDepth > 0 {
+		return
+	}
+
+	for len(this.batchPending) > 0 {
+		pending := this.batchPending
+		this.batchPending = nil
+		for _, st := range pending {
+			st.limit.endBatch(st)
+		}
+	}
+}
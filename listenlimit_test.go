@@ -0,0 +1,161 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type limitGot struct {
+	operSymbol OperSymbol
+	value      float64
+}
+
+func TestListenLimit(t *testing.T) {
+	id := testName("测试限流", "限流数据")
+
+	type step struct {
+		set     float64
+		advance time.Duration
+	}
+	tests := []struct {
+		name  string
+		opt   ListenOpt
+		steps []step
+		want  []limitGot
+	}{
+		{"防抖", ListenOpt{Debounce: time.Second},
+			[]step{{1, 0}, {2, 500 * time.Millisecond}, {3, 999 * time.Millisecond}, {4, 0}, {-1, time.Second}},
+			[]limitGot{{OS_SET, 4}}},
+		{"防抖分两次", ListenOpt{Debounce: time.Second},
+			[]step{{1, time.Second}, {2, time.Second}},
+			[]limitGot{{OS_SET, 1}, {OS_SET, 2}}},
+		{"节流", ListenOpt{ThrottleCount: 2, ThrottleInterval: time.Second},
+			[]step{{1, 0}, {2, 0}, {3, 0}, {4, time.Second}, {5, 0}, {6, 0}},
+			[]limitGot{{OS_SET, 1}, {OS_SET, 2}, {OS_SET, 5}, {OS_SET, 6}}},
+		{"防抖加节流", ListenOpt{Debounce: time.Second, ThrottleCount: 1, ThrottleInterval: time.Hour},
+			[]step{{1, time.Second}, {2, time.Second}},
+			[]limitGot{{OS_SET, 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := NewTickClock(testStart)
+			tt.opt.Clock = clk
+			store := NewStorehouse(nil)
+
+			var got []limitGot
+			store.Lister.AddByIdOpt(id, tt.opt, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+				got = append(got, limitGot{operSymbol, value})
+			})
+			for _, st := range tt.steps {
+				if st.set >= 0 {
+					store.Set(id, st.set)
+				}
+				clk.Advance(st.advance)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("%v，应为%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListenCoalesce(t *testing.T) {
+	testName("测试限流", "合并数据")
+	store := NewStorehouse(nil)
+
+	var got []limitGot
+	store.Lister.AddByNameOpt("合并数据", ListenOpt{Coalesce: true}, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+		got = append(got, limitGot{operSymbol, value})
+	})
+
+	opers, err := ParseOperExp("合并数据=1\n合并数据+=2\n合并数据*=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	WorkStat.ExecOper(store, opers, false)
+	if want := []limitGot{{OS_SET, 15}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("%v，应为%v", got, want)
+	}
+
+	// 不在批次中时直接回调
+	store.Set(Names.GetIdByName("合并数据"), 3)
+	if len(got) != 2 {
+		t.Fatalf("批次外的变化应直接回调：%v", got)
+	}
+}
+
+// 系统时钟的防抖回调投递到仓库，由拥有者调用Pump执行，不会在定时器协程中读写仓库
+func TestListenDebouncePump(t *testing.T) {
+	id := testName("测试限流", "投递数据")
+	store := NewStorehouse(nil)
+	notify := make(chan bool, 1)
+	store.SetPumpNotify(func() {
+		select {
+		case notify <- true:
+		default:
+		}
+	})
+
+	var got []limitGot
+	store.Lister.AddByIdOpt(id, ListenOpt{Debounce: 20 * time.Millisecond, Clock: sysClock{}}, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+		got = append(got, limitGot{operSymbol, store.Get(id)})
+	})
+	for i := 1; i <= 3; i++ {
+		store.Set(id, float64(i))
+	}
+
+	select {
+	case <-notify:
+	case <-time.After(5 * time.Second):
+		t.Fatal("没有收到定时回调通知")
+	}
+	if len(got) != 0 {
+		t.Fatal("回调应在Pump中执行")
+	}
+	if n := store.Pump(); n == 0 {
+		t.Fatal("Pump没有执行回调")
+	}
+	if want := []limitGot{{OS_SET, 3}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("%v，应为%v", got, want)
+	}
+}
+
+func TestCondListenDebounce(t *testing.T) {
+	id := testName("测试限流", "条件防抖")
+	cond, err := ParseCondExp("条件防抖>10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := NewTickClock(testStart)
+	store := NewStorehouse(nil)
+
+	var got []bool
+	opt := CondOpt{ListenOpt: ListenOpt{Debounce: time.Second, Clock: clk}, Edge: CE_BOTH}
+	WorkStat.ListenCondOpt(store, cond, opt, func(store *Storehouse, state bool, ctx any) {
+		got = append(got, state)
+	}, nil)
+
+	store.Set(id, 20)
+	store.Set(id, 5)
+	store.Set(id, 30)
+	clk.Advance(time.Second)
+	store.Set(id, 1)
+	clk.Advance(time.Second)
+	if want := []bool{true, false}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("%v，应为%v", got, want)
+	}
+}
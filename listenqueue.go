@@ -0,0 +1,202 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+// 异步监听队列(Asynchronous Listening Queue)
+
+// 异步监听回调在仓库的工作协程中按变化顺序执行，回调中不应再读写该仓库数据；
+// 阻塞策略下回调中再修改该仓库会因等待自身队列而死锁。
+
+import (
+	"sync"
+)
+
+// 队列满时的处理策略
+type QueuePolicy uint
+
+const (
+	// 阻塞直到队列有空位
+	QP_BLOCK QueuePolicy = iota
+	// 丢弃最早的变化
+	QP_DROP_OLDEST
+	// 同一ID的待处理变化合并为一个（以OS_SET携带最新值），无可合并时阻塞
+	QP_COALESCE
+)
+
+const defaultQueueSize = 1024
+
+type asyncEvent struct {
+	id         uint32
+	operSymbol OperSymbol
+	value      float64
+	listeners  []*Listener
+}
+
+type listenQueue struct {
+	store     *Storehouse
+	mutex     sync.Mutex
+	notFull   *sync.Cond
+	idle      *sync.Cond
+	policy    QueuePolicy
+	ring      []asyncEvent
+	head      uint64
+	tail      uint64
+	seqOfId   map[uint32]uint64
+	running   bool
+	dropCount uint64
+}
+
+func newListenQueue(store *Storehouse, size int, policy QueuePolicy) *listenQueue {
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	ret := &listenQueue{
+		store:   store,
+		policy:  policy,
+		ring:    make([]asyncEvent, size),
+		seqOfId: make(map[uint32]uint64),
+	}
+	ret.notFull = sync.NewCond(&ret.mutex)
+	ret.idle = sync.NewCond(&ret.mutex)
+	return ret
+}
+
+func (this *listenQueue) at(seq uint64) *asyncEvent {
+	return &this.ring[seq%uint64(len(this.ring))]
+}
+
+func (this *listenQueue) full() bool {
+	return this.tail-this.head >= uint64(len(this.ring))
+}
+
+func (this *listenQueue) popHead() asyncEvent {
+	evt := this.at(this.head)
+	ret := *evt
+	*evt = asyncEvent{}
+	if this.seqOfId[ret.id] == this.head {
+		delete(this.seqOfId, ret.id)
+	}
+	this.head++
+	return ret
+}
+
+func (this *listenQueue) push(evt asyncEvent) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.policy == QP_COALESCE {
+		if seq, ok := this.seqOfId[evt.id]; ok {
+			*this.at(seq) = evt
+			return
+		}
+	}
+
+	for this.full() {
+		if this.policy == QP_DROP_OLDEST {
+			this.popHead()
+			this.dropCount++
+			break
+		}
+		this.notFull.Wait()
+	}
+
+	this.seqOfId[evt.id] = this.tail
+	*this.at(this.tail) = evt
+	this.tail++
+
+	if !this.running {
+		this.running = true
+		go this.work()
+	}
+}
+
+// 队列处理完即退出工作协程，有新变化时再启动，闲置仓库不占用协程
+func (this *listenQueue) work() {
+	this.mutex.Lock()
+	for this.head < this.tail {
+		evt := this.popHead()
+		this.notFull.Signal()
+		this.mutex.Unlock()
+
+		for _, l := range evt.listeners {
+			this.call(l, evt)
+		}
+
+		this.mutex.Lock()
+	}
+	this.running = false
+	this.idle.Broadcast()
+	this.mutex.Unlock()
+}
+
+func (this *listenQueue) call(l *Listener, evt asyncEvent) {
+	defer func() {
+		if err := recover(); err != nil {
+			writeLog(&LogEntry{Level: LL_ERROR, Code: "async_callback_failed", Args: []any{err, l.opt.Name},
+				Flag: "listenQueue.work", Name: this.store.names.GetNameById(evt.id), Id: evt.id, Err: err})
+		}
+	}()
+	l.call(this.store, evt.id, evt.operSymbol, evt.value)
+}
+
+func (this *listenQueue) flush() {
+	this.mutex.Lock()
+	for this.running || (this.head < this.tail) {
+		this.idle.Wait()
+	}
+	this.mutex.Unlock()
+}
+
+func (this *listenQueue) dropped() uint64 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.dropCount
+}
+
+// 设置异步监听队列的容量和满时策略，应在产生数据变化前设置
+func (this *Storehouse) SetListenQueue(size int, policy QueuePolicy) {
+	if this.listenQueue != nil {
+		this.listenQueue.flush()
+	}
+	this.listenQueue = newListenQueue(this, size, policy)
+}
+
+func (this *Storehouse) pushAsyncListen(id uint32, operSymbol OperSymbol, value float64, listeners []*Listener) {
+	if this.listenQueue == nil {
+		this.listenQueue = newListenQueue(this, defaultQueueSize, QP_BLOCK)
+	}
+	if this.listenQueue.policy == QP_COALESCE {
+		operSymbol = OS_SET
+		value = this.Get(id)
+	}
+	this.listenQueue.push(asyncEvent{
+		id:         id,
+		operSymbol: operSymbol,
+		value:      value,
+		listeners:  listeners,
+	})
+}
+
+// 等待异步监听队列处理完毕
+func (this *Storehouse) Flush() {
+	if this.listenQueue != nil {
+		this.listenQueue.flush()
+	}
+}
+
+// 因队列满而丢弃的变化数量
+func (this *Storehouse) ListenDropped() uint64 {
+	if this.listenQueue == nil {
+		return 0
+	}
+	return this.listenQueue.dropped()
+}
@@ -0,0 +1,114 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type asyncGot struct {
+	id    uint32
+	value float64
+}
+
+func TestListenAsyncOrder(t *testing.T) {
+	id := testName("测试异步", "异步顺序")
+	store := NewStorehouse(nil)
+
+	var got []float64
+	store.Lister.AddByIdOpt(id, ListenOpt{Async: true}, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+		got = append(got, value)
+	})
+	var want []float64
+	for i := 1; i <= 200; i++ {
+		store.Set(id, float64(i))
+		want = append(want, float64(i))
+	}
+	store.Flush()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("异步回调顺序错误：%v", got)
+	}
+}
+
+func TestListenQueuePolicy(t *testing.T) {
+	a := testName("测试异步", "异步策略甲")
+	b := testName("测试异步", "异步策略乙")
+
+	tests := []struct {
+		name    string
+		policy  QueuePolicy
+		sets    []asyncGot
+		want    []asyncGot
+		dropped uint64
+	}{
+		{"阻塞", QP_BLOCK,
+			[]asyncGot{{a, 1}, {a, 2}, {a, 3}, {a, 4}, {a, 5}},
+			[]asyncGot{{a, 1}, {a, 2}, {a, 3}, {a, 4}, {a, 5}}, 0},
+		{"丢弃最早", QP_DROP_OLDEST,
+			[]asyncGot{{a, 1}, {a, 2}, {a, 3}, {a, 4}, {a, 5}},
+			[]asyncGot{{a, 1}, {a, 4}, {a, 5}}, 2},
+		{"按ID合并", QP_COALESCE,
+			[]asyncGot{{a, 1}, {a, 2}, {b, 1}, {a, 3}, {b, 2}},
+			[]asyncGot{{a, 1}, {a, 3}, {b, 2}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStorehouse(nil)
+			store.SetListenQueue(2, tt.policy)
+
+			// 第一个回调阻塞工作协程，使后续变化积压在队列中
+			started := make(chan bool)
+			gate := make(chan bool)
+			var once sync.Once
+			var got []asyncGot
+			fn := func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+				once.Do(func() {
+					started <- true
+					<-gate
+				})
+				got = append(got, asyncGot{id, value})
+			}
+			store.Lister.AddByIdOpt(a, ListenOpt{Async: true}, fn)
+			store.Lister.AddByIdOpt(b, ListenOpt{Async: true}, fn)
+
+			store.Set(tt.sets[0].id, tt.sets[0].value)
+			<-started
+			if tt.policy == QP_BLOCK {
+				// 队列满时Set阻塞，放行回调后才能继续
+				done := make(chan bool)
+				go func() {
+					for _, set := range tt.sets[1:] {
+						store.Set(set.id, set.value)
+					}
+					close(done)
+				}()
+				close(gate)
+				<-done
+			} else {
+				for _, set := range tt.sets[1:] {
+					store.Set(set.id, set.value)
+				}
+				close(gate)
+			}
+			store.Flush()
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("%v，应为%v", got, tt.want)
+			}
+			if store.ListenDropped() != tt.dropped {
+				t.Fatalf("丢弃%d个，应为%d个", store.ListenDropped(), tt.dropped)
+			}
+		})
+	}
+}
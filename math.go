@@ -21,336 +21,337 @@ func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Trunc(params[0].Float64(store))
 	}
-	RegisterFunc("Trunc", funcExec, 1)
+	RegisterPureFunc("Trunc", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.NaN()
 	}
-	RegisterFunc("NaN", funcExec, 0)
+	RegisterPureFunc("NaN", funcExec, 0)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
-		return math.Inf(int(params[0].Float64(store)))
+		return math.Inf(1)
 	}
-	RegisterFunc("Inf", funcExec, 0)
+	// 正无穷，负无穷为-Inf()
+	RegisterPureFunc("Inf", funcExec, 0)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Cbrt(params[0].Float64(store))
 	}
-	RegisterFunc("Cbrt", funcExec, 1)
+	RegisterPureFunc("Cbrt", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Sqrt(params[0].Float64(store))
 	}
-	RegisterFunc("Sqrt", funcExec, 1)
+	RegisterPureFunc("Sqrt", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Hypot(params[0].Float64(store), params[1].Float64(store))
 	}
-	RegisterFunc("Hypot", funcExec, 2)
+	RegisterPureFunc("Hypot", funcExec, 2)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Sin(params[0].Float64(store))
 	}
-	RegisterFunc("Sin", funcExec, 1)
+	RegisterPureFunc("Sin", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Cos(params[0].Float64(store))
 	}
-	RegisterFunc("Cos", funcExec, 1)
+	RegisterPureFunc("Cos", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Tan(params[0].Float64(store))
 	}
-	RegisterFunc("Tan", funcExec, 1)
+	RegisterPureFunc("Tan", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Log(params[0].Float64(store))
 	}
-	RegisterFunc("Log", funcExec, 1)
+	RegisterPureFunc("Log", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Log2(params[0].Float64(store))
 	}
-	RegisterFunc("Log2", funcExec, 1)
+	RegisterPureFunc("Log2", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Log10(params[0].Float64(store))
 	}
-	RegisterFunc("Log10", funcExec, 1)
+	RegisterPureFunc("Log10", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Log1p(params[0].Float64(store))
 	}
-	RegisterFunc("Log1p", funcExec, 1)
+	RegisterPureFunc("Log1p", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Logb(params[0].Float64(store))
 	}
-	RegisterFunc("Logb", funcExec, 1)
+	RegisterPureFunc("Logb", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return float64(math.Ilogb(params[0].Float64(store)))
 	}
-	RegisterFunc("Ilogb", funcExec, 1)
+	RegisterPureFunc("Ilogb", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Abs(params[0].Float64(store))
 	}
-	RegisterFunc("Abs", funcExec, 1)
+	RegisterPureFunc("Abs", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Floor(params[0].Float64(store))
 	}
-	RegisterFunc("Floor", funcExec, 1)
+	RegisterPureFunc("Floor", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Ceil(params[0].Float64(store))
 	}
-	RegisterFunc("Ceil", funcExec, 1)
+	RegisterPureFunc("Ceil", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Mod(params[0].Float64(store), params[1].Float64(store))
 	}
-	RegisterFunc("Mod", funcExec, 2)
+	RegisterPureFunc("Mod", funcExec, 2)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Pow(params[0].Float64(store), params[1].Float64(store))
 	}
-	RegisterFunc("Pow", funcExec, 2)
+	RegisterPureFunc("Pow", funcExec, 2)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Copysign(params[0].Float64(store), params[1].Float64(store))
 	}
-	RegisterFunc("Copysign", funcExec, 2)
+	RegisterPureFunc("Copysign", funcExec, 2)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Nextafter(params[0].Float64(store), params[1].Float64(store))
 	}
-	RegisterFunc("Nextafter", funcExec, 2)
+	RegisterPureFunc("Nextafter", funcExec, 2)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Remainder(params[0].Float64(store), params[1].Float64(store))
 	}
-	RegisterFunc("Remainder", funcExec, 2)
+	RegisterPureFunc("Remainder", funcExec, 2)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Dim(params[0].Float64(store), params[1].Float64(store))
 	}
-	RegisterFunc("Dim", funcExec, 2)
+	RegisterPureFunc("Dim", funcExec, 2)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Asin(params[0].Float64(store))
 	}
-	RegisterFunc("Asin", funcExec, 1)
+	RegisterPureFunc("Asin", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Acos(params[0].Float64(store))
 	}
-	RegisterFunc("Acos", funcExec, 1)
+	RegisterPureFunc("Acos", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Atan2(params[0].Float64(store), params[1].Float64(store))
 	}
-	RegisterFunc("Atan2", funcExec, 2)
+	RegisterPureFunc("Atan2", funcExec, 2)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Atan(params[0].Float64(store))
 	}
-	RegisterFunc("Atan", funcExec, 1)
+	RegisterPureFunc("Atan", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Sinh(params[0].Float64(store))
 	}
-	RegisterFunc("Sinh", funcExec, 1)
+	RegisterPureFunc("Sinh", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Cosh(params[0].Float64(store))
 	}
-	RegisterFunc("Cosh", funcExec, 1)
+	RegisterPureFunc("Cosh", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Tanh(params[0].Float64(store))
 	}
-	RegisterFunc("Tanh", funcExec, 1)
+	RegisterPureFunc("Tanh", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Asinh(params[0].Float64(store))
 	}
-	RegisterFunc("Asinh", funcExec, 1)
+	RegisterPureFunc("Asinh", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Acosh(params[0].Float64(store))
 	}
-	RegisterFunc("Acosh", funcExec, 1)
+	RegisterPureFunc("Acosh", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Atanh(params[0].Float64(store))
 	}
-	RegisterFunc("Atanh", funcExec, 1)
+	RegisterPureFunc("Atanh", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Ldexp(params[0].Float64(store), int(params[1].Float64(store)))
 	}
-	RegisterFunc("Ldexp", funcExec, 2)
+	RegisterPureFunc("Ldexp", funcExec, 2)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Exp(params[0].Float64(store))
 	}
-	RegisterFunc("Exp", funcExec, 1)
+	RegisterPureFunc("Exp", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Exp2(params[0].Float64(store))
 	}
-	RegisterFunc("Exp2", funcExec, 1)
+	RegisterPureFunc("Exp2", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Expm1(params[0].Float64(store))
 	}
-	RegisterFunc("Expm1", funcExec, 1)
+	RegisterPureFunc("Expm1", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Pow10(int(params[0].Float64(store)))
 	}
-	RegisterFunc("Pow10", funcExec, 1)
+	RegisterPureFunc("Pow10", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Gamma(params[0].Float64(store))
 	}
-	RegisterFunc("Gamma", funcExec, 1)
+	RegisterPureFunc("Gamma", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Erf(params[0].Float64(store))
 	}
-	RegisterFunc("Erf", funcExec, 1)
+	RegisterPureFunc("Erf", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Erfc(params[0].Float64(store))
 	}
-	RegisterFunc("Erfc", funcExec, 1)
+	RegisterPureFunc("Erfc", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.J0(params[0].Float64(store))
 	}
-	RegisterFunc("J0", funcExec, 1)
+	RegisterPureFunc("J0", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.J1(params[0].Float64(store))
 	}
-	RegisterFunc("J1", funcExec, 1)
+	RegisterPureFunc("J1", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Jn(int(params[0].Float64(store)), params[1].Float64(store))
 	}
-	RegisterFunc("Jn", funcExec, 2)
+	RegisterPureFunc("Jn", funcExec, 2)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Y0(params[0].Float64(store))
 	}
-	RegisterFunc("Y0", funcExec, 1)
+	RegisterPureFunc("Y0", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Y1(params[0].Float64(store))
 	}
-	RegisterFunc("Y1", funcExec, 1)
+	RegisterPureFunc("Y1", funcExec, 1)
 }
 
 func init() {
 	funcExec := func(store *Storehouse, params []FrmlExp) float64 {
 		return math.Yn(int(params[0].Float64(store)), params[1].Float64(store))
 	}
-	RegisterFunc("Yn", funcExec, 2)
+	RegisterPureFunc("Yn", funcExec, 2)
 }
 
 func init() {
@@ -376,4 +377,5 @@ func init() {
 		return values[rid]
 	}
 	Names.RegisterGetFuncByType(typ, getMathConst)
+	Names.RegisterConstByType(typ)
 }
@@ -0,0 +1,304 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+// 消息与日志(Messages & Logging)
+//
+// 错误、异常及日志消息按稳定的消息代码在消息目录中查找，按引擎语言（中文、英文）格式化；
+// 日志以*LogEntry交给Logger，带有级别、消息代码、出处、表达式、数据名及ID等字段，便于日志工具检索
+
+import (
+	"fmt"
+	"log"
+)
+
+type Lang uint
+
+const (
+	// 中文（默认）
+	LANG_ZH Lang = iota
+	// 英文
+	LANG_EN
+	langCount
+)
+
+var lang = LANG_ZH
+
+// 设置引擎语言，无效语言按中文处理。
+// 与定点模式、运算异常策略一样，语言为整个引擎（本包）共用，不区分仓库，须在使用前设置；
+// 需要按仓库或日志输出不同语言时，用错误、日志的消息代码及参数调用MessageIn生成
+func SetLang(value Lang) {
+	if value >= langCount {
+		value = LANG_ZH
+	}
+	lang = value
+}
+
+func GetLang() Lang {
+	return lang
+}
+
+// 消息目录：消息代码 => 各语言的格式串，英文缺失时取中文
+var messages = map[string][langCount]string{
+	// 解析错误
+	"parse_syntax":          {"语法错误", "syntax error"},
+	"parse_name":            {"名称错误", "name error"},
+	"parse_number":          {"数值错误", "number error"},
+	"parse_func":            {"函数错误", "function error"},
+	"parse_type":            {"类型错误", "type error"},
+	"parse_at_token":        {"%s，在第%d行第%d列字符[%s]附近，源串：%s", "%[1]s, near [%[4]s] at line %[2]d, column %[3]d, source: %[5]s"},
+	"parse_at":              {"%s，在第%d行第%d列附近，源串：%s", "%s, near line %d, column %d, source: %s"},
+	"trailing_chars":        {"表达式后面有多余字符", "unexpected characters after the expression"},
+	"extra_param_separator": {"此处出现多余函数参数分隔符：%s", "unexpected function parameter separator: %s"},
+	"compare_missing_left":  {"比较符“%s”缺失左值", "comparator \"%s\" is missing its left operand"},
+	"compare_missing_right": {"比较符“%s”缺失右值", "comparator \"%s\" is missing its right operand"},
+	"compare_chained":       {"比较符不能连用，请用&&或||连接多个比较", "comparators cannot be chained, join comparisons with && or ||"},
+	"invalid_comparator":    {"无效比较符：%s", "invalid comparator: %s"},
+	"not_without_in":        {"not后面缺失in", "\"not\" must be followed by \"in\""},
+	"in_missing_lparen":     {"in后面缺失集合左括号", "\"in\" must be followed by \"(\""},
+	"in_empty":              {"in的集合不能为空", "the set of \"in\" cannot be empty"},
+	"in_missing_rparen":     {"集合缺失右括号", "the set is missing \")\""},
+	"between_missing_and":   {"between缺失and", "\"between\" is missing \"and\""},
+	"missing_value":         {"缺失值表达式", "missing value expression"},
+	"missing_rparen":        {"缺失右括号", "missing \")\""},
+	"unexpected_rparen":     {"此处不应该出现右括号", "unexpected \")\""},
+	"unexpected_lparen":     {"此处不应该出现左括号", "unexpected \"(\""},
+	"extra_rparen":          {"多余右括号", "extra \")\""},
+	"extra_space":           {"此处出现多余空格", "unexpected space"},
+	"oper_missing_left":     {"运算符“%s”缺失左值", "operator \"%s\" is missing its left operand"},
+	"double_slash":          {"“//”不是运算符，整除请使用Div(a, b)", "\"//\" is not an operator, use Div(a, b) for integer division"},
+	"paren_comment":         {"括号内的“//”是注释，括号未闭合；整除请使用Div(a, b)", "\"//\" inside parentheses starts a comment and leaves them unclosed, use Div(a, b) for integer division"},
+	"oper_missing_name":     {"符号“%s”前缺失数据名", "missing data name before \"%s\""},
+	"oper_missing_value":    {"数据“%s”缺失值表达式", "data \"%s\" is missing its value expression"},
+	"oper_missing_equal":    {"符号“%s”后面缺失等号“=”", "\"%s\" must be followed by \"=\""},
+	"name_trailing_chars":   {"名字“%s”后面出现多余字符", "unexpected characters after name \"%s\""},
+	"empty_oper":            {"无效运算表达式", "invalid operation expression"},
+	"invalid_name":          {"无效数据名：%s", "invalid data name: %s"},
+	"invalid_value_name":    {"无效值名：%s", "invalid value name: %s"},
+	"undefined_func":        {"未定义的函数：%s", "undefined function: %s"},
+	"undefined_cond":        {"未注册的条件：%s", "unregistered condition: %s"},
+	"invalid_func_name":     {"无效函数名称：%s", "invalid function name: %s"},
+	"number_out_of_range":   {"数值超出范围：%s", "number out of range: %s"},
+	"invalid_number":        {"无效数值：%s（支持十进制、科学计数法、0x十六进制、0b二进制，数字间可用单个下划线分隔）", "invalid number: %s (decimal, scientific notation, 0x hexadecimal and 0b binary are supported, digits may be separated by single underscores)"},
+	"func_param_count":      {"%s函数参数必须为%d个，当前为：%d", "function %s requires %d parameters, got: %d"},
+	"random_param_count":    {"不合法的random函数参数个数：%d", "invalid number of random parameters: %d"},
+	"return_missing_params": {"return函数缺失参数", "return is missing its parameters"},
+	"return_too_many":       {"return函数的参数数量不能超过2个", "return accepts at most 2 parameters"},
+	"func_error":            {"%v", "%v"},
+	"decimal_float_func":    {"定点数“%s”不能直接用于浮点函数%s，请用Float(%s)显式转换", "decimal \"%s\" cannot be passed to float function %s directly, convert it with Float(%s)"},
+	"func_registered":       {"函数“%s”已经被注册", "function \"%s\" is already registered"},
+
+	// JSON序列化
+	"json_unsupported":     {"不支持序列化的表达式类型：%T", "unsupported expression type: %T"},
+	"json_decode":          {"%v", "%v"},
+	"json_invalid_number":  {"无效数值：%s", "invalid number: %s"},
+	"json_arg_count":       {"“%s”节点参数必须为%d个，当前为：%d", "\"%s\" node requires %d arguments, got: %d"},
+	"json_missing_node":    {"缺失表达式节点", "missing expression node"},
+	"json_missing_value":   {"数值节点缺失value", "number node is missing \"value\""},
+	"json_in_missing_set":  {"in节点缺失集合项", "in node is missing its set items"},
+	"json_expect_exp":      {"此处应为公式或条件，当前节点类型：“%s”", "expected a formula or condition, got node kind: \"%s\""},
+	"json_missing_oper":    {"缺失运算节点", "missing operation node"},
+	"json_expect_oper":     {"此处应为运算，当前节点类型：“%s”", "expected an operation, got node kind: \"%s\""},
+	"json_expect_kind":     {"此处应为“%s”节点，当前节点类型：“%s”", "expected a \"%s\" node, got node kind: \"%s\""},
+	"invalid_operator":     {"无效运算符：%s", "invalid operator: %s"},
+	"cond_name_empty":      {"条件名不能为空", "condition name cannot be empty"},
+	"cond_cycle":           {"条件循环引用：%s → %s", "circular condition reference: %s → %s"},
+	"empty_frml":           {"公式为空", "formula is nil"},
+	"empty_cond":           {"条件为空", "condition is nil"},
+	"invalid_fault":        {"无效的运算异常类型：%d", "invalid arithmetic fault: %d"},
+	"invalid_fault_action": {"无效的运算异常处理方式：%d", "invalid arithmetic fault action: %d"},
+	"arith_error":          {"运算异常（%s）：%s，左值：%v，右值：%v", "arithmetic fault (%s): %s, left: %v, right: %v"},
+	"fault_div_zero":       {"除数为0", "division by zero"},
+	"fault_oper_div_zero":  {"写入数据时除数为0", "division by zero when writing data"},
+	"fault_mod_zero":       {"取余除数为0", "modulo by zero"},
+	"fault_not_int":        {"操作数不是整数", "operand is not an integer"},
+	"fault_nan":            {"结果为NaN", "result is NaN"},
+	"fault_inf":            {"结果为Inf", "result is Inf"},
+	"int_operands":         {"运算“%s”的操作数%v、%v不是int64范围内的整数", "operands %[2]v, %[3]v of \"%[1]s\" are not integers within int64"},
+	"int_operand":          {"运算“%s”的操作数%v不是int64范围内的整数", "operand %[2]v of \"%[1]s\" is not an integer within int64"},
+	"decimal_scale":        {"定点数精度须在0到%d之间，当前为：%d", "decimal scale must be between 0 and %d, got: %d"},
+
+	// 名字注册
+	"type_limit":          {"名字类型超过规定限数：%s", "too many name types: %s"},
+	"raw_id_too_large":    {"该类型原始ID大于规定数值：%s", "raw ID of the type is too large: %s"},
+	"raw_id_duplicate":    {"该类型原始ID重复或者已经被注册：%s", "raw ID of the type is duplicated or already registered: %s"},
+	"type_name_limit":     {"该类型名字数量超过规定限数：%s", "too many names of the type: %s"},
+	"order_id_limit":      {"有序ID的名字数量已经达到设定的个数:%d", "the number of ordered-ID names has reached the limit: %d"},
+	"name_duplicate":      {"名字重复：%s", "duplicate name: %s"},
+	"type_not_registered": {"类型名称“%s”尚未注册", "type \"%s\" is not registered"},
+	"name_not_registered": {"数据名称“%s”尚未注册", "data name \"%s\" is not registered"},
+	"id_not_registered":   {"数据ID“%d”尚未注册", "data ID \"%d\" is not registered"},
+	"order_set_func":      {"有序数据“%s”不能设置Set函数", "ordered data \"%s\" cannot have a Set function"},
+	"order_get_func":      {"有序数据“%s”不能设置Get函数", "ordered data \"%s\" cannot have a Get function"},
+	"order_const":         {"有序数据“%s”不能设为常量", "ordered data \"%s\" cannot be a constant"},
+
+	// 仓库与监听
+	"order_name_missing":     {"ID为“%d”的有序数据名缺失", "ordered data name of ID \"%d\" is missing"},
+	"data_id_zero":           {"数据ID为0", "data ID is 0"},
+	"invalid_data_id":        {"无效数据ID：%d", "invalid data ID: %d"},
+	"set_func_failed":        {"数据设置异常：%v", "Set function failed: %v"},
+	"get_func_failed":        {"数据获取异常：%v", "Get function failed: %v"},
+	"cascade_limit":          {"数据监听连锁触发超过限定深度%d，触发路径：%s", "listener cascade exceeded depth %d, path: %s"},
+	"listener_duplicate":     {"不能重复相同名称的监听器：%s", "duplicate listener name: %s"},
+	"listener_nil":           {"监听函数不能为nil", "listener function cannot be nil"},
+	"listen_id_zero":         {"ID不能为0", "ID cannot be 0"},
+	"listen_tag_empty":       {"标签不能为空", "tag cannot be empty"},
+	"listen_callback_failed": {"数据监听回调异常：%v，监听器：%s", "data listener callback failed: %v, listener: %s"},
+	"async_callback_failed":  {"异步监听回调异常：%v，监听器：%s", "async listener callback failed: %v, listener: %s"},
+	"cond_callback_failed":   {"条件监听回调异常：%v，条件：%s", "condition listener callback failed: %v, condition: %s"},
+	"cond_without_names":     {"监听的条件无实际数据名：%s", "listened condition has no data names: %s"},
+	"step_name_exp":          {"[Step%d].名字表达式:%s", "[Step%d].Name expression:%s"},
+	"step_value_exp":         {"[Step%d].数值表达式:%s", "[Step%d].Value expression:%s"},
+
+	// 快照与录制
+	"snapshot_version":        {"引擎版本与录制时不一致", "engine version differs from the recording"},
+	"snapshot_names":          {"名字注册表与录制时不一致", "name registry differs from the recording"},
+	"snapshot_format":         {"无效的快照或录制日志格式", "invalid snapshot or record log format"},
+	"snapshot_version_detail": {"：录制%s，当前%s", ": recorded %s, current %s"},
+	"snapshot_names_detail":   {"：录制%016x，当前%016x", ": recorded %016x, current %016x"},
+	"already_recording":       {"仓库已经在录制中", "the storehouse is already recording"},
+	"replay_diverged":         {"第%d条记录（%s）回放结果不一致：录制%v，回放%v", "record %d (%s) diverged on replay: recorded %v, replayed %v"},
+	// 条件解释
+	"explain_skipped": {"%s：短路未检查", "%s: not checked (short-circuited)"},
+	"explain_detail":  {"%s：%s", "%s: %s"},
+	"explain_failed":  {"不成立原因：%s", "failed because: %s"},
+}
+
+// 按消息代码及当前语言格式化消息，未知代码返回代码及参数
+func Message(code string, args ...any) string {
+	return MessageIn(lang, code, args...)
+}
+
+// 按指定语言格式化消息，不受SetLang影响
+func MessageIn(value Lang, code string, args ...any) string {
+	texts, ok := messages[code]
+	if !ok {
+		return code + fmt.Sprint(args...)
+	}
+	if value >= langCount {
+		value = LANG_ZH
+	}
+	text := texts[value]
+	if text == "" {
+		text = texts[LANG_ZH]
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// 引擎消息的统一格式：[dmp]出处 => 消息
+func dmpText(flag, code string, args ...any) string {
+	return "[dmp]" + flag + " => " + Message(code, args...)
+}
+
+// 带消息代码的错误，Error()按当前语言生成消息
+type CodeError struct {
+	Flag string
+	Code string
+	Args []any
+}
+
+func (this *CodeError) Error() string {
+	return dmpText(this.Flag, this.Code, this.Args...)
+}
+
+type LogLevel uint
+
+const (
+	// 调试信息，如流程的步骤日志
+	LL_DEBUG LogLevel = iota
+	LL_WARN
+	LL_ERROR
+)
+
+var logLevelNames = []string{"DEBUG", "WARN", "ERROR"}
+
+func (this LogLevel) String() string {
+	if int(this) < len(logLevelNames) {
+		return logLevelNames[this]
+	}
+	return fmt.Sprintf("LogLevel(%d)", uint(this))
+}
+
+// 日志条目，Exp、Name、Id、Err按消息有无填写
+type LogEntry struct {
+	Level LogLevel
+	Code  string
+	Args  []any
+	// 出处，如：Storehouse.Oper
+	Flag string
+	// 相关表达式
+	Exp string
+	// 相关数据名及ID
+	Name string
+	Id   uint32
+	// 回调等抛出的异常
+	Err any
+}
+
+func (this *LogEntry) Message() string {
+	return Message(this.Code, this.Args...)
+}
+
+// 与原有日志一致的文本：[dmp]出处 => 消息，无出处时只有消息
+func (this *LogEntry) String() string {
+	if this.Flag == "" {
+		return this.Message()
+	}
+	return dmpText(this.Flag, this.Code, this.Args...)
+}
+
+// 引擎日志，可通过SetLogger替换
+type Logger interface {
+	Log(entry *LogEntry)
+}
+
+// 函数形式的Logger
+type LogFunc func(entry *LogEntry)
+
+func (this LogFunc) Log(entry *LogEntry) {
+	this(entry)
+}
+
+// 标准日志，输出String()文本
+type stdLogger struct {
+}
+
+func (this stdLogger) Log(entry *LogEntry) {
+	log.Print(entry.String())
+}
+
+var logger Logger = stdLogger{}
+
+// 设置引擎日志，默认为标准日志
+func SetLogger(value Logger) {
+	if value == nil {
+		value = stdLogger{}
+	}
+	logger = value
+}
+
+// 以格式化函数作为日志，只输出String()文本
+//
+// Deprecated: 使用SetLogger，可取得消息代码等字段
+func SetLogFunc(value func(format string, v ...any)) {
+	if value == nil {
+		SetLogger(nil)
+		return
+	}
+	SetLogger(LogFunc(func(entry *LogEntry) {
+		value("%s", entry.String())
+	}))
+}
+
+func writeLog(entry *LogEntry) {
+	logger.Log(entry)
+}
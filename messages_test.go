@@ -0,0 +1,68 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"errors"
+	"testing"
+)
+
+// 消息目录的每个代码都有中英文
+func TestMessageCatalog(t *testing.T) {
+	for code, texts := range messages {
+		for lang, text := range texts {
+			if text == "" {
+				t.Errorf("%s缺少语言%d的消息", code, lang)
+			}
+		}
+	}
+	for key := range parseKinds {
+		if _, ok := messages[key]; !ok {
+			t.Errorf("解析错误%s不在消息目录中", key)
+		}
+	}
+}
+
+func TestMessageLang(t *testing.T) {
+	tests := []struct {
+		name string
+		lang Lang
+		want string
+	}{
+		{"中文", LANG_ZH, "Max函数参数必须为2个，当前为：1"},
+		{"英文", LANG_EN, "function Max requires 2 parameters, got: 1"},
+		{"无效语言按中文", Lang(99), "Max函数参数必须为2个，当前为：1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MessageIn(tt.lang, "func_param_count", "Max", 2, 1); got != tt.want {
+				t.Fatalf("%s，应为%s", got, tt.want)
+			}
+
+			// 语言为引擎共用，错误文本按当前语言生成
+			SetLang(tt.lang)
+			defer SetLang(LANG_ZH)
+			if got := Message("func_param_count", "Max", 2, 1); got != tt.want {
+				t.Fatalf("Message：%s，应为%s", got, tt.want)
+			}
+			_, err := ParseFrmlExp("Max(1)")
+			var pe *ParseError
+			if !errors.As(err, &pe) || (pe.Message() != tt.want) {
+				t.Fatalf("解析错误：%v", err)
+			}
+		})
+	}
+
+	if got := MessageIn(LANG_EN, "未知代码", 1); got != "未知代码1" {
+		t.Fatalf("未知代码：%s", got)
+	}
+}
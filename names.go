@@ -14,7 +14,10 @@ package de
 // 名字系统(Name System)
 
 import (
-	"fmt"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sort"
 )
 
 // 数据充值周期
@@ -48,12 +51,19 @@ type getFunc = func(store *Storehouse, id uint32) float64
 type nameCfg struct {
 	id      uint32
 	name    string
+	typ     string
 	rsc     retsetCycle
 	init    float64
 	max     float64
 	min     float64
 	setFunc setFunc
 	getFunc getFunc
+	// 常量：取值与仓库无关且不会改变，优化表达式时可折叠
+	isConst bool
+	// 定点精度，nil为浮点数据
+	dec *Decimal
+	// 标签，可按标签监听
+	tags []string
 }
 
 type typeCfg struct {
@@ -62,6 +72,9 @@ type typeCfg struct {
 	nameIdCount uint32
 	setFunc     setFunc
 	getFunc     getFunc
+	isConst     bool
+	dec         *Decimal
+	tags        []string
 }
 
 type names struct {
@@ -106,7 +119,7 @@ func (this *names) registerType(typ string) *typeCfg {
 	tCfg := this.typeCfgOfName[typ]
 	if tCfg == nil {
 		if this.typeIdCount >= maxType {
-			panic("[dmp]registerType => 名字类型超过规定限数：" + typ)
+			panic(dmpText("registerType", "type_limit", typ))
 		}
 		this.typeIdCount++
 		tCfg = &typeCfg{
@@ -124,15 +137,28 @@ func (this *names) RegisterType(typ string) uint32 {
 	return this.registerType(typ).typeId
 }
 
+// 获取类型ID（高8位），类型未注册时返回0
+func (this *names) GetTypeId(typ string) uint32 {
+	if typ == "" {
+		typ = "empty+nil"
+	}
+
+	tCfg := this.typeCfgOfName[typ]
+	if tCfg == nil {
+		return 0
+	}
+	return tCfg.typeId
+}
+
 func (this *names) allocNameId(typ, name string, rawId uint32) (ret uint32, typeCfg *typeCfg) {
 	if rawId >= maxNameOfType {
-		panic("[dmp]allocNameId => 该类型原始ID大于规定数值：" + typ)
+		panic(dmpText("allocNameId", "raw_id_too_large", typ))
 	}
 
 	typeCfg = this.registerType(typ)
 	if rawId != 0 {
 		if typeCfg.flagOfRawID[rawId] {
-			panic("[dmp]allocNameId => 该类型原始ID重复或者已经被注册：" + name)
+			panic(dmpText("allocNameId", "raw_id_duplicate", name))
 		}
 		if typeCfg.nameIdCount < rawId {
 			typeCfg.nameIdCount = rawId
@@ -141,7 +167,7 @@ func (this *names) allocNameId(typ, name string, rawId uint32) (ret uint32, type
 		ret = typeCfg.typeId + rawId
 	} else {
 		if typeCfg.nameIdCount >= maxNameOfType {
-			panic("[dmp]allocNameId => 该类型名字数量超过规定限数：" + typ)
+			panic(dmpText("allocNameId", "type_name_limit", typ))
 		}
 		typeCfg.nameIdCount++
 		typeCfg.flagOfRawID[typeCfg.nameIdCount] = true
@@ -152,12 +178,12 @@ func (this *names) allocNameId(typ, name string, rawId uint32) (ret uint32, type
 
 func (this *names) RegisterNameOfOrderId(name string, rawId uint32, init, min, max float64) uint32 {
 	if this.orderIdCount >= maxNameOfType {
-		panic(fmt.Sprintf("[dmp]RegisterNameOfOrderId => 有序ID的名字数量已经达到设定的个数:%d", maxNameOfType))
+		panic(dmpText("RegisterNameOfOrderId", "order_id_limit", maxNameOfType))
 	}
 
 	id := this.GetIdByName(name)
 	if id != 0 {
-		panic(fmt.Sprintf("[dmp]RegisterNameOfOrderId => 名字重复：%s", name))
+		panic(dmpText("RegisterNameOfOrderId", "name_duplicate", name))
 	}
 
 	if rawId == 0 {
@@ -189,12 +215,16 @@ func (this *names) addName(typ string, id uint32, name string, rsc retsetCycle,
 	cfg := &nameCfg{
 		id:      id,
 		name:    name,
+		typ:     typ,
 		rsc:     rsc,
 		init:    init,
 		min:     min,
 		max:     max,
 		getFunc: typeCfg.getFunc,
 		setFunc: typeCfg.setFunc,
+		isConst: typeCfg.isConst,
+		dec:     typeCfg.dec,
+		tags:    typeCfg.tags,
 	}
 
 	this.nameCfgOfName[name] = cfg
@@ -209,12 +239,12 @@ func (this *names) addName(typ string, id uint32, name string, rsc retsetCycle,
 
 func (this *names) RegisterNameByInfo(typ string, name string, rawId uint32, rsc retsetCycle, init, min, max float64) uint32 {
 	if this.nameCfgOfName[name] != nil {
-		panic("[dmp]RegisterNameByInfo => 名字重复: " + name)
+		panic(dmpText("RegisterNameByInfo", "name_duplicate", name))
 	}
 
 	id, typeCfg := this.allocNameId(typ, name, rawId)
 	if this.nameCfgOfId[id] != nil {
-		panic("[dmp]RegisterNameByInfo => 名字重复: " + name)
+		panic(dmpText("RegisterNameByInfo", "name_duplicate", name))
 	}
 
 	this.addName(typ, id, name, rsc, init, min, max, typeCfg)
@@ -238,10 +268,46 @@ func (this *names) GetNameById(id uint32) string {
 	return cfg.name
 }
 
+// 名字注册表指纹，用于检测录制、快照与当前注册表是否一致；
+// 包括名字、类型、复位周期、初值、上下限及定点精度
+func (this *names) Fingerprint() uint64 {
+	ids := make([]uint32, 0, len(this.nameCfgOfId))
+	for id := range this.nameCfgOfId {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	h := fnv.New64a()
+	buf := make([]byte, 8)
+	writeUint := func(v uint64) {
+		binary.LittleEndian.PutUint64(buf, v)
+		h.Write(buf)
+	}
+	for _, id := range ids {
+		cfg := this.nameCfgOfId[id]
+		writeUint(uint64(id))
+		h.Write([]byte(cfg.name))
+		h.Write([]byte{0})
+		h.Write([]byte(cfg.typ))
+		h.Write([]byte{0})
+		writeUint(uint64(cfg.rsc))
+		writeUint(math.Float64bits(cfg.init))
+		writeUint(math.Float64bits(cfg.min))
+		writeUint(math.Float64bits(cfg.max))
+		if cfg.dec != nil {
+			writeUint(uint64(cfg.dec.Scale) + 1)
+			writeUint(uint64(cfg.dec.Round))
+		} else {
+			writeUint(0)
+		}
+	}
+	return h.Sum64()
+}
+
 func (this *names) RegisterSetFuncByType(typeName string, value setFunc) {
 	tcfg := this.typeCfgOfName[typeName]
 	if tcfg == nil {
-		panic(fmt.Sprintf("[dmp]names.RegisterSetFuncByType => 类型名称“%s”尚未注册", typeName))
+		panic(dmpText("names.RegisterSetFuncByType", "type_not_registered", typeName))
 	}
 	tcfg.setFunc = value
 
@@ -256,7 +322,7 @@ func (this *names) RegisterSetFuncByType(typeName string, value setFunc) {
 func (this *names) RegisterSetFuncByName(name string, value setFunc) {
 	id := this.GetIdByName(name)
 	if id == 0 {
-		panic(fmt.Sprintf("[dmp]names.RegisterSetFuncByName => 数据名称“%s”尚未注册", name))
+		panic(dmpText("names.RegisterSetFuncByName", "name_not_registered", name))
 	}
 
 	this.RegisterSetFuncById(id, value)
@@ -264,7 +330,7 @@ func (this *names) RegisterSetFuncByName(name string, value setFunc) {
 
 func (this *names) RegisterSetFuncById(id uint32, value setFunc) {
 	if id <= maxNameOfType {
-		panic(fmt.Sprintf("[dmp]names.RegisterSetFuncById => 有序数据“%s”不能设置Set函数", this.GetNameById(id)))
+		panic(dmpText("names.RegisterSetFuncById", "order_set_func", this.GetNameById(id)))
 		return
 	}
 
@@ -275,7 +341,7 @@ func (this *names) RegisterSetFuncById(id uint32, value setFunc) {
 func (this *names) RegisterGetFuncByType(typeName string, value getFunc) {
 	tcfg := this.typeCfgOfName[typeName]
 	if tcfg == nil {
-		panic(fmt.Sprintf("[dmp]names.RegisterGetFuncByType => 类型名称“%s”尚未注册", typeName))
+		panic(dmpText("names.RegisterGetFuncByType", "type_not_registered", typeName))
 	}
 	tcfg.getFunc = value
 
@@ -290,7 +356,7 @@ func (this *names) RegisterGetFuncByType(typeName string, value getFunc) {
 func (this *names) RegisterGetFuncByName(name string, value getFunc) {
 	id := this.GetIdByName(name)
 	if id == 0 {
-		panic(fmt.Sprintf("[dmp]names.SetGetFunc => 数据名称“%s”尚未注册", name))
+		panic(dmpText("names.SetGetFunc", "name_not_registered", name))
 	}
 
 	this.RegisterGetFuncById(id, value)
@@ -298,7 +364,7 @@ func (this *names) RegisterGetFuncByName(name string, value getFunc) {
 
 func (this *names) RegisterGetFuncById(id uint32, value getFunc) {
 	if id <= maxNameOfType {
-		panic(fmt.Sprintf("[dmp]names.SetGetFunc => 有序数据“%s”不能设置Get函数", this.GetNameById(id)))
+		panic(dmpText("names.SetGetFunc", "order_get_func", this.GetNameById(id)))
 		return
 	}
 
@@ -306,6 +372,93 @@ func (this *names) RegisterGetFuncById(id uint32, value getFunc) {
 	cfg.getFunc = value
 }
 
+// 标记类型下的数据为常量（须由Get函数取值），优化表达式时以其值替换
+func (this *names) RegisterConstByType(typeName string) {
+	tcfg := this.typeCfgOfName[typeName]
+	if tcfg == nil {
+		panic(dmpText("names.RegisterConstByType", "type_not_registered", typeName))
+	}
+	tcfg.isConst = true
+
+	for _, cfg := range this.nameCfgsOfType[typeName] {
+		this.RegisterConstById(cfg.id)
+	}
+}
+
+func (this *names) RegisterConstByName(name string) {
+	id := this.GetIdByName(name)
+	if id == 0 {
+		panic(dmpText("names.RegisterConstByName", "name_not_registered", name))
+	}
+
+	this.RegisterConstById(id)
+}
+
+func (this *names) RegisterConstById(id uint32) {
+	if id <= maxNameOfType {
+		panic(dmpText("names.RegisterConstById", "order_const", this.GetNameById(id)))
+	}
+
+	cfg := this.nameCfgOfId[id]
+	cfg.isConst = true
+}
+
+// 给类型下的数据（包括之后注册的数据）加标签
+func (this *names) RegisterTagByType(typeName string, tags ...string) {
+	tcfg := this.typeCfgOfName[typeName]
+	if tcfg == nil {
+		panic(dmpText("names.RegisterTagByType", "type_not_registered", typeName))
+	}
+	tcfg.tags = appendTags(tcfg.tags, tags)
+
+	for _, cfg := range this.nameCfgsOfType[typeName] {
+		this.RegisterTagById(cfg.id, tags...)
+	}
+}
+
+func (this *names) RegisterTagByName(name string, tags ...string) {
+	id := this.GetIdByName(name)
+	if id == 0 {
+		panic(dmpText("names.RegisterTagByName", "name_not_registered", name))
+	}
+
+	this.RegisterTagById(id, tags...)
+}
+
+func (this *names) RegisterTagById(id uint32, tags ...string) {
+	cfg := this.nameCfgOfId[id]
+	if cfg == nil {
+		panic(dmpText("names.RegisterTagById", "id_not_registered", id))
+	}
+	cfg.tags = appendTags(cfg.tags, tags)
+}
+
+func (this *names) GetTagsById(id uint32) []string {
+	cfg := this.nameCfgOfId[id]
+	if cfg == nil {
+		return nil
+	}
+	return cfg.tags
+}
+
+// 追加不重复的标签，返回新切片，不修改原切片（类型与名字可能共用）
+func appendTags(olds []string, tags []string) []string {
+	news := append([]string(nil), olds...)
+	for _, tag := range tags {
+		found := false
+		for _, old := range news {
+			if old == tag {
+				found = true
+				break
+			}
+		}
+		if !found && (tag != "") {
+			news = append(news, tag)
+		}
+	}
+	return news
+}
+
 func strGetFunc(store *Storehouse, id uint32) float64 {
 	// 字符串无数据值，默认用其ID来做比较
 	return float64(id)
@@ -14,8 +14,6 @@ package de
 // 四则运算集合(Operation Set)
 
 import (
-	"errors"
-	"fmt"
 	"strconv"
 )
 
@@ -36,6 +34,10 @@ func (this *operExp) DestId() uint32 {
 	return this.nameId
 }
 
+func (this *operExp) getOperExp() *operExp {
+	return this
+}
+
 type incOperExp struct {
 	operExp
 }
@@ -104,6 +106,40 @@ func (this *divOperExp) Exec(store *Storehouse) bool {
 	return false
 }
 
+type andOperExp struct {
+	operExp
+}
+
+func (this *andOperExp) NameExp() string {
+	return this.names.GetNameById(this.nameId) + "&=" + this.value.NameExp()
+}
+
+func (this *andOperExp) ValueExp(store *Storehouse) string {
+	return strconv.FormatFloat(this.value.Float64(store), 'f', -1, 64) + "&=" + this.value.ValueExp(store)
+}
+
+func (this *andOperExp) Exec(store *Storehouse) bool {
+	store.Oper(this.nameId, OS_AND, this.value.Float64(store))
+	return false
+}
+
+type orOperExp struct {
+	operExp
+}
+
+func (this *orOperExp) NameExp() string {
+	return this.names.GetNameById(this.nameId) + "|=" + this.value.NameExp()
+}
+
+func (this *orOperExp) ValueExp(store *Storehouse) string {
+	return strconv.FormatFloat(this.value.Float64(store), 'f', -1, 64) + "|=" + this.value.ValueExp(store)
+}
+
+func (this *orOperExp) Exec(store *Storehouse) bool {
+	store.Oper(this.nameId, OS_OR, this.value.Float64(store))
+	return false
+}
+
 type setOperExp struct {
 	operExp
 }
@@ -160,38 +196,84 @@ func (this operSet) ValueExp(store *Storehouse) (ret string) {
 type operParser struct {
 	parser
 	buildExp func(symbol rune, nameStart, nameEnd int) (ret OperExp)
+	// 当前运算开始的行号
+	stepLine int
+	// 收集错误：出错的运算跳到下一行继续解析
+	collect bool
+	errs    []*ParseError
 }
 
 func (this *operParser) extractNameValue(nameStart, nameEnd int) (nameId uint32, value FrmlExp) {
 
 	name := string(this.exp[nameStart:nameEnd])
 	if name == "" {
-		this.doError(fmt.Sprintf("符号“%s”前缺失数据名", string(this.exp[this.index])))
+		this.doError("oper_missing_name", string(this.exp[this.index]))
 	}
 
 	nameId = this.names.GetIdByName(name)
 	if nameId == 0 {
-		this.doError("无效数据名：" + name)
+		panic(this.newError(nameStart, "invalid_name", name))
 	}
 
 	this.index++
 	this.pass()
 	valueExp := ""
+	// 值表达式各字符在源串中的位置，用于换算值表达式中错误的位置
+	valuePos := []int{}
+	appendValue := func(char rune) {
+		valueExp = valueExp + string(char)
+		valuePos = append(valuePos, this.index)
+	}
 	isValueEnd := false
 	hasOper := false
 	hasSpace := false
 	leftParentheses := 0
+	// 未闭合的左括号在源串中的位置
+	opens := []int{}
+	// 括号内第一个换行在值表达式中的位置
+	lineBreak := -1
+	// 括号内第一个“//”注释在源串中的位置
+	comment := -1
+
+	// 解析值表达式的前n个字符，错误换算到源串中的位置
+	parse := func(n int) FrmlExp {
+		exp := string([]rune(valueExp)[:n])
+		defer func() {
+			if err := recover(); err != nil {
+				this.rethrow(err, func(inner *ParseError) int {
+					if inner.Source != exp {
+						return -1
+					}
+					if inner.Offset < n {
+						return valuePos[inner.Offset]
+					}
+					return valuePos[n-1] + 1
+				})
+			}
+		}()
+		return parseFrmlExpByNames(exp, this.names)
+	}
 
 	defer func() {
 		if valueExp == "" {
-			this.doError(fmt.Sprintf("数据“%s”缺失值表达式", name))
+			this.doError("oper_missing_value", name)
 		}
-		defer func() {
-			if err := recover(); err != nil {
-				this.doError(fmt.Sprintf("%v", err))
+		if leftParentheses > 0 {
+			// 未闭合时只解析左括号所在行，其后的行多为后续的运算
+			n := len(valuePos)
+			if lineBreak >= 0 {
+				n = lineBreak
 			}
-		}()
-		value = parseFrmlExpByNames(valueExp, this.names)
+			// 括号内有注释时多为把“//”误作整除，注释截断了值表达式
+			offset, key := opens[0], "missing_rparen"
+			if comment >= 0 {
+				offset, key = comment, "paren_comment"
+			}
+			this.unclosedError(offset, key, func() {
+				parse(n)
+			})
+		}
+		value = parse(len(valuePos))
 	}()
 
 	for {
@@ -202,8 +284,21 @@ func (this *operParser) extractNameValue(nameStart, nameEnd int) (nameId uint32,
 
 		char := this.exp[this.index]
 
+		// “//”到行尾为注释，换行结束值表达式
 		if (char == '/') && (this.index < this.end-1) && (this.exp[this.index+1] == '/') {
+			if (leftParentheses > 0) && (comment < 0) {
+				comment = this.index
+			}
 			this.toLineEnd()
+			hasSpace = false
+			isValueEnd = true
+			hasOper = false
+			continue
+		}
+
+		// 括号内的空格保留，如：If(职业 in (1,3), 1, 0)
+		if (leftParentheses > 0) && ((char == ' ') || (char == '\t')) {
+			appendValue(' ')
 			this.index++
 			continue
 		}
@@ -212,15 +307,17 @@ func (this *operParser) extractNameValue(nameStart, nameEnd int) (nameId uint32,
 		case '(':
 			{
 				leftParentheses++
-				valueExp = valueExp + string(char)
+				opens = append(opens, this.index)
+				appendValue(char)
 			}
 		case ')':
 			{
 				if leftParentheses == 0 {
-					this.doError("多余右括号")
+					this.doError("extra_rparen")
 				}
 				leftParentheses--
-				valueExp = valueExp + string(char)
+				opens = opens[:len(opens)-1]
+				appendValue(char)
 			}
 		case stepSeparator:
 			{
@@ -234,17 +331,24 @@ func (this *operParser) extractNameValue(nameStart, nameEnd int) (nameId uint32,
 			}
 		case '\n':
 			{
+				// 括号内的换行作为空格，前后两行的记号不相连
+				if leftParentheses > 0 {
+					if lineBreak < 0 {
+						lineBreak = len(valuePos)
+					}
+					appendValue(' ')
+				}
 				hasSpace = false
 				isValueEnd = true
 				hasOper = false
 				this.line++
 				this.lineStart = this.index + 1
 			}
-		case '+', '-', '*', '/', '&', '<', '>', '=', '!', ':', ',':
+		case '+', '-', '*', '/', '%', '^', '~', '&', '|', '<', '>', '=', '!', ':', ',':
 			{
 				hasOper = true
 				hasSpace = false
-				valueExp = valueExp + string(char)
+				appendValue(char)
 			}
 		default:
 			{
@@ -254,9 +358,9 @@ func (this *operParser) extractNameValue(nameStart, nameEnd int) (nameId uint32,
 				}
 				if (char > 32) && (char != 127) {
 					if hasSpace && (!hasOper) {
-						this.doError("此处出现多余空格")
+						this.doError("extra_space")
 					}
-					valueExp = valueExp + string(char)
+					appendValue(char)
 				}
 				isValueEnd = false
 				hasOper = false
@@ -274,7 +378,7 @@ func (this *operParser) doBuildExp(symbol rune, nameStart, nameEnd int) (ret Ope
 
 	checkEqu := func() {
 		if (this.index >= this.end-1) || (this.exp[this.index+1] != '=') {
-			this.doError(fmt.Sprintf("符号“%s”后面缺失等号“=”", string(symbol)))
+			this.doError("oper_missing_equal", string(symbol))
 		}
 		this.index++
 	}
@@ -324,6 +428,26 @@ func (this *operParser) doBuildExp(symbol rune, nameStart, nameEnd int) (ret Ope
 			ret.value = value
 			return ret
 		}
+	case '&':
+		{
+			checkEqu()
+			nameId, value := this.extractNameValue(nameStart, nameEnd)
+			ret := &andOperExp{}
+			ret.names = this.names
+			ret.nameId = nameId
+			ret.value = value
+			return ret
+		}
+	case '|':
+		{
+			checkEqu()
+			nameId, value := this.extractNameValue(nameStart, nameEnd)
+			ret := &orOperExp{}
+			ret.names = this.names
+			ret.nameId = nameId
+			ret.value = value
+			return ret
+		}
 	case '=', ':':
 		{
 			nameId, value := this.extractNameValue(nameStart, nameEnd)
@@ -348,7 +472,7 @@ func (this *operParser) doParse(onGetOperExp func(exp OperExp)) {
 			if nameEnd == -1 {
 				nameEnd = firstSpace
 			} else {
-				this.doError(fmt.Sprintf("名字“%s”后面出现多余字符", string(this.exp[nameStart:nameEnd])))
+				this.doError("name_trailing_chars", string(this.exp[nameStart:nameEnd]))
 			}
 		}
 
@@ -371,6 +495,7 @@ func (this *operParser) doParse(onGetOperExp func(exp OperExp)) {
 		if nameStart == -1 {
 			nameStart = this.index
 			nameEnd = -1
+			this.stepLine = this.line
 		}
 
 		if nameStart != -1 {
@@ -387,33 +512,48 @@ func (this *operParser) doParse(onGetOperExp func(exp OperExp)) {
 }
 
 func parseOperExp(exp string) OperSet {
+	ret, _ := parseOperLines(exp)
+	return ret
+}
+
+// 解析运算集合，同时返回各运算开始的行号
+func parseOperLines(exp string) (OperSet, []int) {
+	ret, lines, _ := parseOperSource(exp, false)
+	return ret, lines
+}
+
+// 解析运算集合；collect为true时收集所有错误，否则遇到错误即抛出
+func parseOperSource(exp string, collect bool) (OperSet, []int, []*ParseError) {
 	ret := operSet{}
+	lines := []int{}
 
 	if exp == "" {
-		return ret
+		return ret, lines, nil
 	}
 
+	var perser *operParser
 	onGetOperExp := func(exp OperExp) {
 		ret = append(ret, exp)
+		lines = append(lines, perser.stepLine)
 	}
 
-	perser := &operParser{}
+	perser = &operParser{}
 	perser.buildExp = perser.doBuildExp
 	perser.init(exp, Names, "OperSet")
-	perser.doParse(onGetOperExp)
-	perser.checkEnd()
+	perser.collect = collect
+	perser.parseAll(onGetOperExp)
 
-	if len(ret) == 0 {
-		perser.doError("无效运算表达式")
+	if (len(ret) == 0) && (len(perser.errs) == 0) {
+		if !collect {
+			perser.doError("empty_oper")
+		}
+		perser.errs = append(perser.errs, perser.newError(perser.index, "empty_oper"))
 	}
 
-	return &ret
+	return &ret, lines, perser.errs
 }
 
-func ParseOperExp(exp string) (OperSet, error) {
-	if err := recover(); err != nil {
-		return nil, errors.New(fmt.Sprintf("%v", err))
-	}
-	ret := parseOperExp(exp)
-	return ret, nil
+func ParseOperExp(exp string) (ret OperSet, err error) {
+	defer recoverParse(&err)
+	return parseOperExp(exp), nil
 }
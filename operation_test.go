@@ -0,0 +1,66 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"testing"
+)
+
+// 运算集合中“//”到行尾均为注释，整除使用Div
+func TestOperBitwiseAndComment(t *testing.T) {
+	a := testName("测试运算", "权限")
+	b := testName("测试运算", "权限乙")
+
+	tests := []struct {
+		exp      string
+		wantName string
+		wantA    float64
+		wantB    float64
+	}{
+		{"权限 |= 3", "权限|=3", 15, 0},
+		{"权限 &= ~4", "权限&=~4", 8, 0},
+		{"权限乙 = 权限 // 2", "权限乙=权限", 12, 12},
+		{"权限乙=权限//2\n权限=1", "权限乙=权限 权限=1", 1, 12},
+		{"权限乙 = Div(权限, 5) // 注释\n权限 |= 3", "权限乙=Div(权限,5) 权限|=3", 15, 2},
+		{"// 整行注释\n权限乙 = 权限 ~ 5", "权限乙=(权限~5)", 12, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			opers, err := ParseOperExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opers.NameExp() != tt.wantName {
+				t.Fatalf("%s，应为%s", opers.NameExp(), tt.wantName)
+			}
+			store := NewStorehouse(nil)
+			store.Set(a, 12)
+			WorkStat.ExecOper(store, opers, false)
+			if (store.Get(a) != tt.wantA) || (store.Get(b) != tt.wantB) {
+				t.Fatalf("权限=%v 权限乙=%v，应为%v %v", store.Get(a), store.Get(b), tt.wantA, tt.wantB)
+			}
+		})
+	}
+}
+
+// 格式化时紧跟在运算项后的“//”同样为注释
+func TestFormatOperComment(t *testing.T) {
+	testName("测试运算", "权限")
+	testName("测试运算", "权限乙")
+	got, err := FormatOperExp("权限乙=权限//整除已改用Div\n权限 |= 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "权限乙 = 权限 // 整除已改用Div\n权限 |= 3\n"; got != want {
+		t.Fatalf("%q，应为%q", got, want)
+	}
+}
@@ -0,0 +1,432 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+// 表达式优化(Expression Optimizer)
+//
+// 常量子表达式（常数、常量数据名、参数均为常量的纯函数）在优化时求值折叠，
+// 并化简恒等式（x+0、x*1、x/1等）、无副作用的零乘（x*0，按数据为有限值处理）以及条件为常量的If分支；
+// 优化生成新的表达式，原表达式不变，命名条件引用不展开（其定义可被重新注册）
+
+import (
+	"math"
+)
+
+type optimizer struct {
+	// 常量求值用的空仓库
+	store *Storehouse
+}
+
+// 优化公式，已编译的公式优化其源表达式后重新编译
+func OptimizeFrml(exp FrmlExp) FrmlExp {
+	if exp == nil {
+		return nil
+	}
+	if v, ok := exp.(*compiledFrml); ok {
+		return CompileFrml(OptimizeFrml(v.src))
+	}
+	return newOptimizer().frml(exp)
+}
+
+// 优化条件，已编译的条件优化其源表达式后重新编译
+func OptimizeCond(exp CondExp) CondExp {
+	if exp == nil {
+		return nil
+	}
+	if v, ok := exp.(*compiledCond); ok {
+		return CompileCond(OptimizeCond(v.src))
+	}
+	return newOptimizer().cond(exp)
+}
+
+func newOptimizer() *optimizer {
+	return &optimizer{store: &Storehouse{}}
+}
+
+func constFrml(value float64) FrmlExp {
+	return &constExp{value: value}
+}
+
+func constCond(value bool) CondExp {
+	return &truthExp{frml: constFrml(boolValue(value))}
+}
+
+// 常量公式的值
+func frmlConst(exp FrmlExp) (float64, bool) {
+	if v, ok := exp.(*constExp); ok {
+		return v.value, true
+	}
+	return 0, false
+}
+
+// 常量条件的值
+func condConst(exp CondExp) (bool, bool) {
+	if v, ok := exp.(*truthExp); ok {
+		if value, ok := frmlConst(v.frml); ok {
+			return value != 0, true
+		}
+	}
+	return false, false
+}
+
+// 判断表达式是否无副作用（可省略求值），不能确定的按有副作用处理
+func isPure(exp anyExp) bool {
+	switch v := exp.(type) {
+	case *constExp, *idenExp:
+		return true
+	case *compiledFrml:
+		return isPure(v.src)
+	case *compiledCond:
+		return isPure(v.src)
+	case *condRefExp:
+		return isPure(v.get())
+	case *ifFuncExp:
+		// 走下面的子表达式判断
+	case *funcExp:
+		if !v.pure {
+			return false
+		}
+	case interface{ getFuncExp() *funcExp }:
+		return false
+	}
+
+	ret := true
+	eachSubExp(exp, func(sub anyExp) {
+		if ret && !isPure(sub) {
+			ret = false
+		}
+	})
+	return ret
+}
+
+func (this *optimizer) frml(exp FrmlExp) FrmlExp {
+	switch v := exp.(type) {
+	case *idenExp:
+		{
+			cfg := v.names.GetCfgById(v.id)
+			if (cfg != nil) && cfg.isConst {
+				this.store.names = v.names
+				return constFrml(this.store.Get(v.id))
+			}
+			return v
+		}
+	case *negExp:
+		{
+			value := this.frml(v.value)
+			if c, ok := frmlConst(value); ok {
+				return constFrml(-c)
+			}
+			return &negExp{value: value}
+		}
+	case *bitNotExp:
+		{
+			ret := &bitNotExp{value: this.frml(v.value)}
+			if _, ok := frmlConst(ret.value); ok {
+				return this.fold(ret)
+			}
+			return ret
+		}
+	case *boolExp:
+		{
+			cond := this.cond(v.cond)
+			if c, ok := condConst(cond); ok {
+				return constFrml(boolValue(c))
+			}
+			return asFrml(cond)
+		}
+	case *ifFuncExp:
+		{
+			return this.ifFunc(v)
+		}
+	case *funcExp:
+		{
+			ret := *v
+			ret.params = make([]FrmlExp, len(v.params))
+			isConst := v.pure
+			for i, param := range v.params {
+				ret.params[i] = this.frml(param)
+				if _, ok := frmlConst(ret.params[i]); !ok {
+					isConst = false
+				}
+			}
+			if isConst {
+				return this.fold(&ret)
+			}
+			return &ret
+		}
+	case interface{ getFrmlExp() *frmlExp }:
+		{
+			base := v.getFrmlExp()
+			return this.binary(base, this.frml(base.left), this.frml(base.right))
+		}
+	}
+	return exp
+}
+
+// 常量求值，求值时发生异常（如运算异常策略为FA_ERROR、函数出错）的表达式不折叠，留到执行时处理；
+// 结果为NaN、Inf时也不折叠，优化后的表达式文本仍可解析
+func (this *optimizer) fold(exp FrmlExp) (ret FrmlExp) {
+	defer func() {
+		if e := recover(); e != nil {
+			ret = exp
+		}
+	}()
+	value := exp.Float64(this.store)
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return exp
+	}
+	return constFrml(value)
+}
+
+// 零乘可化简：无副作用，且不是值为NaN、Inf的常量表达式（如Inf()），数据按有限值处理
+func (this *optimizer) finite(exp FrmlExp) (ret bool) {
+	if !isPure(exp) {
+		return false
+	}
+	hasId := false
+	exp.EachId(func(id uint32) {
+		hasId = true
+	})
+	if hasId {
+		return true
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			ret = false
+		}
+	}()
+	value := exp.Float64(this.store)
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+
+func (this *optimizer) binary(base *frmlExp, left, right FrmlExp) FrmlExp {
+	ret := newFrmlExpOf(base.oper, base.names, left, right)
+	lc, isLeftConst := frmlConst(left)
+	rc, isRightConst := frmlConst(right)
+	if isLeftConst && isRightConst {
+		return this.fold(ret)
+	}
+
+	switch base.oper {
+	case "+":
+		{
+			if isLeftConst && (lc == 0) {
+				return right
+			}
+			if isRightConst && (rc == 0) {
+				return left
+			}
+		}
+	case "-":
+		{
+			if isRightConst && (rc == 0) {
+				return left
+			}
+			if isLeftConst && (lc == 0) {
+				return &negExp{value: right}
+			}
+		}
+	case "*":
+		{
+			if isLeftConst && (lc == 1) {
+				return right
+			}
+			if isRightConst && (rc == 1) {
+				return left
+			}
+			if (isLeftConst && (lc == 0) && this.finite(right)) || (isRightConst && (rc == 0) && this.finite(left)) {
+				return constFrml(0)
+			}
+		}
+	case "/":
+		{
+			if isRightConst && (rc == 1) {
+				return left
+			}
+		}
+	case "^":
+		{
+			if isRightConst && (rc == 1) {
+				return left
+			}
+			if isRightConst && (rc == 0) && isPure(left) {
+				return constFrml(1)
+			}
+		}
+	}
+	return ret
+}
+
+// 条件为常量时只保留选中的分支
+func (this *optimizer) ifFunc(exp *ifFuncExp) FrmlExp {
+	cond := this.cond(exp.cond)
+	if c, ok := condConst(cond); ok {
+		if c {
+			return this.frml(exp.params[0])
+		}
+		return this.frml(exp.params[1])
+	}
+
+	ret := *exp
+	ret.cond = cond
+	ret.params = make([]FrmlExp, len(exp.params))
+	for i, param := range exp.params {
+		ret.params[i] = this.frml(param)
+	}
+	return &ret
+}
+
+func (this *optimizer) cond(exp CondExp) CondExp {
+	switch v := exp.(type) {
+	case *truthExp:
+		{
+			return asCond(this.frml(v.frml))
+		}
+	case *notExp:
+		{
+			cond := this.cond(v.cond)
+			if c, ok := condConst(cond); ok {
+				return constCond(!c)
+			}
+			return &notExp{names: v.names, cond: cond}
+		}
+	case *logicExpAnd:
+		{
+			left := this.cond(v.left)
+			if c, ok := condConst(left); ok {
+				if !c {
+					return left
+				}
+				return this.cond(v.right)
+			}
+			right := this.cond(v.right)
+			if c, ok := condConst(right); ok {
+				if c {
+					return left
+				}
+				if isPure(left) {
+					return right
+				}
+			}
+			ret := *v
+			ret.left, ret.right = left, right
+			return &ret
+		}
+	case *logicExpOr:
+		{
+			left := this.cond(v.left)
+			if c, ok := condConst(left); ok {
+				if c {
+					return left
+				}
+				return this.cond(v.right)
+			}
+			right := this.cond(v.right)
+			if c, ok := condConst(right); ok {
+				if !c {
+					return left
+				}
+				if isPure(left) {
+					return right
+				}
+			}
+			ret := *v
+			ret.left, ret.right = left, right
+			return &ret
+		}
+	case *inExp:
+		{
+			ret := *v
+			ret.left = this.frml(v.left)
+			_, isConst := frmlConst(ret.left)
+			ret.items = make([]FrmlExp, len(v.items))
+			for i, item := range v.items {
+				ret.items[i] = this.frml(item)
+				if _, ok := frmlConst(ret.items[i]); !ok {
+					isConst = false
+				}
+			}
+			if isConst {
+				return constCond(ret.Check(this.store))
+			}
+			return &ret
+		}
+	case *betweenExp:
+		{
+			ret := *v
+			ret.left = this.frml(v.left)
+			ret.low = this.frml(v.low)
+			ret.high = this.frml(v.high)
+			if this.isConst(ret.left, ret.low, ret.high) {
+				return constCond(ret.Check(this.store))
+			}
+			return &ret
+		}
+	case compExp:
+		{
+			ret := cloneComp(v)
+			base := ret.getCondExp()
+			base.left = this.frml(base.left)
+			base.right = this.frml(base.right)
+			if this.isConst(base.left, base.right) {
+				return constCond(ret.Check(this.store))
+			}
+			return ret
+		}
+	}
+	return exp
+}
+
+func (this *optimizer) isConst(exps ...FrmlExp) bool {
+	for _, exp := range exps {
+		if _, ok := frmlConst(exp); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func cloneComp(exp compExp) compExp {
+	switch v := exp.(type) {
+	case *compExpG:
+		{
+			ret := *v
+			return &ret
+		}
+	case *compExpNG:
+		{
+			ret := *v
+			return &ret
+		}
+	case *compExpL:
+		{
+			ret := *v
+			return &ret
+		}
+	case *compExpNL:
+		{
+			ret := *v
+			return &ret
+		}
+	case *compExpE:
+		{
+			ret := *v
+			return &ret
+		}
+	case *compExpNE:
+		{
+			ret := *v
+			return &ret
+		}
+	}
+	return exp
+}
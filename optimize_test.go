@@ -0,0 +1,141 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"math"
+	"testing"
+)
+
+func testOptStores() []*Storehouse {
+	id := testName("测试优化", "年龄")
+	if Names.GetIdByName("测试常量") == 0 {
+		Names.RegisterName("测试优化常量", "测试常量", 0)
+		Names.RegisterGetFuncByName("测试常量", func(store *Storehouse, id uint32) float64 {
+			return 5
+		})
+		Names.RegisterConstByName("测试常量")
+	}
+	if getFuncParser("OptPanic") == nil {
+		// 非运算异常的出错函数
+		RegisterPureFunc("OptPanic", func(store *Storehouse, params []FrmlExp) float64 {
+			panic("OptPanic")
+		}, 1)
+	}
+	var ret []*Storehouse
+	for _, v := range []float64{0, 1, 2, 30} {
+		store := NewStorehouse(nil)
+		store.Set(id, v)
+		ret = append(ret, store)
+	}
+	return ret
+}
+
+func TestOptimizeFrml(t *testing.T) {
+	stores := testOptStores()
+
+	tests := []struct {
+		exp  string
+		want string
+		// 结果随机或执行出错，不比较优化前后的值
+		noEval bool
+	}{
+		{"Sin(PI/2)*10+0*年龄", "10", false},
+		{"年龄*1+0", "年龄", false},
+		{"0+年龄", "年龄", false},
+		{"年龄/1", "年龄", false},
+		{"年龄^1", "年龄", false},
+		{"年龄^0", "1", false},
+		{"年龄*0", "0", false},
+		{"(年龄>1)*0", "0", false},
+		{"1+2*3", "7", false},
+		{"Max(1,2)+年龄", "(2+年龄)", false},
+		{"测试常量*2+年龄", "(10+年龄)", false},
+		{"If(1>2, 年龄, 年龄+1)", "(年龄+1)", false},
+		{"If(PI>3, 年龄, 1)", "年龄", false},
+		{"If(年龄>1, 2*3, 4)", "If((年龄>1),6,4)", false},
+		// 结果不是有限值的不折叠，出错的纯函数留到执行时处理
+		{"NaN()", "NaN()", false},
+		{"Inf()", "Inf()", false},
+		{"-Inf()+1", "(-Inf()+1)", false},
+		{"Inf()*0+年龄", "((Inf()*0)+年龄)", false},
+		{"OptPanic(1)+2", "(OptPanic(1)+2)", true},
+		// 有副作用的函数不省略也不折叠
+		{"0*Random(1,10)", "(0*Random(1,10))", true},
+		{"Random(1,10)+0", "Random(1,10)", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			frml, err := ParseFrmlExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			src := frml.NameExp()
+			opt := OptimizeFrml(frml)
+			if opt.NameExp() != tt.want {
+				t.Fatalf("%s，应为%s", opt.NameExp(), tt.want)
+			}
+			if frml.NameExp() != src {
+				t.Fatal("优化不应修改原表达式")
+			}
+			if tt.noEval {
+				return
+			}
+			for i, store := range stores {
+				got, want := opt.Float64(store), frml.Float64(store)
+				if (got != want) && !(math.IsNaN(got) && math.IsNaN(want)) {
+					t.Fatalf("仓库%d：%v，应为%v", i, got, want)
+				}
+			}
+
+			// 已编译的表达式优化后仍为编译的
+			compiled := OptimizeFrml(CompileFrml(frml))
+			if !isCompiled(compiled) || (compiled.NameExp() != tt.want) {
+				t.Fatalf("编译后优化：%s", compiled.NameExp())
+			}
+		})
+	}
+}
+
+func TestOptimizeCond(t *testing.T) {
+	stores := testOptStores()
+
+	tests := []struct {
+		exp  string
+		want string
+	}{
+		{"1>2 && 年龄>1", "0"},
+		{"年龄>1 || PI>3", "1"},
+		{"年龄>1 && 1<2", "(年龄>1)"},
+		{"!(1>2)", "1"},
+		{"年龄 in (1, 1+1)", "(年龄 in (1,2))"},
+		{"年龄 between 1 and 2*5", "(年龄 between 1 and 10)"},
+		{"年龄>测试常量-1", "(年龄>4)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			cond, err := ParseCondExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opt := OptimizeCond(cond)
+			if opt.NameExp() != tt.want {
+				t.Fatalf("%s，应为%s", opt.NameExp(), tt.want)
+			}
+			for i, store := range stores {
+				if got, want := opt.Check(store), cond.Check(store); got != want {
+					t.Fatalf("仓库%d：%v，应为%v", i, got, want)
+				}
+			}
+		})
+	}
+}
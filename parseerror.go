@@ -0,0 +1,334 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+// 解析错误(Parse Error)
+//
+// 解析表达式出错时抛出*ParseError，由ParseFrmlExp、ParseCondExp、ParseOperExp、ParseProcExp等返回；
+// 错误带有类别、消息键及参数、行列位置、出错的记号及所在行，子表达式（运算的值、函数参数）的错误换算到源串中的位置。
+// ParseOperExpAll、ParseProcExpAll一次收集所有错误（每行最多一个），便于编辑器同时显示
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type ParseErrorKind uint
+
+const (
+	// 语法错误：括号、运算符、分隔符等
+	PE_SYNTAX ParseErrorKind = iota
+	// 未注册的数据名、函数或条件
+	PE_NAME
+	// 无效数值
+	PE_NUMBER
+	// 函数参数错误
+	PE_FUNC
+	// 数据类型不匹配，如定点数用于浮点函数
+	PE_TYPE
+)
+
+var parseErrorKindCodes = []string{"parse_syntax", "parse_name", "parse_number", "parse_func", "parse_type"}
+
+func (this ParseErrorKind) String() string {
+	if int(this) < len(parseErrorKindCodes) {
+		return Message(parseErrorKindCodes[this])
+	}
+	return fmt.Sprintf("ParseErrorKind(%d)", uint(this))
+}
+
+// 解析错误的类别，按消息键索引，消息文本见消息目录
+var parseKinds = map[string]ParseErrorKind{
+	"trailing_chars":        PE_SYNTAX,
+	"extra_param_separator": PE_SYNTAX,
+	"compare_missing_left":  PE_SYNTAX,
+	"compare_missing_right": PE_SYNTAX,
+	"compare_chained":       PE_SYNTAX,
+	"invalid_comparator":    PE_SYNTAX,
+	"not_without_in":        PE_SYNTAX,
+	"in_missing_lparen":     PE_SYNTAX,
+	"in_empty":              PE_SYNTAX,
+	"in_missing_rparen":     PE_SYNTAX,
+	"between_missing_and":   PE_SYNTAX,
+	"missing_value":         PE_SYNTAX,
+	"missing_rparen":        PE_SYNTAX,
+	"double_slash":          PE_SYNTAX,
+	"paren_comment":         PE_SYNTAX,
+	"unexpected_rparen":     PE_SYNTAX,
+	"unexpected_lparen":     PE_SYNTAX,
+	"extra_rparen":          PE_SYNTAX,
+	"extra_space":           PE_SYNTAX,
+	"oper_missing_left":     PE_SYNTAX,
+	"oper_missing_name":     PE_SYNTAX,
+	"oper_missing_value":    PE_SYNTAX,
+	"oper_missing_equal":    PE_SYNTAX,
+	"name_trailing_chars":   PE_SYNTAX,
+	"empty_oper":            PE_SYNTAX,
+	"invalid_name":          PE_NAME,
+	"invalid_value_name":    PE_NAME,
+	"undefined_func":        PE_NAME,
+	"undefined_cond":        PE_NAME,
+	"invalid_func_name":     PE_NAME,
+	"number_out_of_range":   PE_NUMBER,
+	"invalid_number":        PE_NUMBER,
+	"func_param_count":      PE_FUNC,
+	"random_param_count":    PE_FUNC,
+	"return_missing_params": PE_FUNC,
+	"return_too_many":       PE_FUNC,
+	"func_error":            PE_FUNC,
+	"decimal_float_func":    PE_TYPE,
+	"decimal_scale":         PE_FUNC,
+}
+
+type ParseError struct {
+	Kind ParseErrorKind
+	// 消息键及参数，消息按当前语言生成，也可按消息键自行翻译
+	Key  string
+	Args []any
+	// 表达式类别：Formula、Condition、OperSet、Process
+	Flag string
+	// 行、列从1开始，列按字符（rune）计；Offset为在源串中的字符偏移
+	Line   int
+	Column int
+	Offset int
+	// 出错处的记号，在源串结尾时为空
+	Token string
+	// 出错所在行
+	Snippet string
+	Source  string
+}
+
+func (this *ParseError) Message() string {
+	return Message(this.Key, this.Args...)
+}
+
+func (this *ParseError) Error() string {
+	if this.Token != "" {
+		return dmpText(this.Flag, "parse_at_token", this.Message(), this.Line, this.Column, this.Token, this.Snippet)
+	}
+	return dmpText(this.Flag, "parse_at", this.Message(), this.Line, this.Column, this.Snippet)
+}
+
+// 按偏移计算行列、记号及所在行
+func (this *ParseError) locate(src []rune) {
+	if this.Offset > len(src) {
+		this.Offset = len(src)
+	}
+	lineStart := 0
+	this.Line = 1
+	for i := 0; i < this.Offset; i++ {
+		if src[i] == '\n' {
+			this.Line++
+			lineStart = i + 1
+		}
+	}
+	this.Column = this.Offset - lineStart + 1
+
+	lineEnd := lineStart
+	for (lineEnd < len(src)) && (src[lineEnd] != '\n') {
+		lineEnd++
+	}
+	this.Snippet = strings.TrimRight(string(src[lineStart:lineEnd]), "\r")
+	this.Token = tokenAt(src, this.Offset)
+}
+
+var twoCharSymbols = []string{"&&", "||", ">=", "<=", "!=", "==", "<<", ">>", "+=", "-=", "*=", "/=", "&=", "|="}
+
+func isTokenSymbol(char rune) bool {
+	if (char <= 32) || (char == 127) || (char == paramSeparator) || (char == stepSeparator) {
+		return true
+	}
+	return strings.ContainsRune("()+-*/%^~&|<>=!:;", char)
+}
+
+// 取偏移处的记号：连续的非符号字符，或一个（两个字符的）运算符
+func tokenAt(src []rune, offset int) string {
+	if offset >= len(src) {
+		return ""
+	}
+	if !isTokenSymbol(src[offset]) {
+		end := offset
+		for (end < len(src)) && !isTokenSymbol(src[end]) {
+			end++
+		}
+		return string(src[offset:end])
+	}
+	if offset+1 < len(src) {
+		two := string(src[offset : offset+2])
+		for _, symbol := range twoCharSymbols {
+			if two == symbol {
+				return two
+			}
+		}
+	}
+	if src[offset] <= 32 {
+		return ""
+	}
+	return string(src[offset])
+}
+
+// 不在解析器中（如函数参数检查）的解析错误，位置由外层解析器补上
+func parseFail(key string, args ...any) {
+	panic(&ParseError{Kind: parseKinds[key], Key: key, Args: args, Offset: -1})
+}
+
+func (this *parser) newError(offset int, key string, args ...any) *ParseError {
+	ret := &ParseError{
+		Kind:   parseKinds[key],
+		Key:    key,
+		Args:   args,
+		Flag:   this.errFlag,
+		Offset: offset,
+		Source: string(this.exp),
+	}
+	ret.locate(this.exp)
+	return ret
+}
+
+// 在当前位置抛出解析错误
+func (this *parser) doError(key string, args ...any) {
+	panic(this.newError(this.index, key, args...))
+}
+
+// 重新抛出子表达式的错误：mapOffset把子表达式中的偏移换算到源串，返回-1或子错误没有位置时取当前位置；
+// 非解析错误作为函数错误
+func (this *parser) rethrow(err any, mapOffset func(inner *ParseError) int) {
+	inner, ok := err.(*ParseError)
+	if !ok {
+		this.doError("func_error", err)
+	}
+
+	offset := -1
+	if (inner.Offset >= 0) && (mapOffset != nil) {
+		offset = mapOffset(inner)
+	}
+	if offset < 0 {
+		offset = this.index
+	}
+	panic(this.newError(offset, inner.Key, inner.Args...))
+}
+
+// 函数参数中的错误换算到源串中的位置，参数在readFuncParams中记录起始偏移
+func (this *parser) paramOffset(params []string) func(inner *ParseError) int {
+	offsets := this.paramOffsets
+	return func(inner *ParseError) int {
+		for i, param := range params {
+			if (param == inner.Source) && (i < len(offsets)) {
+				return offsets[i] + inner.Offset
+			}
+		}
+		return -1
+	}
+}
+
+// 括号未闭合时可能由缺失右括号引起的错误
+var unclosedKeys = map[string]bool{
+	"missing_rparen":        true,
+	"in_missing_rparen":     true,
+	"func_param_count":      true,
+	"random_param_count":    true,
+	"return_missing_params": true,
+	"return_too_many":       true,
+}
+
+// 括号未闭合：fn解析左括号所在行的内容，其中的错误（如缺失运算项）优先报告，
+// 否则在offset处报告key（一般为在左括号处报告缺失右括号）
+func (this *parser) unclosedError(offset int, key string, fn func()) {
+	func() {
+		defer func() {
+			if err := recover(); err != nil {
+				if inner, ok := err.(*ParseError); ok && unclosedKeys[inner.Key] {
+					return
+				}
+				panic(err)
+			}
+		}()
+		fn()
+	}()
+	panic(this.newError(offset, key))
+}
+
+// 解析函数参数，参数中的错误换算到源串中的位置
+func (this *parser) parseParams(params []string, fn func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			this.rethrow(err, this.paramOffset(params))
+		}
+	}()
+	fn()
+}
+
+// 回收解析错误，其它异常按原文本返回
+func recoverParse(err *error) {
+	if e := recover(); e != nil {
+		if perr, ok := e.(*ParseError); ok {
+			*err = perr
+			return
+		}
+		*err = errors.New(fmt.Sprintf("%v", e))
+	}
+}
+
+// 解析运算集合或流程；collect为true时出错的运算跳到下一行继续解析并收集错误，否则直接抛出
+func (this *operParser) parseAll(onGetOperExp func(exp OperExp)) {
+	for !this.tryParse(onGetOperExp) {
+	}
+}
+
+func (this *operParser) tryParse(onGetOperExp func(exp OperExp)) (done bool) {
+	defer func() {
+		if !this.collect {
+			return
+		}
+		if e := recover(); e != nil {
+			perr, ok := e.(*ParseError)
+			if !ok {
+				panic(e)
+			}
+			this.errs = append(this.errs, perr)
+			this.skipLine(perr.Offset)
+			done = false
+		}
+	}()
+
+	this.doParse(onGetOperExp)
+	this.checkEnd()
+	return true
+}
+
+// 跳到offset所在行的下一行
+func (this *operParser) skipLine(offset int) {
+	this.index = 0
+	this.line = 1
+	this.lineStart = 0
+	for (this.index < offset) && (this.index < this.end) {
+		if this.exp[this.index] == '\n' {
+			this.line++
+			this.lineStart = this.index + 1
+		}
+		this.index++
+	}
+	this.toLineEnd()
+}
+
+// 解析运算集合并收集所有错误，每行最多一个错误；返回的运算集合只包含没有错误的运算
+func ParseOperExpAll(exp string) (OperSet, []*ParseError) {
+	ret, _, errs := parseOperSource(exp, true)
+	return ret, errs
+}
+
+// 解析流程并收集所有错误，每行最多一个错误；返回的流程只包含没有错误的步骤
+func ParseProcExpAll(exp string) (ProcExp, []*ParseError) {
+	ret, _, errs := parseProcSource(exp, true)
+	return ret, errs
+}
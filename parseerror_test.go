@@ -0,0 +1,179 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseErrorPosition(t *testing.T) {
+	testCondStore(0, 0, 0)
+
+	parsers := map[string]func(exp string) error{
+		"Formula": func(exp string) error {
+			_, err := ParseFrmlExp(exp)
+			return err
+		},
+		"Condition": func(exp string) error {
+			_, err := ParseCondExp(exp)
+			return err
+		},
+		"OperSet": func(exp string) error {
+			_, err := ParseOperExp(exp)
+			return err
+		},
+		"Process": func(exp string) error {
+			_, err := ParseProcExp(exp)
+			return err
+		},
+	}
+
+	tests := []struct {
+		flag    string
+		exp     string
+		key     string
+		line    int
+		column  int
+		token   string
+		snippet string
+	}{
+		{"Formula", "条甲+未注册", "invalid_value_name", 1, 4, "未注册", "条甲+未注册"},
+		{"Formula", "(条甲+1", "missing_rparen", 1, 1, "(", "(条甲+1"},
+		{"Formula", "条甲*(1+(2)", "missing_rparen", 1, 4, "(", "条甲*(1+(2)"},
+		{"Formula", "Max(条甲, 2", "missing_rparen", 1, 4, "(", "Max(条甲, 2"},
+		{"Formula", "Max(条甲+, 2", "missing_value", 1, 8, ",", "Max(条甲+, 2"},
+		{"Formula", "条甲+", "missing_value", 1, 4, "", "条甲+"},
+		{"Condition", "条甲 in (1, 2", "in_missing_rparen", 1, 7, "(", "条甲 in (1, 2"},
+		{"Condition", "条甲>1 &&\n(条乙<2", "missing_rparen", 2, 1, "(", "(条乙<2"},
+		{"OperSet", "条甲 = 1\n条乙 = (3\n条丙 = 4", "missing_rparen", 2, 6, "(", "条乙 = (3"},
+		{"OperSet", "条甲 = Max(1,\n条乙 = 2", "missing_rparen", 1, 9, "(", "条甲 = Max(1,"},
+		{"OperSet", "条甲 = (条乙+\n条丙 = 4", "missing_value", 1, 10, "", "条甲 = (条乙+"},
+		{"OperSet", "条甲 = 条乙 +\n条丙 = 4", "missing_value", 1, 10, "", "条甲 = 条乙 +"},
+		{"Process", "条甲 = 1\n条乙 = 2\nreturn(条甲+\n", "missing_value", 3, 11, "", "return(条甲+"},
+		{"Process", "return(条甲>1,\n条丙 = 4", "missing_rparen", 1, 7, "(", "return(条甲>1,"},
+		{"Process", "return(\n", "missing_rparen", 1, 7, "(", "return("},
+		{"Formula", "条甲//2", "double_slash", 1, 3, "/", "条甲//2"},
+		{"OperSet", "条甲 = Max(7//2, 1)", "paren_comment", 1, 11, "/", "条甲 = Max(7//2, 1)"},
+		{"Process", "条甲 = (条乙//2)+1", "paren_comment", 1, 9, "/", "条甲 = (条乙//2)+1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			err := parsers[tt.flag](tt.exp)
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("应返回解析错误：%v", err)
+			}
+			if (pe.Flag != tt.flag) || (pe.Key != tt.key) {
+				t.Fatalf("错误：%s %s，应为%s %s", pe.Flag, pe.Key, tt.flag, tt.key)
+			}
+			if (pe.Line != tt.line) || (pe.Column != tt.column) || (pe.Token != tt.token) || (pe.Snippet != tt.snippet) {
+				t.Fatalf("位置：第%d行第%d列[%s]“%s”，应为第%d行第%d列[%s]“%s”",
+					pe.Line, pe.Column, pe.Token, pe.Snippet, tt.line, tt.column, tt.token, tt.snippet)
+			}
+		})
+	}
+}
+
+// 误把“//”当作整除时提示使用Div：公式中“//”不是运算符，运算集合中括号内的“//”是注释并使括号未闭合
+func TestParseDivHint(t *testing.T) {
+	testCondStore(0, 0, 0)
+
+	tests := []struct {
+		exp string
+		key string
+	}{
+		{"7//2", "double_slash"},
+		{"条甲 = Max(7//2, 1)", "paren_comment"},
+		{"条甲 = (条乙 // 2) + 1", "paren_comment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			var err error
+			if tt.key == "double_slash" {
+				_, err = ParseFrmlExp(tt.exp)
+			} else {
+				_, err = ParseOperExp(tt.exp)
+			}
+			var pe *ParseError
+			if !errors.As(err, &pe) || (pe.Key != tt.key) {
+				t.Fatalf("%v，应为%s", err, tt.key)
+			}
+			for _, lang := range []Lang{LANG_ZH, LANG_EN} {
+				if msg := MessageIn(lang, pe.Key); !strings.Contains(msg, "Div(a, b)") {
+					t.Fatalf("“%s”应提示使用Div(a, b)", msg)
+				}
+			}
+			if !strings.Contains(err.Error(), "Div(a, b)") {
+				t.Fatalf("“%v”应提示使用Div(a, b)", err)
+			}
+		})
+	}
+
+	// 括号闭合时括号内的注释不影响解析
+	if _, err := ParseOperExp("条甲 = Max(1, // 注释\n  条乙)"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 括号内可以换行，闭合后与单行的解析结果一致
+func TestParseMultiLineParen(t *testing.T) {
+	testCondStore(0, 0, 0)
+
+	tests := []struct {
+		exp  string
+		want string
+	}{
+		{"条甲 = Max(1,\n  条乙)\n条丙 = 1", "条甲 = Max(1, 条乙)\n条丙 = 1"},
+		{"条甲 = If(条乙 > 1,\n  2,\n  3)", "条甲 = If(条乙 > 1, 2, 3)"},
+		{"return(条甲 > 1,\n  条乙)", "return(条甲 > 1, 条乙)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			proc, err := ParseProcExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := FormatProc(proc); got != tt.want {
+				t.Fatalf("%q，应为%q", got, tt.want)
+			}
+		})
+	}
+}
+
+// 收集错误时每行最多一个错误，未闭合括号的错误在左括号所在行，其后的行继续解析
+func TestParseAllErrors(t *testing.T) {
+	testCondStore(0, 0, 0)
+
+	proc, errs := ParseProcExpAll("条甲 = (3\n条乙 = 4\n条丙 = Max(1)\nreturn(条甲+\n")
+	type pos struct {
+		key  string
+		line int
+	}
+	want := []pos{{"missing_rparen", 1}, {"func_param_count", 3}, {"missing_value", 4}}
+	if len(errs) != len(want) {
+		t.Fatalf("错误：%v", errs)
+	}
+	for i, pe := range errs {
+		if (pe.Key != want[i].key) || (pe.Line != want[i].line) {
+			t.Fatalf("第%d个错误：%s 第%d行，应为%s 第%d行", i, pe.Key, pe.Line, want[i].key, want[i].line)
+		}
+	}
+	if got := FormatProc(proc); got != "条乙 = 4" {
+		t.Fatalf("没有错误的运算：%q", got)
+	}
+
+	if _, errs := ParseOperExpAll("条甲 = 1\n条乙 += 2"); len(errs) != 0 {
+		t.Fatalf("不应有错误：%v", errs)
+	}
+}
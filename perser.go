@@ -14,7 +14,7 @@ package de
 // 解析器基类(base parser)
 
 import (
-	"fmt"
+	"strings"
 )
 
 type parser struct {
@@ -25,6 +25,10 @@ type parser struct {
 	lineStart int
 	errFlag   string
 	names     INames
+	// readFuncParams读取的各参数在源串中的起始偏移
+	paramOffsets []int
+	// readFuncParams读到结尾仍未闭合的左括号的偏移，已闭合时为-1
+	unclosed int
 }
 
 func (this *parser) init(exp string, names INames, errFlag string) {
@@ -35,6 +39,7 @@ func (this *parser) init(exp string, names INames, errFlag string) {
 	this.lineStart = 0
 	this.names = names
 	this.errFlag = errFlag
+	this.unclosed = -1
 }
 
 func (this *parser) Name() INames {
@@ -80,46 +85,63 @@ func (this *parser) pass() (first int, ret bool) {
 	return
 }
 
-func (this *parser) doError(msg string) {
-	if (this.index) < this.end {
-		panic(fmt.Sprintf("[dmp]%s => %s，在第%d行第%d列字符[%s]附近，源串：%s",
-			this.errFlag, msg, this.line, this.index-this.lineStart, string(this.exp[this.index:]), string(this.exp)))
-	} else {
-		panic(fmt.Sprintf("[dmp]%s => %s，在第%d行第%d列附近，源串：%s",
-			this.errFlag, msg, this.line, this.index-this.lineStart, string(this.exp)))
+// 判断pos处是否为关键字word（不区分大小写，其后须为空白、左括号或结尾），返回关键字之后的位置
+func (this *parser) isWord(pos int, word string) (bool, int) {
+	for _, w := range word {
+		if pos >= this.end {
+			return false, pos
+		}
+		char := this.exp[pos]
+		if (char >= 'A') && (char <= 'Z') {
+			char = char - 'A' + 'a'
+		}
+		if char != w {
+			return false, pos
+		}
+		pos++
 	}
-
+	if pos < this.end {
+		char := this.exp[pos]
+		if (char > 32) && (char != 127) && (char != '(') {
+			return false, pos
+		}
+	}
+	return true, pos
 }
 
 func (this *parser) checkEnd() {
 	this.pass()
 	if this.index < this.end {
-		this.doError("表达式后面有多余字符")
+		this.doError("trailing_chars")
 	}
 }
 
 func (this *parser) getNameId(name string) uint32 {
 	ret := this.names.GetIdByName(name)
 	if ret == 0 {
-		this.doError("无效数据名：" + name)
+		this.doError("invalid_name", name)
 	}
 	return ret
 }
 
 func (this *parser) readFuncParams() (ret []string) {
+	open := this.index
 	this.index++
 	start := -1
 	leftParentheses := 0
+	this.paramOffsets = this.paramOffsets[:0]
+	this.unclosed = -1
 
 	getParam := func() {
 		if start == -1 {
 			return
 		}
 		param := string(this.exp[start:this.index])
-		start = -1
 		if param != "" {
 			ret = append(ret, param)
+			this.paramOffsets = append(this.paramOffsets, start)
 		}
+		start = -1
 	}
 
 	for {
@@ -141,11 +163,7 @@ func (this *parser) readFuncParams() (ret []string) {
 		case ')':
 			{
 				leftParentheses--
-				if leftParentheses == 0 {
-					this.index++
-					getParam()
-					continue
-				} else if leftParentheses < 0 {
+				if leftParentheses < 0 {
 					getParam()
 					return
 				}
@@ -153,7 +171,7 @@ func (this *parser) readFuncParams() (ret []string) {
 		case paramSeparator:
 			{
 				if start == -1 {
-					this.doError("此处出现多余函数参数分隔符：" + string(paramSeparator))
+					this.doError("extra_param_separator", string(paramSeparator))
 				}
 				if leftParentheses == 0 {
 					getParam()
@@ -164,5 +182,32 @@ func (this *parser) readFuncParams() (ret []string) {
 		this.index++
 	}
 
+	getParam()
+	this.unclosed = open
+	return this.openLineParams(open, ret)
+}
+
+// 未闭合的函数只保留左括号所在行的参数，其后的行多为后续的运算
+func (this *parser) openLineParams(open int, params []string) (ret []string) {
+	lineEnd := open
+	for (lineEnd < this.end) && (this.exp[lineEnd] != '\n') {
+		lineEnd++
+	}
+	offsets := this.paramOffsets
+	this.paramOffsets = nil
+	for i, param := range params {
+		offset := offsets[i]
+		if offset >= lineEnd {
+			break
+		}
+		if end := offset + len([]rune(param)); end > lineEnd {
+			param = string(this.exp[offset:lineEnd])
+		}
+		param = strings.TrimRight(param, " \t\r")
+		if param != "" {
+			ret = append(ret, param)
+			this.paramOffsets = append(this.paramOffsets, offset)
+		}
+	}
 	return
 }
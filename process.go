@@ -14,8 +14,6 @@ package de
 // 运算流程工艺控制系统(Operation Process Control System)
 
 import (
-	"errors"
-	"fmt"
 	"strconv"
 	"strings"
 )
@@ -147,6 +145,12 @@ func (this *procExp) recordRawId(names *procNames) {
 	}
 }
 
+// 设置流程的名字系统（流程临时变量及引用的仓库数据）
+func (this *procExp) setNames(names *procNames) {
+	this.store.setNames(&names.names)
+	this.recordRawId(names)
+}
+
 type procParser struct {
 	operParser
 }
@@ -156,6 +160,14 @@ type procNames struct {
 	rawIds map[uint32]uint32
 }
 
+func newProcNames() *procNames {
+	ret := &procNames{}
+	ret.init()
+	ret.rawIds = make(map[uint32]uint32)
+	ret.RegisterNameOfOrderId("return", 1, 0, 0, 0)
+	return ret
+}
+
 func (this *procNames) GetIdByName(name string) uint32 {
 	id := this.names.GetIdByName(name)
 	if id != 0 {
@@ -174,77 +186,101 @@ func (this *procParser) doBuildExp(symbol rune, nameStart, nameEnd int) (ret Ope
 	if symbol == '(' {
 		name := string(this.exp[nameStart:this.index])
 		if name == "" {
-			this.doError("此处不应该出现左括号")
+			this.doError("unexpected_lparen")
 		}
 		if strings.ToLower(name) != "return" {
-			this.doError("无效函数名称：" + name)
+			this.doError("invalid_func_name", name)
 		}
 
 		params := this.readFuncParams()
-		len := len(params)
-		switch len {
-		case 0:
-			{
-				this.doError("return函数必须缺失参数")
-			}
-		case 1:
-			{
-				return &returnExp{value: parseFrmlExpByNames(params[0], this.names)}
-			}
-		case 2:
-			{
-				return &ifReturnExp{
-					cond:  parseCondExpByNames(params[0], this.names),
-					value: parseFrmlExpByNames(params[1], this.names),
-				}
-			}
-		default:
-			{
-				this.doError("return函数的参数数量不能超过2个")
-			}
+		if this.unclosed >= 0 {
+			this.unclosedError(this.unclosed, "missing_rparen", func() {
+				this.returnExp(params)
+			})
 		}
+		return this.returnExp(params)
 	}
 
 	return this.operParser.doBuildExp(symbol, nameStart, nameEnd)
 }
 
+// 按参数个数生成return(值)或return(条件,值)
+func (this *procParser) returnExp(params []string) OperExp {
+	switch len(params) {
+	case 0:
+		{
+			this.doError("return_missing_params")
+		}
+	case 1:
+		{
+			ret := &returnExp{}
+			this.parseParams(params, func() {
+				ret.value = parseFrmlExpByNames(params[0], this.names)
+			})
+			return ret
+		}
+	case 2:
+		{
+			ret := &ifReturnExp{}
+			this.parseParams(params, func() {
+				ret.cond = parseCondExpByNames(params[0], this.names)
+				ret.value = parseFrmlExpByNames(params[1], this.names)
+			})
+			return ret
+		}
+	}
+	this.doError("return_too_many")
+	return nil
+}
+
 func parseProcExp(exp string) ProcExp {
+	ret, _ := parseProcLines(exp)
+	return ret
+}
+
+// 解析流程，同时返回各步骤开始的行号
+func parseProcLines(exp string) (ProcExp, []int) {
+	ret, lines, _ := parseProcSource(exp, false)
+	return ret, lines
+}
+
+// 解析流程；collect为true时收集所有错误，否则遇到错误即抛出
+func parseProcSource(exp string, collect bool) (ProcExp, []int, []*ParseError) {
 	ret := procExp{}
 	ret.store = &Storehouse{}
 	ret.store.init(nil, nil)
+	lines := []int{}
 
 	if exp == "" {
-		return &ret
+		return &ret, lines, nil
 	}
 
+	var perser *procParser
 	onGetOperExp := func(exp OperExp) {
 		ret.operSet = append(ret.operSet, exp)
+		lines = append(lines, perser.stepLine)
 	}
 
-	nms := &procNames{}
-	nms.init()
-	nms.rawIds = make(map[uint32]uint32)
-	nms.RegisterNameOfOrderId("return", 1, 0, 0, 0)
+	nms := newProcNames()
 
-	perser := &procParser{}
+	perser = &procParser{}
 	perser.buildExp = perser.doBuildExp
 	perser.init(exp, nms, "Process")
-	perser.doParse(onGetOperExp)
-	perser.checkEnd()
+	perser.collect = collect
+	perser.parseAll(onGetOperExp)
 
-	if len(ret.operSet) == 0 {
-		perser.doError("无效运算表达式")
+	if (len(ret.operSet) == 0) && (len(perser.errs) == 0) {
+		if !collect {
+			perser.doError("empty_oper")
+		}
+		perser.errs = append(perser.errs, perser.newError(perser.index, "empty_oper"))
 	}
 
-	ret.store.setNames(&nms.names)
-	ret.recordRawId(nms)
-	return &ret
+	ret.setNames(nms)
+	return &ret, lines, perser.errs
 }
 
-func ParseProcExp(exp string) (ProcExp, error) {
-	if err := recover(); err != nil {
-		return nil, errors.New(fmt.Sprintf("%v", err))
-	}
-	ret := parseProcExp(exp)
-	return ret, nil
+func ParseProcExp(exp string) (ret ProcExp, err error) {
+	defer recoverParse(&err)
+	return parseProcExp(exp), nil
 }
@@ -0,0 +1,497 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+// 快照、录制与回放(Snapshot, Record and Replay)
+
+// 录制日志格式：日志标识 + 初始快照 + 变化记录（序号、运算符、ID、操作数、结果）
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// 录制日志中表示数据复位的运算符
+const (
+	recResetId OperSymbol = 0xF0 + iota
+	recResetAll
+	recResetCycle
+)
+
+const (
+	snapshotMagic = "DESS"
+	recordMagic   = "DERL"
+)
+
+var ErrVersionDiverged error = &CodeError{Flag: "Snapshot", Code: "snapshot_version"}
+var ErrNamesDiverged error = &CodeError{Flag: "Snapshot", Code: "snapshot_names"}
+var ErrBadFormat error = &CodeError{Flag: "Snapshot", Code: "snapshot_format"}
+
+type SnapshotData struct {
+	Id    uint32
+	Value float64
+}
+
+// 计时中或已成立的保持条件，Elapsed不小于保持时长的为已成立，Key见CondOpt.Hold
+type SnapshotHold struct {
+	Key     string
+	Elapsed time.Duration
+}
+
+type Snapshot struct {
+	Version   string
+	NamesHash uint64
+	// 快照时录制器的序号，未录制时为0
+	Seq   uint64
+	Datas []SnapshotData
+	Holds []SnapshotHold
+}
+
+func (this *Storehouse) namesHash() uint64 {
+	if nms, ok := this.names.(*names); ok {
+		return nms.Fingerprint()
+	}
+	return 0
+}
+
+func (this *Storehouse) Snapshot() *Snapshot {
+	ret := &Snapshot{
+		Version:   Version,
+		NamesHash: this.namesHash(),
+	}
+	if this.recorder != nil {
+		ret.Seq = this.recorder.seq
+	}
+
+	for id := 1; id < len(this.datasOfOrderId); id++ {
+		ret.Datas = append(ret.Datas, SnapshotData{Id: uint32(id), Value: this.datasOfOrderId[id]})
+	}
+
+	ids := make([]uint32, 0, len(this.datasOfHashId))
+	for id, data := range this.datasOfHashId {
+		if data.cfg != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		ret.Datas = append(ret.Datas, SnapshotData{Id: id, Value: this.datasOfHashId[id].Value})
+	}
+
+	for _, wLog := range this.workstatLog {
+		for _, listers := range wLog.listerOfCond {
+			for _, lister := range listers {
+				if elapsed := lister.held(); elapsed >= 0 {
+					ret.Holds = append(ret.Holds, SnapshotHold{Key: lister.holdKey(), Elapsed: elapsed})
+				}
+			}
+		}
+	}
+	for key, elapseds := range this.restoredHolds {
+		for _, elapsed := range elapseds {
+			ret.Holds = append(ret.Holds, SnapshotHold{Key: key, Elapsed: elapsed})
+		}
+	}
+	sort.SliceStable(ret.Holds, func(i, j int) bool { return ret.Holds[i].Key < ret.Holds[j].Key })
+
+	return ret
+}
+
+// 从快照恢复数据（不触发监听），引擎版本或名字注册表不一致时返回错误且不做修改
+func (this *Storehouse) Restore(snap *Snapshot) error {
+	if snap.Version != Version {
+		return fmt.Errorf("%w%s", ErrVersionDiverged, Message("snapshot_version_detail", snap.Version, Version))
+	}
+	if snap.NamesHash != this.namesHash() {
+		return fmt.Errorf("%w%s", ErrNamesDiverged, Message("snapshot_names_detail", snap.NamesHash, this.namesHash()))
+	}
+
+	for i := range this.datasOfOrderId {
+		this.datasOfOrderId[i] = 0
+	}
+	this.datasOfHashId = make(map[uint32]*Data)
+	this.datasOfCycle = make(map[retsetCycle][]*Data)
+	this.slots = nil
+
+	for _, data := range snap.Datas {
+		if data.Id < uint32(len(this.datasOfOrderId)) {
+			this.datasOfOrderId[data.Id] = data.Value
+		} else {
+			this.getData(data.Id).Value = data.Value
+		}
+	}
+
+	// 已监听的保持条件按恢复后的数据重新计时，尚未监听的留待监听时继续计时
+	this.restoredHolds = make(map[string][]time.Duration)
+	for _, hold := range snap.Holds {
+		this.restoredHolds[hold.Key] = append(this.restoredHolds[hold.Key], hold.Elapsed)
+	}
+	for _, wLog := range this.workstatLog {
+		for _, listers := range wLog.listerOfCond {
+			for _, lister := range listers {
+				if lister.opt.Hold <= 0 {
+					continue
+				}
+				lister.stopHold()
+				lister.state = false
+				lister.raw = lister.cond.Check(this)
+				if lister.raw {
+					lister.startHold(this.takeRestoredHold(lister.holdKey()))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// 取出快照恢复的保持计时，没有则返回0
+func (this *Storehouse) takeRestoredHold(key string) time.Duration {
+	elapseds := this.restoredHolds[key]
+	if len(elapseds) == 0 {
+		return 0
+	}
+	if len(elapseds) == 1 {
+		delete(this.restoredHolds, key)
+	} else {
+		this.restoredHolds[key] = elapseds[1:]
+	}
+	return elapseds[0]
+}
+
+type writer struct {
+	w   io.Writer
+	n   int64
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (this *writer) write(b []byte) {
+	if this.err != nil {
+		return
+	}
+	n, err := this.w.Write(b)
+	this.n += int64(n)
+	this.err = err
+}
+
+func (this *writer) uvarint(v uint64) {
+	n := binary.PutUvarint(this.buf[:], v)
+	this.write(this.buf[:n])
+}
+
+func (this *writer) uint64(v uint64) {
+	binary.LittleEndian.PutUint64(this.buf[:8], v)
+	this.write(this.buf[:8])
+}
+
+func (this *writer) float64(v float64) {
+	this.uint64(math.Float64bits(v))
+}
+
+func (this *writer) string(v string) {
+	this.uvarint(uint64(len(v)))
+	this.write([]byte(v))
+}
+
+type reader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (this *reader) uvarint() uint64 {
+	if this.err != nil {
+		return 0
+	}
+	ret, err := binary.ReadUvarint(this.r)
+	this.err = err
+	return ret
+}
+
+func (this *reader) bytes(n int) []byte {
+	if this.err != nil {
+		return nil
+	}
+	ret := make([]byte, n)
+	_, this.err = io.ReadFull(this.r, ret)
+	return ret
+}
+
+func (this *reader) byte() byte {
+	if this.err != nil {
+		return 0
+	}
+	ret, err := this.r.ReadByte()
+	this.err = err
+	return ret
+}
+
+func (this *reader) uint64() uint64 {
+	b := this.bytes(8)
+	if this.err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (this *reader) float64() float64 {
+	return math.Float64frombits(this.uint64())
+}
+
+func (this *reader) string() string {
+	n := this.uvarint()
+	if n > 1<<20 {
+		this.err = ErrBadFormat
+	}
+	return string(this.bytes(int(n)))
+}
+
+func (this *reader) magic(magic string) {
+	if (string(this.bytes(len(magic))) != magic) && (this.err == nil) {
+		this.err = ErrBadFormat
+	}
+}
+
+func (this *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	wr := &writer{w: w}
+	this.write(wr)
+	return wr.n, wr.err
+}
+
+func (this *Snapshot) write(wr *writer) {
+	wr.write([]byte(snapshotMagic))
+	wr.string(this.Version)
+	wr.uint64(this.NamesHash)
+	wr.uvarint(this.Seq)
+	wr.uvarint(uint64(len(this.Datas)))
+	for _, data := range this.Datas {
+		wr.uvarint(uint64(data.Id))
+		wr.float64(data.Value)
+	}
+	wr.uvarint(uint64(len(this.Holds)))
+	for _, hold := range this.Holds {
+		wr.string(hold.Key)
+		wr.uint64(uint64(hold.Elapsed))
+	}
+}
+
+func readSnapshot(rd *reader) *Snapshot {
+	rd.magic(snapshotMagic)
+	ret := &Snapshot{}
+	ret.Version = rd.string()
+	ret.NamesHash = rd.uint64()
+	ret.Seq = rd.uvarint()
+	count := rd.uvarint()
+	for i := uint64(0); (i < count) && (rd.err == nil); i++ {
+		id := rd.uvarint()
+		value := rd.float64()
+		ret.Datas = append(ret.Datas, SnapshotData{Id: uint32(id), Value: value})
+	}
+	count = rd.uvarint()
+	for i := uint64(0); (i < count) && (rd.err == nil); i++ {
+		key := rd.string()
+		elapsed := time.Duration(rd.uint64())
+		ret.Holds = append(ret.Holds, SnapshotHold{Key: key, Elapsed: elapsed})
+	}
+	return ret
+}
+
+func newReader(r io.Reader) *reader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &reader{r: br}
+}
+
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	rd := newReader(r)
+	ret := readSnapshot(rd)
+	if rd.err != nil {
+		if rd.err == io.EOF {
+			rd.err = io.ErrUnexpectedEOF
+		}
+		return nil, rd.err
+	}
+	return ret, nil
+}
+
+// 录制器：记录仓库的每一次运算和复位
+type Recorder struct {
+	store *Storehouse
+	wr    *writer
+	bw    *bufio.Writer
+	seq   uint64
+}
+
+// 开始录制，先写入当前数据快照
+func (this *Storehouse) Record(w io.Writer) (*Recorder, error) {
+	if this.recorder != nil {
+		return nil, &CodeError{Flag: "Storehouse.Record", Code: "already_recording"}
+	}
+
+	ret := &Recorder{store: this}
+	ret.bw = bufio.NewWriter(w)
+	ret.wr = &writer{w: ret.bw}
+	ret.wr.write([]byte(recordMagic))
+	this.Snapshot().write(ret.wr)
+	if ret.wr.err != nil {
+		return nil, ret.wr.err
+	}
+
+	this.recorder = ret
+	return ret, nil
+}
+
+func (this *Recorder) record(id uint32, operSymbol OperSymbol, value, result float64) {
+	this.seq++
+	this.wr.uvarint(this.seq)
+	this.wr.write([]byte{byte(operSymbol)})
+	this.wr.uvarint(uint64(id))
+	this.wr.float64(value)
+	this.wr.float64(result)
+}
+
+// 最后一条记录的序号
+func (this *Recorder) Seq() uint64 {
+	return this.seq
+}
+
+func (this *Recorder) Flush() error {
+	if this.wr.err != nil {
+		return this.wr.err
+	}
+	return this.bw.Flush()
+}
+
+// 停止录制并写出缓冲数据，返回录制过程中的首个写错误
+func (this *Recorder) Stop() error {
+	if this.store.recorder == this {
+		this.store.recorder = nil
+	}
+	return this.Flush()
+}
+
+type ReplayRecord struct {
+	Seq        uint64
+	Id         uint32
+	OperSymbol OperSymbol
+	Value      float64
+	Result     float64
+}
+
+// 回放器：由录制日志中的初始快照（或指定快照）加上变化记录重建仓库
+type Replayer struct {
+	snapshot *Snapshot
+	records  []ReplayRecord
+}
+
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	rd := newReader(r)
+	rd.magic(recordMagic)
+	ret := &Replayer{}
+	ret.snapshot = readSnapshot(rd)
+	if rd.err != nil {
+		if rd.err == io.EOF {
+			rd.err = io.ErrUnexpectedEOF
+		}
+		return nil, rd.err
+	}
+
+	for {
+		rec := ReplayRecord{}
+		rec.Seq = rd.uvarint()
+		if rd.err == io.EOF {
+			break
+		}
+		rec.OperSymbol = OperSymbol(rd.byte())
+		rec.Id = uint32(rd.uvarint())
+		rec.Value = rd.float64()
+		rec.Result = rd.float64()
+		if rd.err != nil {
+			if rd.err == io.EOF {
+				rd.err = io.ErrUnexpectedEOF
+			}
+			return nil, rd.err
+		}
+		ret.records = append(ret.records, rec)
+	}
+
+	return ret, nil
+}
+
+func (this *Replayer) Snapshot() *Snapshot {
+	return this.snapshot
+}
+
+func (this *Replayer) Records() []ReplayRecord {
+	return this.records
+}
+
+// 回放至序号untilSeq（含）为止，untilSeq为0时回放全部记录
+func (this *Replayer) Replay(untilSeq uint64) (*Storehouse, error) {
+	return this.ReplayFrom(this.snapshot, untilSeq)
+}
+
+// 从指定快照开始回放序号大于快照序号的记录；回放不触发监听，
+// 回放结果与录制结果不一致时返回错误及回放到该记录时的仓库
+func (this *Replayer) ReplayFrom(snap *Snapshot, untilSeq uint64) (*Storehouse, error) {
+	ret := NewStorehouse(nil)
+	if err := ret.Restore(snap); err != nil {
+		return nil, err
+	}
+
+	ret.allowTriggerChgEvt = false
+	defer func() {
+		ret.allowTriggerChgEvt = true
+	}()
+
+	for _, rec := range this.records {
+		if rec.Seq <= snap.Seq {
+			continue
+		}
+		if (untilSeq != 0) && (rec.Seq > untilSeq) {
+			break
+		}
+
+		result := rec.Result
+		switch rec.OperSymbol {
+		case recResetId:
+			{
+				result = ret.ResetById(rec.Id)
+			}
+		case recResetAll:
+			{
+				ret.Reset()
+			}
+		case recResetCycle:
+			{
+				ret.ResetByCycle(retsetCycle(rec.Value))
+			}
+		default:
+			{
+				result = ret.Oper(rec.Id, rec.OperSymbol, rec.Value)
+			}
+		}
+
+		if math.Float64bits(result) != math.Float64bits(rec.Result) {
+			return ret, &CodeError{Flag: "Replayer.Replay", Code: "replay_diverged",
+				Args: []any{rec.Seq, ret.names.GetNameById(rec.Id), rec.Result, result}}
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,188 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	a := testName("测试快照", "快照甲")
+	b := testName("测试快照", "快照乙")
+	store := NewStorehouse(nil)
+	store.Set(a, 1.5)
+	store.Set(b, 3)
+
+	snap := store.Snapshot()
+	buf := &bytes.Buffer{}
+	if _, err := snap.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadSnapshot(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, snap) {
+		t.Fatalf("读出的快照不一致：%+v，应为%+v", read, snap)
+	}
+
+	other := NewStorehouse(nil)
+	if err := other.Restore(read); err != nil {
+		t.Fatal(err)
+	}
+	if (other.Get(a) != 1.5) || (other.Get(b) != 3) {
+		t.Fatalf("恢复后：%v %v", other.Get(a), other.Get(b))
+	}
+
+	if _, err := ReadSnapshot(bytes.NewReader([]byte("XXXX"))); !errors.Is(err, ErrBadFormat) {
+		t.Fatalf("无效格式：%v", err)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	a := testName("测试录制", "录制甲")
+	b := testName("测试录制", "录制乙")
+	store := NewStorehouse(nil)
+	store.Set(a, 10)
+	// 监听中的运算不回放监听，靠录制记录重建
+	store.Lister.AddById(a, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+		store.Oper(b, OS_INC, value)
+	})
+
+	buf := &bytes.Buffer{}
+	rec, err := store.Record(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Record(buf); err == nil {
+		t.Fatal("重复录制应返回错误")
+	}
+	store.Oper(a, OS_INC, 5)
+	mid := store.Snapshot()
+	store.Oper(a, OS_MUL, 2)
+	store.ResetById(b)
+	store.Oper(a, OS_DIV, 3)
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayer, err := NewReplayer(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayer.Records()) != int(rec.Seq()) {
+		t.Fatalf("记录%d条，应为%d条", len(replayer.Records()), rec.Seq())
+	}
+
+	tests := []struct {
+		name  string
+		from  *Snapshot
+		until uint64
+		want  *Snapshot
+	}{
+		{"全部", replayer.Snapshot(), 0, store.Snapshot()},
+		{"从中间快照", mid, 0, store.Snapshot()},
+		{"回放到中间", replayer.Snapshot(), mid.Seq, mid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := replayer.ReplayFrom(tt.from, tt.until)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Snapshot().Datas, tt.want.Datas) {
+				t.Fatalf("%v，应为%v", got.Snapshot().Datas, tt.want.Datas)
+			}
+		})
+	}
+}
+
+// Set函数中的运算不录制，回放时由Set函数重新产生，不会执行两次
+func TestRecordSetFunc(t *testing.T) {
+	total := testName("测试录制Set", "录制总额")
+	part := testName("测试录制Set", "录制分项")
+	Names.RegisterSetFuncById(total, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) float64 {
+		return store.Oper(part, operSymbol, value)
+	})
+
+	store := NewStorehouse(nil)
+	buf := &bytes.Buffer{}
+	rec, _ := store.Record(buf)
+	store.Oper(total, OS_INC, 2)
+	store.Oper(total, OS_INC, 3)
+	rec.Stop()
+	if rec.Seq() != 2 {
+		t.Fatalf("录制%d条，应为2条", rec.Seq())
+	}
+
+	replayer, err := NewReplayer(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := replayer.Replay(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Get(part) != store.Get(part) {
+		t.Fatalf("回放结果%v，应为%v", got.Get(part), store.Get(part))
+	}
+}
+
+func TestRecordDiverged(t *testing.T) {
+	nms := newNames()
+	nms.RegisterNameByInfo("甲类", "分歧甲", 0, RSC_TEMP, 0, 0, 100)
+	store := &Storehouse{}
+	store.init(nil, nms)
+	store.Set(nms.GetIdByName("分歧甲"), 10)
+	snap := store.Snapshot()
+
+	if err := store.Restore(snap); err != nil {
+		t.Fatal(err)
+	}
+	old := snap.Version
+	snap.Version = "0.0.1"
+	if err := store.Restore(snap); !errors.Is(err, ErrVersionDiverged) {
+		t.Fatalf("版本不一致：%v", err)
+	}
+	snap.Version = old
+
+	tests := []struct {
+		name string
+		reg  func(nms *names)
+	}{
+		{"名字", func(nms *names) { nms.RegisterNameByInfo("甲类", "分歧乙", 0, RSC_TEMP, 0, 0, 100) }},
+		{"类型", func(nms *names) { nms.RegisterNameByInfo("乙类", "分歧甲", 1, RSC_TEMP, 0, 0, 100) }},
+		{"上下限", func(nms *names) { nms.RegisterNameByInfo("甲类", "分歧甲", 0, RSC_TEMP, 0, -1, 100) }},
+		{"复位周期", func(nms *names) { nms.RegisterNameByInfo("甲类", "分歧甲", 0, RSC_DAY, 0, 0, 100) }},
+		{"定点精度", func(nms *names) {
+			nms.RegisterNameByInfo("甲类", "分歧甲", 0, RSC_TEMP, 0, 0, 100)
+			nms.RegisterDecimalByName("分歧甲", 2, RM_HALF_UP)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := newNames()
+			tt.reg(other)
+			if other.Fingerprint() == nms.Fingerprint() {
+				t.Fatal("指纹应不同")
+			}
+			otherStore := &Storehouse{}
+			otherStore.init(nil, other)
+			if err := otherStore.Restore(snap); !errors.Is(err, ErrNamesDiverged) {
+				t.Fatalf("名字注册表不一致：%v", err)
+			}
+		})
+	}
+}
@@ -11,18 +11,22 @@ package de
 
 *******************************************************************************/
 
-import (
-	"log"
-)
+// 引擎版本，录制日志和快照中记录此版本用于检测回放环境是否一致
+const Version = "1.2.0"
 
 var stepSeparator = ' '
 var paramSeparator = ','
 
 var outStepLog = false
-var writeLog = log.Printf
 
-func SetLogFunc(value func(format string, v ...any)) {
-	writeLog = value
+var clock Clock = sysClock{}
+
+// 设置引擎时钟，默认为系统时钟
+func SetClock(value Clock) {
+	if value == nil {
+		value = sysClock{}
+	}
+	clock = value
 }
 
 func SetOutStepLog(value bool) {
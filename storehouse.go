@@ -15,6 +15,9 @@ package de
 
 import (
 	"fmt"
+	"math"
+	"sync"
+	"time"
 	"unsafe"
 )
 
@@ -27,6 +30,9 @@ const (
 	OS_MUL
 	OS_DIV
 	OS_SET
+	// 按位与、或，按int64运算
+	OS_AND
+	OS_OR
 )
 
 type Data struct {
@@ -59,7 +65,30 @@ type Storehouse struct {
 	datasOfCycle       map[retsetCycle][]*Data
 	Owner              unsafe.Pointer
 	Lister             *lister
+	listenQueue        *listenQueue
 	workstatLog        map[*Workstat]*workstatLog
+	cascadeIds         []uint32
+	cascadeLimit       int
+	// 各类运算异常的次数
+	faults        [afCount]uint64
+	deferNested   bool
+	deferredOpers []deferredOper
+	onCascade     func(store *Storehouse, err *CascadeError)
+	batchDepth    int
+	batchPending  []*limitState
+	recorder      *Recorder
+	// 正在执行的Set函数层数
+	setFuncDepth  int
+	restoredHolds map[string][]time.Duration
+	// 投递到拥有者协程执行的定时回调，见Pump
+	timerMutex  sync.Mutex
+	timerPosts  []func()
+	onTimerPost func()
+	// 编译表达式的数据槽表，每个在本仓库执行过的程序一个，见progSlots
+	slots map[*program][]*float64
+	// 最近执行的程序及其槽表，连续执行同一程序时免去查找
+	lastProg  *program
+	lastSlots []*float64
 }
 
 func NewStorehouse(owner unsafe.Pointer) *Storehouse {
@@ -75,6 +104,7 @@ func (this *Storehouse) init(owner unsafe.Pointer, names *names) {
 	this.Owner = owner
 	this.Lister = newLister()
 	this.workstatLog = make(map[*Workstat]*workstatLog)
+	this.cascadeLimit = defaultCascadeLimit
 	if names != nil {
 		this.setNames(names)
 	}
@@ -82,6 +112,7 @@ func (this *Storehouse) init(owner unsafe.Pointer, names *names) {
 
 func (this *Storehouse) setNames(names *names) {
 	this.names = names
+	this.slots = nil
 	count := names.orderIdCount
 	if count > 0 {
 		count++
@@ -90,7 +121,7 @@ func (this *Storehouse) setNames(names *names) {
 		for i := uint32(1); i < count; i++ {
 			cfg := names.GetCfgById(i)
 			if cfg == nil {
-				panic(fmt.Sprintf("[dmp]NewStorehouse => ID为“%d”的有序数据名缺失", i))
+				panic(dmpText("NewStorehouse", "order_name_missing", i))
 			}
 			this.cfgsOfOrderId[i] = cfg
 		}
@@ -101,12 +132,148 @@ func (this *Storehouse) Set(id uint32, value float64) float64 {
 	return this.Oper(id, OS_SET, value)
 }
 
-func (this *Storehouse) Oper(id uint32, operSymbol OperSymbol, value float64) (ret float64) {
+// 监听连锁触发（监听回调中再修改数据）的默认限定深度
+const defaultCascadeLimit = 32
+
+// 监听连锁触发超过限定深度时的错误，Path为触发路径上的数据ID
+type CascadeError struct {
+	Limit int
+	Path  []uint32
+	names INames
+}
+
+// 触发路径，如：钱包 → 税 → 钱包
+func (this *CascadeError) PathName() (ret string) {
+	ret = ""
+	for _, id := range this.Path {
+		name := this.names.GetNameById(id)
+		if name == "" {
+			name = fmt.Sprintf("%d", id)
+		}
+		if ret == "" {
+			ret = name
+		} else {
+			ret = ret + " → " + name
+		}
+	}
+	return
+}
+
+func (this *CascadeError) Error() string {
+	return dmpText("Storehouse.Oper", "cascade_limit", this.Limit, this.PathName())
+}
+
+// 路径只保留最近一次出现当前ID之后的部分，即循环触发的环路
+func (this *Storehouse) newCascadeError(path []uint32, id uint32) *CascadeError {
+	start := 0
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] == id {
+			start = i
+			break
+		}
+	}
+	ret := &CascadeError{
+		Limit: this.cascadeLimit,
+		Path:  make([]uint32, 0, len(path)-start+1),
+		names: this.names,
+	}
+	ret.Path = append(ret.Path, path[start:]...)
+	ret.Path = append(ret.Path, id)
+	return ret
+}
+
+type deferredOper struct {
+	path       []uint32
+	id         uint32
+	operSymbol OperSymbol
+	value      float64
+}
+
+// 设置监听连锁触发的限定深度
+func (this *Storehouse) SetCascadeLimit(limit int) {
+	if limit <= 0 {
+		limit = defaultCascadeLimit
+	}
+	this.cascadeLimit = limit
+}
+
+// 设置为true时，监听回调中对数据的修改推迟到外层运算（包括其所有监听）完成后再依次执行，
+// 推迟的Oper返回修改前的值
+func (this *Storehouse) SetDeferNested(value bool) {
+	this.deferNested = value
+}
+
+// 设置连锁触发超限时的处理函数，默认写日志
+func (this *Storehouse) SetCascadeFunc(fn func(store *Storehouse, err *CascadeError)) {
+	this.onCascade = fn
+}
+
+func (this *Storehouse) Oper(id uint32, operSymbol OperSymbol, value float64) float64 {
 	if id == 0 {
-		panic("[dmp]Storehouse.Oper => 数据ID为0")
+		panic(dmpText("Storehouse.Oper", "data_id_zero"))
+	}
+
+	depth := len(this.cascadeIds)
+	if depth == 0 {
+		return this.operTop(id, operSymbol, value)
+	}
+
+	if depth >= this.cascadeLimit {
+		panic(this.newCascadeError(this.cascadeIds, id))
+	}
+	if this.deferNested {
+		this.deferredOpers = append(this.deferredOpers, deferredOper{
+			path:       append([]uint32(nil), this.cascadeIds...),
+			id:         id,
+			operSymbol: operSymbol,
+			value:      value,
+		})
+		return this.Get(id)
 	}
+	return this.oper(id, operSymbol, value)
+}
+
+// 最外层运算：负责回收连锁触发超限错误以及执行推迟的运算
+func (this *Storehouse) operTop(id uint32, operSymbol OperSymbol, value float64) (ret float64) {
+	defer func() {
+		if err := recover(); err != nil {
+			cerr, ok := err.(*CascadeError)
+			if !ok {
+				panic(err)
+			}
+			this.cascadeIds = nil
+			this.deferredOpers = nil
+			if this.onCascade != nil {
+				this.onCascade(this, cerr)
+			} else {
+				writeLog(&LogEntry{Level: LL_ERROR, Code: "cascade_limit", Args: []any{cerr.Limit, cerr.PathName()},
+					Flag: "Storehouse.Oper", Name: this.names.GetNameById(id), Id: id, Err: cerr})
+			}
+			ret = this.Get(id)
+		}
+	}()
 
+	ret = this.oper(id, operSymbol, value)
+
+	for len(this.deferredOpers) > 0 {
+		op := this.deferredOpers[0]
+		this.deferredOpers = this.deferredOpers[1:]
+		if len(op.path) >= this.cascadeLimit {
+			panic(this.newCascadeError(op.path, op.id))
+		}
+		this.cascadeIds = op.path
+		this.oper(op.id, op.operSymbol, op.value)
+		this.cascadeIds = nil
+	}
+	this.deferredOpers = nil
+	return
+}
+
+func (this *Storehouse) oper(id uint32, operSymbol OperSymbol, value float64) (ret float64) {
+	// 运算异常策略为FA_ERROR时运算中止，不记录也不触发监听
+	aborted := false
 	doOper := func(cfg *nameCfg, oldValue float64) (newValue float64) {
+		aborted = true
 		switch operSymbol {
 		case OS_INC:
 			newValue = oldValue + value
@@ -117,9 +284,39 @@ func (this *Storehouse) Oper(id uint32, operSymbol OperSymbol, value float64) (r
 		case OS_DIV:
 			if value != 0 {
 				newValue = oldValue / value
+			} else {
+				newValue = this.operFault(AF_OPER_DIV_ZERO, cfg, operSymbol, oldValue, value, oldValue/value)
 			}
 		case OS_SET:
 			newValue = value
+		case OS_AND, OS_OR:
+			ov, ok := toInt64(oldValue)
+			v, ok2 := toInt64(value)
+			if ok && ok2 {
+				if operSymbol == OS_AND {
+					newValue = float64(ov & v)
+				} else {
+					newValue = float64(ov | v)
+				}
+			} else {
+				if warnFault(AF_NOT_INT) {
+					exp := fmt.Sprintf("%s%s%v", cfg.name, operSymbolText[operSymbol], value)
+					writeLog(&LogEntry{Level: LL_WARN, Code: "int_operands", Args: []any{exp, oldValue, value},
+						Flag: "Storehouse.Oper", Exp: exp, Name: cfg.name, Id: id})
+				}
+				newValue = this.operFault(AF_NOT_INT, cfg, operSymbol, oldValue, value, 0)
+			}
+		}
+
+		dec := cfg.decimal()
+		if dec != nil {
+			newValue = dec.operSymbol(this, operSymbol, oldValue, value, newValue)
+		}
+
+		if math.IsNaN(newValue) {
+			newValue = this.operFault(AF_NAN, cfg, operSymbol, oldValue, value, newValue)
+		} else if math.IsInf(newValue, 0) {
+			newValue = this.operFault(AF_INF, cfg, operSymbol, oldValue, value, newValue)
 		}
 
 		if (cfg.max != 0) && (newValue > cfg.max) {
@@ -127,18 +324,30 @@ func (this *Storehouse) Oper(id uint32, operSymbol OperSymbol, value float64) (r
 		} else if newValue < cfg.min {
 			newValue = cfg.min
 		}
+		if dec != nil {
+			newValue = dec.RoundValue(newValue)
+		}
+		aborted = false
 		return
 	}
 
 	defer func() {
+		if aborted {
+			return
+		}
+		// Set函数中的运算在回放时由Set函数重新产生，不录制；监听不回放，监听中的运算照常录制
+		if (this.recorder != nil) && (this.setFuncDepth == 0) {
+			this.recorder.record(id, operSymbol, value, ret)
+		}
 		if this.allowTriggerChgEvt {
+			this.cascadeIds = append(this.cascadeIds, id)
+			depth := this.setFuncDepth
+			this.setFuncDepth = 0
 			defer func() {
-				if err := recover(); err != nil {
-					writeLog("[dmp]Storehouse.Oper => 数据监听回调异常：%v", err)
-				}
+				this.cascadeIds = this.cascadeIds[:len(this.cascadeIds)-1]
+				this.setFuncDepth = depth
 			}()
-			Lister.trigger(this, id, operSymbol, value)
-			this.Lister.trigger(this, id, operSymbol, value)
+			triggerListen(this, id, operSymbol, value)
 		}
 	}()
 
@@ -148,23 +357,17 @@ func (this *Storehouse) Oper(id uint32, operSymbol OperSymbol, value float64) (r
 		return *old
 	}
 
-	data := this.datasOfHashId[id]
-	if data == nil {
-		data = &Data{}
-		data.cfg = this.names.GetCfgById(id)
-		if data.cfg == nil {
-			writeLog("[dmp]Storehouse.Oper => 无效数据ID: %d", id)
-		}
-		this.datasOfHashId[id] = data
-		this.datasOfCycle[data.cfg.rsc] = append(this.datasOfCycle[data.cfg.rsc], data)
-	}
-
+	data := this.getData(id)
 	if data.cfg.rsc == RSC_EVENT {
 
 	} else if data.cfg.setFunc != nil {
+		this.setFuncDepth++
 		defer func() {
+			this.setFuncDepth--
 			if err := recover(); err != nil {
-				writeLog("[dmp]Storehouse.Set => 数据设置异常：" + fmt.Sprintf("%v", err))
+				rethrowArith(err)
+				writeLog(&LogEntry{Level: LL_ERROR, Code: "set_func_failed", Args: []any{err},
+					Flag: "Storehouse.Set", Name: data.cfg.name, Id: id, Err: err})
 			}
 		}()
 		data.cfg.setFunc(this, id, operSymbol, value)
@@ -177,6 +380,20 @@ func (this *Storehouse) Oper(id uint32, operSymbol OperSymbol, value float64) (r
 	return data.Value
 }
 
+func (this *Storehouse) getData(id uint32) *Data {
+	data := this.datasOfHashId[id]
+	if data == nil {
+		data = &Data{}
+		data.cfg = this.names.GetCfgById(id)
+		if data.cfg == nil {
+			writeLog(&LogEntry{Level: LL_ERROR, Code: "invalid_data_id", Args: []any{id}, Flag: "Storehouse.Oper", Id: id})
+		}
+		this.datasOfHashId[id] = data
+		this.datasOfCycle[data.cfg.rsc] = append(this.datasOfCycle[data.cfg.rsc], data)
+	}
+	return data
+}
+
 func (this *Storehouse) GetByName(name string) float64 {
 	return this.Get(this.names.GetIdByName(name))
 }
@@ -191,7 +408,9 @@ func (this *Storehouse) Get(id uint32) float64 {
 
 	defer func() {
 		if err := recover(); err != nil {
-			writeLog("[dmp]Storehouse.Get => 数据获取异常：%v", err)
+			rethrowArith(err)
+			writeLog(&LogEntry{Level: LL_ERROR, Code: "get_func_failed", Args: []any{err},
+				Flag: "Storehouse.Get", Name: this.names.GetNameById(id), Id: id, Err: err})
 		}
 	}()
 
@@ -216,11 +435,18 @@ func (this *Storehouse) ResetById(id uint32) float64 {
 		return 0
 	}
 
+	if this.recorder != nil {
+		this.recorder.record(id, recResetId, 0, data.cfg.init)
+	}
+
 	data.Value = data.cfg.init
 	return data.Value
 }
 
 func (this *Storehouse) Reset() {
+	if this.recorder != nil {
+		this.recorder.record(0, recResetAll, 0, 0)
+	}
 	for _, data := range this.datasOfHashId {
 		data.Value = data.cfg.init
 	}
@@ -231,6 +457,9 @@ func (this *Storehouse) ResetByCycle(cycle retsetCycle) {
 	if datas == nil {
 		return
 	}
+	if this.recorder != nil {
+		this.recorder.record(0, recResetCycle, float64(cycle), 0)
+	}
 	for _, data := range datas {
 		data.Value = data.cfg.init
 	}
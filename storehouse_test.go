@@ -0,0 +1,140 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCascadeCycle(t *testing.T) {
+	wallet := testName("测试连锁", "连锁钱包")
+	tax := testName("测试连锁", "连锁税")
+
+	tests := []struct {
+		name        string
+		limit       int
+		deferNested bool
+		wantPath    string
+	}{
+		{"默认深度", 0, false, "连锁钱包 → 连锁税 → 连锁钱包"},
+		{"限定深度", 4, false, "连锁钱包 → 连锁税 → 连锁钱包"},
+		{"推迟嵌套", 4, true, "连锁钱包 → 连锁税 → 连锁钱包"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStorehouse(nil)
+			store.SetCascadeLimit(tt.limit)
+			store.SetDeferNested(tt.deferNested)
+
+			calls := 0
+			store.Lister.AddById(wallet, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+				calls++
+				store.Oper(tax, OS_INC, 1)
+			})
+			store.Lister.AddById(tax, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+				calls++
+				store.Oper(wallet, OS_INC, 1)
+			})
+
+			var cerr *CascadeError
+			store.SetCascadeFunc(func(store *Storehouse, err *CascadeError) {
+				cerr = err
+			})
+			store.Set(wallet, 100)
+
+			if cerr == nil {
+				t.Fatal("没有检测到循环触发")
+			}
+			if cerr.PathName() != tt.wantPath {
+				t.Fatalf("触发路径：%s，应为%s", cerr.PathName(), tt.wantPath)
+			}
+			limit := tt.limit
+			if limit == 0 {
+				limit = defaultCascadeLimit
+			}
+			if (cerr.Limit != limit) || (calls != limit) {
+				t.Fatalf("限定深度%d，回调%d次，应为%d", cerr.Limit, calls, limit)
+			}
+
+			// 超限后仓库恢复正常，可以继续运算
+			store.Lister.Clear()
+			store.Set(wallet, 1)
+			if store.Get(wallet) != 1 {
+				t.Fatalf("超限后运算失败：%v", store.Get(wallet))
+			}
+		})
+	}
+}
+
+func TestCascadeDeferNested(t *testing.T) {
+	a := testName("测试连锁", "推迟甲")
+	b := testName("测试连锁", "推迟乙")
+	c := testName("测试连锁", "推迟丙")
+	store := NewStorehouse(nil)
+	store.SetDeferNested(true)
+
+	var got []string
+	var nestedRet float64
+	store.Lister.AddById(a, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+		got = append(got, "甲")
+		nestedRet = store.Set(b, 5)
+		store.Set(c, 7)
+		got = append(got, "甲结束")
+	})
+	store.Lister.AddById(a, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+		got = append(got, "甲2")
+	})
+	store.Lister.AddById(b, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+		got = append(got, "乙")
+	})
+	store.Lister.AddById(c, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+		got = append(got, "丙")
+	})
+
+	store.Set(b, 1)
+	got = nil
+	store.Set(a, 1)
+	want := []string{"甲", "甲结束", "甲2", "乙", "丙"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%v，应为%v", got, want)
+	}
+	if nestedRet != 1 {
+		t.Fatalf("推迟的运算应返回修改前的值1，当前为：%v", nestedRet)
+	}
+	if (store.Get(b) != 5) || (store.Get(c) != 7) {
+		t.Fatalf("推迟的运算没有执行：%v %v", store.Get(b), store.Get(c))
+	}
+}
+
+func TestCascadeErrorLogged(t *testing.T) {
+	a := testName("测试连锁", "日志甲")
+	store := NewStorehouse(nil)
+	store.SetCascadeLimit(3)
+	store.Lister.AddById(a, func(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+		store.Oper(a, OS_INC, 1)
+	})
+
+	var entries []*LogEntry
+	SetLogger(LogFunc(func(entry *LogEntry) {
+		entries = append(entries, entry)
+	}))
+	defer SetLogger(nil)
+
+	store.Set(a, 1)
+	if (len(entries) != 1) || (entries[0].Code != "cascade_limit") || (entries[0].Id != a) {
+		t.Fatalf("日志：%+v", entries)
+	}
+	if _, ok := entries[0].Err.(*CascadeError); !ok {
+		t.Fatalf("日志应携带*CascadeError：%T", entries[0].Err)
+	}
+}
@@ -0,0 +1,131 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+// 浮点比较容差(Float Comparison Tolerance)
+
+import (
+	"math"
+)
+
+// 绝对及相对误差未设置时的默认上限，可使0.1+0.2=0.3成立
+const DEFAULT_EPSILON = float64(1e-9)
+
+type CompareMode uint
+
+const (
+	// 精确比较
+	CM_EXACT CompareMode = iota
+	// 绝对误差：|a-b| <= Epsilon
+	CM_ABS
+	// 相对误差：|a-b| <= Epsilon*max(|a|,|b|)
+	CM_REL
+	// ULP距离：a与b之间可表示的浮点数个数 <= Ulps
+	CM_ULP
+)
+
+// 比较容差，作用于 =、!=、<=、>=、<、>、in 及 between：
+// 两值在容差内视为相等，<= 与 >= 成立，< 与 > 不成立
+type Tolerance struct {
+	Mode CompareMode `json:"mode"`
+	// 绝对及相对误差的上限，<=0时取DEFAULT_EPSILON
+	Epsilon float64 `json:"epsilon,omitempty"`
+	Ulps    uint64  `json:"ulps,omitempty"`
+}
+
+var tolerance = Tolerance{Mode: CM_EXACT}
+
+// 设置引擎的比较容差，默认为精确比较
+func SetTolerance(value Tolerance) {
+	tolerance = value
+}
+
+func (this *Tolerance) epsilon() float64 {
+	if this.Epsilon <= 0 {
+		return DEFAULT_EPSILON
+	}
+	return this.Epsilon
+}
+
+// 有序整数表示：相邻浮点数的表示相差1
+func orderedBits(value float64) uint64 {
+	bits := math.Float64bits(value)
+	if bits>>63 != 0 {
+		return -bits
+	}
+	return bits | (1 << 63)
+}
+
+func (this *Tolerance) equal(a, b float64) bool {
+	if a == b {
+		return true
+	}
+	switch this.Mode {
+	case CM_ABS:
+		{
+			return math.Abs(a-b) <= this.epsilon()
+		}
+	case CM_REL:
+		{
+			return math.Abs(a-b) <= this.epsilon()*math.Max(math.Abs(a), math.Abs(b))
+		}
+	case CM_ULP:
+		{
+			if math.IsNaN(a) || math.IsNaN(b) {
+				return false
+			}
+			x, y := orderedBits(a), orderedBits(b)
+			if x < y {
+				x, y = y, x
+			}
+			return x-y <= this.Ulps
+		}
+	}
+	return false
+}
+
+func (this *Tolerance) less(a, b float64) bool {
+	return (a < b) && !this.equal(a, b)
+}
+
+func (this *Tolerance) lessOrEqual(a, b float64) bool {
+	return (a < b) || this.equal(a, b)
+}
+
+// 为表达式中的比较单独设置容差（覆盖引擎容差），引用的命名条件不受影响
+func SetCondTolerance(cond CondExp, value Tolerance) {
+	setTolerance(cond, &value)
+}
+
+func SetFrmlTolerance(frml FrmlExp, value Tolerance) {
+	setTolerance(frml, &value)
+}
+
+func setTolerance(exp anyExp, value *Tolerance) {
+	switch v := exp.(type) {
+	case compExp:
+		{
+			v.getCondExp().tol = value
+		}
+	case *inExp:
+		{
+			v.tol = value
+		}
+	case *betweenExp:
+		{
+			v.tol = value
+		}
+	}
+	eachSubExp(exp, func(sub anyExp) {
+		setTolerance(sub, value)
+	})
+}
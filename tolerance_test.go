@@ -0,0 +1,129 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTolerance(t *testing.T) {
+	exact := Tolerance{Mode: CM_EXACT}
+	abs := Tolerance{Mode: CM_ABS}
+	rel := Tolerance{Mode: CM_REL}
+	ulp := Tolerance{Mode: CM_ULP, Ulps: 1}
+	tiny := Tolerance{Mode: CM_ABS, Epsilon: 1e-30}
+
+	tests := []struct {
+		exp  string
+		tol  Tolerance
+		want bool
+	}{
+		{"0.1+0.2=0.3", exact, false},
+		{"0.1+0.2=0.3", abs, true},
+		{"0.1+0.2=0.3", rel, true},
+		{"0.1+0.2=0.3", ulp, true},
+		{"0.1+0.2=0.3", tiny, false},
+		{"0.1+0.2!=0.3", abs, false},
+		{"0.1+0.2<=0.3", exact, false},
+		{"0.1+0.2<=0.3", abs, true},
+		{"0.1+0.2>0.3", exact, true},
+		{"0.1+0.2>0.3", abs, false},
+		{"0.3<0.1+0.2", abs, false},
+		{"0.3>=0.1+0.2", abs, true},
+		{"0.1+0.2=0.31", abs, false},
+		{"0.1+0.2 in (1,0.3)", exact, false},
+		{"0.1+0.2 in (1,0.3)", abs, true},
+		{"0.1+0.2 not in (1,0.3)", abs, false},
+		{"0.3 between 0.1+0.2 and 1", exact, false},
+		{"0.3 between 0.1+0.2 and 1", abs, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			cond, err := ParseCondExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			SetCondTolerance(cond, tt.tol)
+			if got := cond.Check(nil); got != tt.want {
+				t.Fatalf("容差%+v：%v，应为%v", tt.tol, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngineTolerance(t *testing.T) {
+	cond, err := ParseCondExp("0.1+0.2=0.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cond.Check(nil) {
+		t.Fatal("默认应为精确比较")
+	}
+	SetTolerance(Tolerance{Mode: CM_ABS})
+	defer SetTolerance(Tolerance{Mode: CM_EXACT})
+	if !cond.Check(nil) {
+		t.Fatal("引擎容差没有生效")
+	}
+
+	// 表达式单独设置的容差优先
+	SetCondTolerance(cond, Tolerance{Mode: CM_EXACT})
+	if cond.Check(nil) {
+		t.Fatal("表达式容差没有生效")
+	}
+}
+
+// 回差检查同样按比较容差比较：数值回到回差带边界时，容差内视为在边界上
+func TestToleranceHysteresis(t *testing.T) {
+	id := testName("测试容差", "容差回差")
+	exact := Tolerance{Mode: CM_EXACT}
+	abs := Tolerance{Mode: CM_ABS}
+
+	tests := []struct {
+		name string
+		exp  string
+		band float64
+		init float64
+		sets []float64
+		tol  Tolerance
+		want []bool
+	}{
+		{"大于精确", "容差回差>1", 0.9, 0, []float64{2, 0.1}, exact, []bool{true}},
+		{"大于绝对误差", "容差回差>1", 0.9, 0, []float64{2, 0.1}, abs, []bool{true, false}},
+		{"小于精确", "容差回差<0.1", 0.2, 5, []float64{0, 0.3}, exact, []bool{true}},
+		{"小于绝对误差", "容差回差<0.1", 0.2, 5, []float64{0, 0.3}, abs, []bool{true, false}},
+		{"等于精确", "容差回差=0.3", 0.1, 5, []float64{0.3, 0.4}, exact, []bool{true, false}},
+		{"等于绝对误差", "容差回差=0.3", 0.1, 5, []float64{0.3, 0.4}, abs, []bool{true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond, err := ParseCondExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			SetCondTolerance(cond, tt.tol)
+			store := NewStorehouse(nil)
+			store.Set(id, tt.init)
+
+			var got []bool
+			WorkStat.ListenCondOpt(store, cond, CondOpt{Edge: CE_BOTH, Hysteresis: tt.band}, func(store *Storehouse, state bool, ctx any) {
+				got = append(got, state)
+			}, nil)
+			for _, v := range tt.sets {
+				store.Set(id, v)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("%v，应为%v", got, tt.want)
+			}
+		})
+	}
+}
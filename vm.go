@@ -0,0 +1,734 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+// 表达式编译及字节码虚拟机(Expression Compiler and Bytecode VM)
+//
+// 解析后的表达式树可编译为扁平的字节码程序：数据名在首次于某仓库执行时解析为数据槽（值的地址），
+// 之后直接读取，不再经过Storehouse.Get的查找（尚未有值的数据在有值后解析）；
+// 编译后的表达式仍实现原接口，名字表达式等与原表达式一致
+//
+// 性能见vm_test.go中的Benchmark：算术、比较、逻辑、If及常用内置数学函数（Max、Abs等）在栈上直接计算，
+// 快于表达式树；自定义函数、命名条件引用及其它内置函数按原表达式执行，这部分与表达式树相当
+
+import (
+	"math"
+)
+
+type opCode uint8
+
+const (
+	opConst opCode = iota
+	opLoad
+	opAdd
+	opSub
+	opMul
+	opDiv
+	opMod
+	opPow
+	opNeg
+	opBitAnd
+	opBitOr
+	opBitXor
+	opShl
+	opShr
+	opIntDiv
+	opBitNot
+	opCmpG
+	opCmpNG
+	opCmpL
+	opCmpNL
+	opCmpE
+	opCmpNE
+	opIn
+	opNotIn
+	opBetween
+	opNot
+	opTruth
+	// 栈顶为0时跳转（保留栈顶），否则弹出
+	opAndJmp
+	// 栈顶非0时跳转（保留栈顶），否则弹出
+	opOrJmp
+	// 弹出栈顶，为0时跳转
+	opJmpFalse
+	opJmp
+	// 定点运算，按decs[a]的运算符及精度计算栈顶两项
+	opDecimal
+	// 不能编译的节点（自定义函数参数之外的部分、命名条件引用等）按原表达式求值
+	opFrml
+	opCond
+	// 内置纯函数，参数在栈上，按funcs1[a]、funcs2[a]计算
+	opCall1
+	opCall2
+	// 右操作数为常量consts[b]的运算，省去常量入栈；比较的顺序与opCmpG至opCmpNE一致
+	opAddK
+	opSubK
+	opMulK
+	opDivK
+	opCmpGK
+	opCmpNGK
+	opCmpLK
+	opCmpNLK
+	opCmpEK
+	opCmpNEK
+)
+
+// 可改为常量右操作数的运算
+var constOps = map[opCode]opCode{
+	opAdd:   opAddK,
+	opSub:   opSubK,
+	opMul:   opMulK,
+	opDiv:   opDivK,
+	opCmpG:  opCmpGK,
+	opCmpNG: opCmpNGK,
+	opCmpL:  opCmpLK,
+	opCmpNL: opCmpNLK,
+	opCmpE:  opCmpEK,
+	opCmpNE: opCmpNEK,
+}
+
+// 虚拟机在栈上直接计算的内置纯函数，与注册的执行函数结果一致；
+// 内置函数不能重复注册，按名字对应即可
+var vmFuncs1 = map[string]func(float64) float64{
+	"Abs":   math.Abs,
+	"Floor": math.Floor,
+	"Ceil":  math.Ceil,
+	"Trunc": math.Trunc,
+	"Sqrt":  math.Sqrt,
+	"Cbrt":  math.Cbrt,
+	"Sin":   math.Sin,
+	"Cos":   math.Cos,
+	"Tan":   math.Tan,
+	"Log":   math.Log,
+	"Log2":  math.Log2,
+	"Log10": math.Log10,
+	"Exp":   math.Exp,
+}
+
+var vmFuncs2 = map[string]func(float64, float64) float64{
+	"Min":       minValue,
+	"Max":       maxValue,
+	"Mod":       math.Mod,
+	"Pow":       math.Pow,
+	"Hypot":     math.Hypot,
+	"Atan2":     math.Atan2,
+	"Dim":       math.Dim,
+	"Remainder": math.Remainder,
+}
+
+type instr struct {
+	op opCode
+	a  int32
+	b  int32
+}
+
+type program struct {
+	code   []instr
+	consts []float64
+	ids    []uint32
+	tols   []*Tolerance
+	decs   []*frmlExp
+	// 可能发生运算异常的节点，按运算异常策略处理时使用
+	srcs   []anyExp
+	frmls  []FrmlExp
+	conds  []CondExp
+	funcs1 []func(float64) float64
+	funcs2 []func(float64, float64) float64
+	depth  int
+}
+
+// 仓库缓存数据槽表的程序数上限，超过时清空重建，避免重新编译后旧程序的槽表一直占用内存
+const maxProgSlots = 1024
+
+// 取程序在仓库中的数据槽表，首次执行时解析
+func (this *Storehouse) progSlots(prog *program) []*float64 {
+	if (this.lastProg == prog) && (this.slots != nil) {
+		return this.lastSlots
+	}
+	ret, ok := this.slots[prog]
+	if !ok {
+		if (this.slots == nil) || (len(this.slots) >= maxProgSlots) {
+			this.slots = make(map[*program][]*float64)
+		}
+		ret = make([]*float64, len(prog.ids))
+		for i, id := range prog.ids {
+			ret[i] = this.slotOf(id)
+		}
+		this.slots[prog] = ret
+	}
+	this.lastProg, this.lastSlots = prog, ret
+	return ret
+}
+
+// 数据槽：仓库中尚未有值及有getFunc的数据为nil，不创建数据
+func (this *Storehouse) slotOf(id uint32) *float64 {
+	if id < uint32(len(this.datasOfOrderId)) {
+		return &this.datasOfOrderId[id]
+	}
+	data := this.datasOfHashId[id]
+	if (data == nil) || (data.cfg == nil) || (data.cfg.getFunc != nil) {
+		return nil
+	}
+	return &data.Value
+}
+
+// 槽为nil的数据经Get取值，数据已创建时补上数据槽
+func (this *Storehouse) loadSlot(slots []*float64, i int32, id uint32) float64 {
+	if slot := this.slotOf(id); slot != nil {
+		slots[i] = slot
+		return *slot
+	}
+	return this.Get(id)
+}
+
+func (this *program) tolerance(i int32) *Tolerance {
+	if tol := this.tols[i]; tol != nil {
+		return tol
+	}
+	return &tolerance
+}
+
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func (this *program) compare(op opCode, i int32, left, right float64) bool {
+	tol := this.tolerance(i)
+	if tol.Mode == CM_EXACT {
+		switch op {
+		case opCmpG:
+			return left > right
+		case opCmpNG:
+			return left <= right
+		case opCmpL:
+			return left < right
+		case opCmpNL:
+			return left >= right
+		case opCmpE:
+			return left == right
+		default:
+			return left != right
+		}
+	}
+
+	switch op {
+	case opCmpG:
+		return tol.less(right, left)
+	case opCmpNG:
+		return tol.lessOrEqual(left, right)
+	case opCmpL:
+		return tol.less(left, right)
+	case opCmpNL:
+		return tol.lessOrEqual(right, left)
+	case opCmpE:
+		return tol.equal(left, right)
+	default:
+		return !tol.equal(left, right)
+	}
+}
+
+func (this *program) run(store *Storehouse) float64 {
+	var buf [16]float64
+	stack := buf[:]
+	if this.depth > len(buf) {
+		stack = make([]float64, this.depth)
+	}
+	slots := store.progSlots(this)
+
+	sp := 0
+	code := this.code
+	for pc := 0; pc < len(code); pc++ {
+		in := &code[pc]
+		switch in.op {
+		case opConst:
+			stack[sp] = this.consts[in.a]
+			sp++
+		case opLoad:
+			if slot := slots[in.a]; slot != nil {
+				stack[sp] = *slot
+			} else {
+				stack[sp] = store.loadSlot(slots, in.a, this.ids[in.a])
+			}
+			sp++
+		case opAdd:
+			sp--
+			stack[sp-1] += stack[sp]
+		case opSub:
+			sp--
+			stack[sp-1] -= stack[sp]
+		case opMul:
+			sp--
+			stack[sp-1] *= stack[sp]
+		case opDiv:
+			sp--
+			stack[sp-1] = divValue(store, this.srcs[in.a], stack[sp-1], stack[sp])
+		case opMod:
+			sp--
+			stack[sp-1] = modValue(store, this.srcs[in.a], stack[sp-1], stack[sp])
+		case opPow:
+			sp--
+			stack[sp-1] = math.Pow(stack[sp-1], stack[sp])
+		case opNeg:
+			stack[sp-1] = -stack[sp-1]
+		case opBitAnd:
+			sp--
+			stack[sp-1] = intOper(store, this.srcs[in.a], stack[sp-1], stack[sp], andInt)
+		case opBitOr:
+			sp--
+			stack[sp-1] = intOper(store, this.srcs[in.a], stack[sp-1], stack[sp], orInt)
+		case opBitXor:
+			sp--
+			stack[sp-1] = intOper(store, this.srcs[in.a], stack[sp-1], stack[sp], xorInt)
+		case opShl:
+			sp--
+			stack[sp-1] = intOper(store, this.srcs[in.a], stack[sp-1], stack[sp], shlInt)
+		case opShr:
+			sp--
+			stack[sp-1] = intOper(store, this.srcs[in.a], stack[sp-1], stack[sp], shrInt)
+		case opIntDiv:
+			sp--
+			stack[sp-1] = intDivValue(store, this.srcs[in.a], stack[sp-1], stack[sp])
+		case opBitNot:
+			stack[sp-1] = bitNotValue(store, this.srcs[in.a], stack[sp-1])
+		case opCmpG, opCmpNG, opCmpL, opCmpNL, opCmpE, opCmpNE:
+			sp--
+			stack[sp-1] = boolValue(this.compare(in.op, in.a, stack[sp-1], stack[sp]))
+		case opIn, opNotIn:
+			count := int(in.b)
+			sp -= count
+			tol := this.tolerance(in.a)
+			found := false
+			for _, item := range stack[sp : sp+count] {
+				if tol.equal(item, stack[sp-1]) {
+					found = true
+					break
+				}
+			}
+			stack[sp-1] = boolValue(found == (in.op == opIn))
+		case opBetween:
+			sp -= 2
+			tol := this.tolerance(in.a)
+			stack[sp-1] = boolValue(tol.lessOrEqual(stack[sp], stack[sp-1]) && tol.lessOrEqual(stack[sp-1], stack[sp+1]))
+		case opNot:
+			stack[sp-1] = boolValue(stack[sp-1] == 0)
+		case opTruth:
+			stack[sp-1] = boolValue(stack[sp-1] != 0)
+		case opAndJmp:
+			if stack[sp-1] == 0 {
+				pc = int(in.a) - 1
+			} else {
+				sp--
+			}
+		case opOrJmp:
+			if stack[sp-1] != 0 {
+				pc = int(in.a) - 1
+			} else {
+				sp--
+			}
+		case opJmpFalse:
+			sp--
+			if stack[sp] == 0 {
+				pc = int(in.a) - 1
+			}
+		case opJmp:
+			pc = int(in.a) - 1
+		case opDecimal:
+			sp--
+			dec := this.decs[in.a]
+			stack[sp-1] = dec.dec.oper(store, dec, dec.oper, stack[sp-1], stack[sp])
+		case opFrml:
+			stack[sp] = this.frmls[in.a].Float64(store)
+			sp++
+		case opCond:
+			stack[sp] = boolValue(this.conds[in.a].Check(store))
+			sp++
+		case opCall1:
+			stack[sp-1] = this.funcs1[in.a](stack[sp-1])
+		case opCall2:
+			sp--
+			stack[sp-1] = this.funcs2[in.a](stack[sp-1], stack[sp])
+		case opAddK:
+			stack[sp-1] += this.consts[in.b]
+		case opSubK:
+			stack[sp-1] -= this.consts[in.b]
+		case opMulK:
+			stack[sp-1] *= this.consts[in.b]
+		case opDivK:
+			stack[sp-1] = divValue(store, this.srcs[in.a], stack[sp-1], this.consts[in.b])
+		case opCmpGK, opCmpNGK, opCmpLK, opCmpNLK, opCmpEK, opCmpNEK:
+			stack[sp-1] = boolValue(this.compare(in.op-opCmpGK+opCmpG, in.a, stack[sp-1], this.consts[in.b]))
+		}
+	}
+	return stack[0]
+}
+
+type compiler struct {
+	prog       *program
+	depth      int
+	slotOfId   map[uint32]int32
+	constIndex map[uint64]int32
+}
+
+func newCompiler() *compiler {
+	return &compiler{
+		prog:       &program{},
+		slotOfId:   make(map[uint32]int32),
+		constIndex: make(map[uint64]int32),
+	}
+}
+
+// 生成指令，change为指令执行后栈深度的变化
+func (this *compiler) emit(op opCode, a, b int32, change int) int {
+	this.prog.code = append(this.prog.code, instr{op: op, a: a, b: b})
+	this.depth += change
+	if this.depth > this.prog.depth {
+		this.prog.depth = this.depth
+	}
+	return len(this.prog.code) - 1
+}
+
+// 回填跳转地址为下一条指令
+func (this *compiler) patch(pc int) {
+	this.prog.code[pc].a = int32(len(this.prog.code))
+}
+
+func (this *compiler) constant(value float64) {
+	this.emit(opConst, this.constOf(value), 0, 1)
+}
+
+func (this *compiler) constOf(value float64) int32 {
+	bits := math.Float64bits(value)
+	i, ok := this.constIndex[bits]
+	if !ok {
+		i = int32(len(this.prog.consts))
+		this.prog.consts = append(this.prog.consts, value)
+		this.constIndex[bits] = i
+	}
+	return i
+}
+
+func (this *compiler) load(id uint32) {
+	i, ok := this.slotOfId[id]
+	if !ok {
+		i = int32(len(this.prog.ids))
+		this.prog.ids = append(this.prog.ids, id)
+		this.slotOfId[id] = i
+	}
+	this.emit(opLoad, i, 0, 1)
+}
+
+func (this *compiler) tolerance(tol *Tolerance) int32 {
+	this.prog.tols = append(this.prog.tols, tol)
+	return int32(len(this.prog.tols) - 1)
+}
+
+// 二元运算，右操作数为常量时生成带常量的指令
+func (this *compiler) binary(op opCode, left, right FrmlExp) {
+	this.frml(left)
+	if k, ok := constOps[op]; ok {
+		if v, ok := right.(*constExp); ok {
+			this.emit(k, 0, this.constOf(v.value), 0)
+			return
+		}
+	}
+	this.frml(right)
+	this.emit(op, 0, 0, -1)
+}
+
+// 可能发生运算异常的二元运算，a为源节点序号
+func (this *compiler) faultable(op opCode, exp anyExp, left, right FrmlExp) {
+	this.binary(op, left, right)
+	this.prog.code[len(this.prog.code)-1].a = this.source(exp)
+}
+
+func (this *compiler) source(exp anyExp) int32 {
+	this.prog.srcs = append(this.prog.srcs, exp)
+	return int32(len(this.prog.srcs) - 1)
+}
+
+func (this *compiler) decimal(exp *frmlExp) {
+	i := int32(len(this.prog.decs))
+	this.prog.decs = append(this.prog.decs, exp)
+	this.binary(opDecimal, exp.left, exp.right)
+	this.prog.code[len(this.prog.code)-1].a = i
+}
+
+func (this *compiler) frml(exp FrmlExp) {
+	if v, ok := exp.(interface{ getFrmlExp() *frmlExp }); ok && (v.getFrmlExp().dec != nil) {
+		this.decimal(v.getFrmlExp())
+		return
+	}
+
+	switch v := exp.(type) {
+	case *constExp:
+		this.constant(v.value)
+	case *idenExp:
+		this.load(v.id)
+	case *incExp:
+		this.binary(opAdd, v.left, v.right)
+	case *decExp:
+		this.binary(opSub, v.left, v.right)
+	case *mulExp:
+		this.binary(opMul, v.left, v.right)
+	case *divExp:
+		this.faultable(opDiv, v, v.left, v.right)
+	case *modExp:
+		this.faultable(opMod, v, v.left, v.right)
+	case *powExp:
+		this.binary(opPow, v.left, v.right)
+	case *bitAndExp:
+		this.faultable(opBitAnd, v, v.left, v.right)
+	case *bitOrExp:
+		this.faultable(opBitOr, v, v.left, v.right)
+	case *bitXorExp:
+		this.faultable(opBitXor, v, v.left, v.right)
+	case *shlExp:
+		this.faultable(opShl, v, v.left, v.right)
+	case *shrExp:
+		this.faultable(opShr, v, v.left, v.right)
+	case *intDivExp:
+		this.faultable(opIntDiv, v, v.left, v.right)
+	case *negExp:
+		this.frml(v.value)
+		this.emit(opNeg, 0, 0, 0)
+	case *bitNotExp:
+		this.frml(v.value)
+		this.emit(opBitNot, this.source(v), 0, 0)
+	case *boolExp:
+		this.cond(v.cond)
+	case *ifFuncExp:
+		this.cond(v.cond)
+		jmpFalse := this.emit(opJmpFalse, 0, 0, -1)
+		this.frml(v.params[0])
+		jmp := this.emit(opJmp, 0, 0, -1)
+		this.patch(jmpFalse)
+		this.frml(v.params[1])
+		this.patch(jmp)
+	case *funcExp:
+		if this.call(v) {
+			return
+		}
+		// 自定义函数按原函数执行，参数编译后传入
+		fn := &funcExp{name: v.name, exec: v.exec, params: make([]FrmlExp, len(v.params))}
+		for i, param := range v.params {
+			fn.params[i] = CompileFrml(param)
+		}
+		this.prog.frmls = append(this.prog.frmls, fn)
+		this.emit(opFrml, int32(len(this.prog.frmls)-1), 0, 1)
+	default:
+		this.prog.frmls = append(this.prog.frmls, exp)
+		this.emit(opFrml, int32(len(this.prog.frmls)-1), 0, 1)
+	}
+}
+
+// 内置纯函数的参数编译到栈上，直接计算
+func (this *compiler) call(exp *funcExp) bool {
+	if fn, ok := vmFuncs1[exp.name]; ok && (len(exp.params) == 1) {
+		this.frml(exp.params[0])
+		this.prog.funcs1 = append(this.prog.funcs1, fn)
+		this.emit(opCall1, int32(len(this.prog.funcs1)-1), 0, 0)
+		return true
+	}
+	if fn, ok := vmFuncs2[exp.name]; ok && (len(exp.params) == 2) {
+		this.frml(exp.params[0])
+		this.frml(exp.params[1])
+		this.prog.funcs2 = append(this.prog.funcs2, fn)
+		this.emit(opCall2, int32(len(this.prog.funcs2)-1), 0, -1)
+		return true
+	}
+	return false
+}
+
+// 编译条件，结果以1、0入栈
+func (this *compiler) cond(exp CondExp) {
+	switch v := exp.(type) {
+	case *compExpG:
+		this.binary(opCmpG, v.left, v.right)
+		this.prog.code[len(this.prog.code)-1].a = this.tolerance(v.tol)
+	case *compExpNG:
+		this.binary(opCmpNG, v.left, v.right)
+		this.prog.code[len(this.prog.code)-1].a = this.tolerance(v.tol)
+	case *compExpL:
+		this.binary(opCmpL, v.left, v.right)
+		this.prog.code[len(this.prog.code)-1].a = this.tolerance(v.tol)
+	case *compExpNL:
+		this.binary(opCmpNL, v.left, v.right)
+		this.prog.code[len(this.prog.code)-1].a = this.tolerance(v.tol)
+	case *compExpE:
+		this.binary(opCmpE, v.left, v.right)
+		this.prog.code[len(this.prog.code)-1].a = this.tolerance(v.tol)
+	case *compExpNE:
+		this.binary(opCmpNE, v.left, v.right)
+		this.prog.code[len(this.prog.code)-1].a = this.tolerance(v.tol)
+	case *logicExpAnd:
+		this.cond(v.left)
+		jmp := this.emit(opAndJmp, 0, 0, -1)
+		this.cond(v.right)
+		this.patch(jmp)
+	case *logicExpOr:
+		this.cond(v.left)
+		jmp := this.emit(opOrJmp, 0, 0, -1)
+		this.cond(v.right)
+		this.patch(jmp)
+	case *notExp:
+		// !(a=b)、!(a<>b)直接编译为相反的比较
+		switch c := v.cond.(type) {
+		case *compExpE:
+			this.binary(opCmpNE, c.left, c.right)
+			this.prog.code[len(this.prog.code)-1].a = this.tolerance(c.tol)
+		case *compExpNE:
+			this.binary(opCmpE, c.left, c.right)
+			this.prog.code[len(this.prog.code)-1].a = this.tolerance(c.tol)
+		default:
+			this.cond(v.cond)
+			this.emit(opNot, 0, 0, 0)
+		}
+	case *truthExp:
+		this.frml(v.frml)
+		this.emit(opTruth, 0, 0, 0)
+	case *inExp:
+		this.frml(v.left)
+		for _, item := range v.items {
+			this.frml(item)
+		}
+		op := opIn
+		if v.not {
+			op = opNotIn
+		}
+		this.emit(op, this.tolerance(v.tol), int32(len(v.items)), -len(v.items))
+	case *betweenExp:
+		this.frml(v.left)
+		this.frml(v.low)
+		this.frml(v.high)
+		this.emit(opBetween, this.tolerance(v.tol), 0, -2)
+	default:
+		this.prog.conds = append(this.prog.conds, exp)
+		this.emit(opCond, int32(len(this.prog.conds)-1), 0, 1)
+	}
+}
+
+// 编译后的公式
+type compiledFrml struct {
+	src  FrmlExp
+	prog *program
+}
+
+func (this *compiledFrml) NameExp() string {
+	return this.src.NameExp()
+}
+
+func (this *compiledFrml) ValueExp(store *Storehouse) string {
+	return this.src.ValueExp(store)
+}
+
+func (this *compiledFrml) EachId(fn func(id uint32)) {
+	this.src.EachId(fn)
+}
+
+func (this *compiledFrml) Float64(store *Storehouse) float64 {
+	return this.prog.run(store)
+}
+
+// 编译后的条件
+type compiledCond struct {
+	src  CondExp
+	prog *program
+}
+
+func (this *compiledCond) NameExp() string {
+	return this.src.NameExp()
+}
+
+func (this *compiledCond) ValueExp(store *Storehouse) string {
+	return this.src.ValueExp(store)
+}
+
+func (this *compiledCond) EachId(fn func(id uint32)) {
+	this.src.EachId(fn)
+}
+
+func (this *compiledCond) Check(store *Storehouse) bool {
+	return this.prog.run(store) != 0
+}
+
+func (this *compiledCond) ExplainCheck(store *Storehouse) *CondTrace {
+	return explainCond(this.src, store)
+}
+
+func (this *compiledCond) checkBand(store *Storehouse, band float64) bool {
+	return checkBand(this.src, store, band)
+}
+
+// 编译公式，已编译的直接返回；
+// 自定义函数、命名条件引用等不能编译的部分按原表达式执行，主要由这些构成的公式编译后没有提速
+func CompileFrml(exp FrmlExp) FrmlExp {
+	if (exp == nil) || isCompiled(exp) {
+		return exp
+	}
+	c := newCompiler()
+	c.frml(exp)
+	return &compiledFrml{src: exp, prog: c.prog}
+}
+
+// 编译条件，已编译的直接返回；不能编译的部分同CompileFrml
+func CompileCond(exp CondExp) CondExp {
+	if (exp == nil) || isCompiled(exp) {
+		return exp
+	}
+	c := newCompiler()
+	c.cond(exp)
+	return &compiledCond{src: exp, prog: c.prog}
+}
+
+func isCompiled(exp anyExp) bool {
+	switch exp.(type) {
+	case *compiledFrml, *compiledCond:
+		return true
+	}
+	return false
+}
+
+func compileOpers(opers []OperExp) {
+	for _, oper := range opers {
+		switch v := oper.(type) {
+		case interface{ getOperExp() *operExp }:
+			{
+				base := v.getOperExp()
+				base.value = CompileFrml(base.value)
+			}
+		case *returnExp:
+			{
+				v.value = CompileFrml(v.value)
+			}
+		case *ifReturnExp:
+			{
+				v.cond = CompileCond(v.cond)
+				v.value = CompileFrml(v.value)
+			}
+		}
+	}
+}
+
+// 编译运算集合中的值表达式（原地替换），返回原运算集合
+func CompileOper(exp OperSet) OperSet {
+	compileOpers(exp.Opers())
+	return exp
+}
+
+// 编译流程中的值表达式及条件（原地替换），返回原流程
+func CompileProc(exp ProcExp) ProcExp {
+	compileOpers(exp.Steps())
+	return exp
+}
@@ -0,0 +1,236 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"math"
+	"testing"
+)
+
+var vmFrmls = []string{
+	"基础*(1+(暴击>0)*0.5)+等级*2-防御/3",
+	"If(等级>=20, 基础*攻速, 基础)+Max(防御, 等级)%7",
+	"(基础+等级*等级-防御)*攻速^2",
+	"-基础^2+Div(等级, 4)*(职业&3)+(1<<职业)~5",
+	"基础/(暴击-1)+防御%(暴击-1)",
+	"Decimal(攻速*3, 2)+未赋值*2+取值函数",
+	"@测试可暴击*基础+(等级 between 20 and 30)",
+	"Abs(防御-等级*5)+Floor(攻速*3)/Sqrt(基础)-Trunc(-攻速)",
+	"Min(基础, 防御*2)-Pow(攻速, 2)+Hypot(等级, 3)*Max(职业, 暴击)",
+	"基础/4-等级*0.5+(防御-10)/0",
+}
+
+var vmConds = []string{
+	"等级>=10 && (职业 in (1,3,7) || 等级 between 20 and 30) && 防御<100",
+	"!(暴击=0) || 基础*攻速>200",
+	"职业 not in (2,4) && !等级<20",
+	"未赋值=0 && 取值函数>10",
+	"@测试可暴击 || 基础",
+	"基础*攻速 <> 180 || 防御 == 45",
+	"!(等级<>25) || !(职业=2) && 基础>=100",
+	"Max(等级, 防御)>50 && 攻速*2<=3",
+}
+
+// 测试编译所用的数据，type为各仓库的取值组合
+func testVMStore(t testing.TB, kind int) *Storehouse {
+	for _, name := range []string{"基础", "暴击", "等级", "防御", "职业", "攻速", "未赋值"} {
+		testName("测试编译", name)
+	}
+	if Names.GetIdByName("取值函数") == 0 {
+		Names.RegisterName("测试编译取值", "取值函数", 0)
+		Names.RegisterGetFuncByName("取值函数", func(store *Storehouse, id uint32) float64 {
+			return store.GetByName("等级") + 1
+		})
+	}
+	if _, ok := condOfName["测试可暴击"]; !ok {
+		if err := RegisterCond("测试可暴击", "暴击>0 && 等级>=10"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	values := [][]float64{
+		{120, 1, 25, 45, 3, 1.5},
+		{80, 0, 5, 150, 2, 2},
+		{200, 3, 30, 99, 7, 0.5},
+	}[kind]
+	store := NewStorehouse(nil)
+	for i, name := range []string{"基础", "暴击", "等级", "防御", "职业", "攻速"} {
+		store.Set(Names.GetIdByName(name), values[i])
+	}
+	return store
+}
+
+// 虚拟机直接计算的内置函数与注册的执行函数结果一致
+func TestCompileFuncs(t *testing.T) {
+	values := []float64{0, -0.5, 2.5, -7, 1e300, math.Inf(1), math.Inf(-1), math.NaN()}
+	same := func(a, b float64) bool {
+		return (a == b) || (math.IsNaN(a) && math.IsNaN(b))
+	}
+	exec := func(name string, args ...float64) float64 {
+		params := make([]FrmlExp, len(args))
+		for i, arg := range args {
+			params[i] = &constExp{value: arg}
+		}
+		return funcParserOfName[name].(*funcParser).exec(nil, params)
+	}
+	for name, fn := range vmFuncs1 {
+		for _, v := range values {
+			if got, want := fn(v), exec(name, v); !same(got, want) {
+				t.Errorf("%s(%v)：%v，应为%v", name, v, got, want)
+			}
+		}
+	}
+	for name, fn := range vmFuncs2 {
+		for _, v1 := range values {
+			for _, v2 := range values {
+				if got, want := fn(v1, v2), exec(name, v1, v2); !same(got, want) {
+					t.Errorf("%s(%v, %v)：%v，应为%v", name, v1, v2, got, want)
+				}
+			}
+		}
+	}
+}
+
+// 编译前后结果一致，同一程序在多个仓库中执行互不影响
+func TestCompileAgree(t *testing.T) {
+	stores := []*Storehouse{testVMStore(t, 0), testVMStore(t, 1), testVMStore(t, 2)}
+
+	for _, str := range vmFrmls {
+		t.Run(str, func(t *testing.T) {
+			frml, err := ParseFrmlExp(str)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compiled := CompileFrml(frml)
+			if (compiled.NameExp() != frml.NameExp()) || (CompileFrml(compiled) != compiled) {
+				t.Fatal("编译后的表达式应与原表达式一致")
+			}
+			for round := 0; round < 2; round++ {
+				for i, store := range stores {
+					if got, want := compiled.Float64(store), frml.Float64(store); got != want {
+						t.Fatalf("仓库%d：%v，应为%v", i, got, want)
+					}
+				}
+			}
+		})
+	}
+	for _, str := range vmConds {
+		t.Run(str, func(t *testing.T) {
+			cond, err := ParseCondExp(str)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compiled := CompileCond(cond)
+			for round := 0; round < 2; round++ {
+				for i, store := range stores {
+					if got, want := compiled.Check(store), cond.Check(store); got != want {
+						t.Fatalf("仓库%d：%v，应为%v", i, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// 执行编译的表达式不创建数据，数据有值后读取新值
+func TestCompileNoSideEffect(t *testing.T) {
+	store := testVMStore(t, 0)
+	id := Names.GetIdByName("未赋值")
+	frml, err := ParseFrmlExp("未赋值+1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled := CompileFrml(frml)
+
+	count := len(store.datasOfHashId)
+	if got := compiled.Float64(store); got != 1 {
+		t.Fatalf("%v，应为1", got)
+	}
+	if len(store.datasOfHashId) != count {
+		t.Fatal("执行编译的表达式不应创建数据")
+	}
+	if len(store.Snapshot().Datas) != len(testVMStore(t, 0).Snapshot().Datas) {
+		t.Fatal("快照中不应出现未赋值的数据")
+	}
+
+	store.Set(id, 5)
+	if got := compiled.Float64(store); got != 6 {
+		t.Fatalf("赋值后：%v，应为6", got)
+	}
+	if err := store.Restore(testVMStore(t, 1).Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	if got := compiled.Float64(store); got != 1 {
+		t.Fatalf("恢复快照后：%v，应为1", got)
+	}
+}
+
+// 仓库缓存的槽表数量有上限，重新编译不会使槽表一直增长
+func TestCompileSlotsBounded(t *testing.T) {
+	store := testVMStore(t, 0)
+	frml, err := ParseFrmlExp("未赋值+基础")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxProgSlots*2; i++ {
+		CompileFrml(frml).Float64(store)
+	}
+	if len(store.slots) > maxProgSlots {
+		t.Fatalf("槽表%d个，超过上限%d", len(store.slots), maxProgSlots)
+	}
+}
+
+var vmSink float64
+
+func benchFrml(b *testing.B, compile bool) {
+	store := testVMStore(b, 0)
+	for _, str := range vmFrmls[:3] {
+		frml, err := ParseFrmlExp(str)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if compile {
+			frml = CompileFrml(frml)
+		}
+		b.Run(str, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				vmSink += frml.Float64(store)
+			}
+		})
+	}
+}
+
+func benchCond(b *testing.B, compile bool) {
+	store := testVMStore(b, 0)
+	for _, str := range vmConds[:2] {
+		cond, err := ParseCondExp(str)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if compile {
+			cond = CompileCond(cond)
+		}
+		b.Run(str, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if cond.Check(store) {
+					vmSink++
+				}
+			}
+		})
+	}
+}
+
+// 对比：go test -run=^$ -bench=Frml
+func BenchmarkFrmlTree(b *testing.B) { benchFrml(b, false) }
+func BenchmarkFrmlVM(b *testing.B)   { benchFrml(b, true) }
+func BenchmarkCondTree(b *testing.B) { benchCond(b, false) }
+func BenchmarkCondVM(b *testing.B)   { benchCond(b, true) }
@@ -13,23 +13,212 @@ package de
 
 // 数据工作站(Data Workstation)
 
-type ListenFuncByCond = func(store *Storehouse, extParam uintptr)
+import (
+	"time"
+)
 
-type condLister struct {
-	fnOfCond ListenFuncByCond
-	fnsOfId  map[uint32]*ListenFuncById
-	cond     CondExp
-	extParam uintptr
+// 条件监听回调，ctx为监听时传入的上下文
+type ListenFuncByCond = func(store *Storehouse, ctx any)
+
+// 带条件状态的条件监听回调，state为条件检查后的新状态
+type ListenFuncByCondState = func(store *Storehouse, state bool, ctx any)
+
+// 条件监听触发方式
+type CondEdge uint
+
+const (
+	// 条件成立时，所引用数据每次变化都回调
+	CE_LEVEL CondEdge = iota
+	// 条件由不成立变为成立时回调
+	CE_RISING
+	// 条件由成立变为不成立时回调
+	CE_FALLING
+	// 条件状态变化时回调
+	CE_BOTH
+)
+
+// 条件监听选项
+type CondOpt struct {
+	// Priority用于条件所引用数据的监听，防抖、节流及批次合并作用于条件检查
+	ListenOpt
+	Edge CondEdge
+	// 回差：条件成立后，比较需向不成立方向越过此带宽才视为不成立，避免数值在临界值附近波动时反复回调；
+	// 不等于（<>）只在相等时不成立，回差对其不起作用，!(a<>b)与a=b相同
+	Hysteresis float64
+	// 保持时长：条件持续成立该时长（按选项时钟）后才视为成立，期间条件不成立则重新计时；
+	// 计时中及已成立的条件会保存到仓库快照中，恢复后以Name（未设置时为条件名字表达式）对应继续计时，
+	// 已成立的恢复为成立且不再回调；同一条件有多个保持监听时应设置不同的Name；
+	// 使用系统时钟时，到时回调由仓库拥有者调用Storehouse.Pump执行
+	Hold time.Duration
+}
+
+// 条件监听句柄，同一条件可以有多个监听
+type CondListener struct {
+	workstat  *Workstat
+	fnOfCond  ListenFuncByCondState
+	fnsOfId   map[uint32]*Listener
+	cond      CondExp
+	opt       CondOpt
+	store     *Storehouse
+	raw       bool
+	state     bool
+	since     time.Time
+	holdTimer Timer
+	ctx       any
+	limit     *listenLimit
+	canceled  bool
+}
+
+func (this *CondListener) Cond() CondExp {
+	return this.cond
+}
+
+// 当前条件状态（边沿及保持方式下为最近一次检查后的状态）
+func (this *CondListener) State() bool {
+	return this.state
+}
+
+func (this *CondListener) Cancel() {
+	if this.canceled {
+		return
+	}
+	this.canceled = true
+
+	for id, fn := range this.fnsOfId {
+		this.store.Lister.DelById(id, fn)
+	}
+	this.stopHold()
+	if this.limit != nil {
+		this.limit.stop()
+	}
+
+	wLog := this.store.workstatLog[this.workstat]
+	if wLog == nil {
+		return
+	}
+	listers := wLog.listerOfCond[this.cond]
+	for i, lister := range listers {
+		if lister == this {
+			listers = append(listers[:i:i], listers[i+1:]...)
+			break
+		}
+	}
+	if len(listers) == 0 {
+		delete(wLog.listerOfCond, this.cond)
+	} else {
+		wLog.listerOfCond[this.cond] = listers
+	}
+}
+
+// 快照中保持计时的标识
+func (this *CondListener) holdKey() string {
+	if this.opt.Name != "" {
+		return this.opt.Name
+	}
+	return this.cond.NameExp()
+}
+
+// 已保持的时长（已成立的包括成立后的时长），未计时且未成立时返回-1
+func (this *CondListener) held() time.Duration {
+	if (this.opt.Hold <= 0) || ((this.holdTimer == nil) && !this.state) {
+		return -1
+	}
+	return this.opt.clock().Now().Sub(this.since)
+}
+
+func (this *CondListener) startHold(elapsed time.Duration) {
+	clk := this.opt.clock()
+	this.since = clk.Now().Add(-elapsed)
+	remain := this.opt.Hold - elapsed
+	if remain <= 0 {
+		// 从快照恢复的已成立条件，快照前已经回调过，不再重复回调
+		this.state = true
+		return
+	}
+
+	// 定时回调在仓库拥有者协程中执行（系统时钟由Pump执行）
+	var timer Timer
+	timer = this.store.afterFunc(clk, remain, func() {
+		if this.canceled || (this.holdTimer != timer) {
+			return
+		}
+		this.holdTimer = nil
+		this.update(true)
+	})
+	this.holdTimer = timer
 }
 
-func (this *condLister) onDataChg(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
-	if this.cond.Check(store) {
+func (this *CondListener) stopHold() {
+	if this.holdTimer != nil {
+		this.holdTimer.Stop()
+		this.holdTimer = nil
+	}
+}
+
+func (this *CondListener) onDataChg(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+	if this.limit != nil {
+		// 条件的防抖、节流及合并以仓库为单位，不区分引起变化的数据ID
+		this.limit.onChg(store, 0, operSymbol, value)
+		return
+	}
+	this.check(store)
+}
+
+func (this *CondListener) onLimitOut(store *Storehouse, id uint32, operSymbol OperSymbol, value float64) {
+	this.check(store)
+}
+
+func (this *CondListener) check(store *Storehouse) {
+	if this.canceled {
+		return
+	}
+
+	if this.raw && (this.opt.Hysteresis > 0) {
+		this.raw = checkBand(this.cond, store, this.opt.Hysteresis)
+	} else {
+		this.raw = this.cond.Check(store)
+	}
+
+	if this.opt.Hold > 0 {
+		if !this.raw {
+			this.stopHold()
+			this.update(false)
+		} else if this.state {
+			this.update(true)
+		} else if this.holdTimer == nil {
+			this.startHold(0)
+		}
+		return
+	}
+
+	this.update(this.raw)
+}
+
+func (this *CondListener) update(state bool) {
+	old := this.state
+	this.state = state
+
+	fire := false
+	switch this.opt.Edge {
+	case CE_LEVEL:
+		fire = this.state
+	case CE_RISING:
+		fire = this.state && !old
+	case CE_FALLING:
+		fire = !this.state && old
+	case CE_BOTH:
+		fire = this.state != old
+	}
+
+	if fire {
 		defer func() {
 			if err := recover(); err != nil {
-				writeLog("[dmp]condLister.onDataChg => 条件监听回调异常：%v，条件：%s", err, this.cond.NameExp())
+				rethrowArith(err)
+				writeLog(&LogEntry{Level: LL_ERROR, Code: "cond_callback_failed", Args: []any{err, this.cond.NameExp()},
+					Flag: "CondListener.onDataChg", Exp: this.cond.NameExp(), Err: err})
 			}
 		}()
-		this.fnOfCond(store, uintptr(this.extParam))
+		this.fnOfCond(this.store, this.state, this.ctx)
 	}
 }
 
@@ -37,7 +226,7 @@ type workstatLog struct {
 	produceIdSaveFlagOfOperSet map[OperSet]bool
 	produceIdSaveFlagOfProcExp map[ProcExp]bool
 	idSaveFlagOfProduceId      map[uint32]bool
-	listerOfCond               map[CondExp]*condLister
+	listerOfCond               map[CondExp][]*CondListener
 }
 
 func newWorkstatLog() *workstatLog {
@@ -45,7 +234,7 @@ func newWorkstatLog() *workstatLog {
 		produceIdSaveFlagOfOperSet: make(map[OperSet]bool),
 		produceIdSaveFlagOfProcExp: make(map[ProcExp]bool),
 		idSaveFlagOfProduceId:      make(map[uint32]bool),
-		listerOfCond:               make(map[CondExp]*condLister),
+		listerOfCond:               make(map[CondExp][]*CondListener),
 	}
 }
 
@@ -76,46 +265,66 @@ func (this *Workstat) myLog(store *Storehouse) *workstatLog {
 	return ret
 }
 
+// 取消该条件的所有监听
 func (this *Workstat) CancelCondListen(store *Storehouse, cond CondExp) {
 	wLog := this.myLog(store)
-	lister := wLog.listerOfCond[cond]
-	if lister == nil {
-		return
+	for _, lister := range wLog.listerOfCond[cond] {
+		lister.Cancel()
 	}
-	for id, fn := range lister.fnsOfId {
-		store.Lister.DelById(id, fn)
+}
+
+func (this *Workstat) ListenCond(store *Storehouse, cond CondExp, fn ListenFuncByCond, ctx any) *CondListener {
+	onCond := func(store *Storehouse, state bool, ctx any) {
+		fn(store, ctx)
 	}
-	delete(wLog.listerOfCond, cond)
+	return this.ListenCondOpt(store, cond, CondOpt{}, onCond, ctx)
 }
 
-func (this *Workstat) ListenCond(store *Storehouse, cond CondExp, fn ListenFuncByCond, extParam uintptr) {
+// 按选项监听条件，边沿触发方式的初始状态取监听时的条件状态
+func (this *Workstat) ListenCondOpt(store *Storehouse, cond CondExp, opt CondOpt, fn ListenFuncByCondState, ctx any) *CondListener {
 	ids := make(map[uint32]uint32)
 	cond.EachId(func(id uint32) {
 		ids[id] = id
 	})
 	len := len(ids)
 	if len == 0 {
-		writeLog("[dmp]Workstat.ListenCond => 监听的条件无实际数据名：" + cond.NameExp())
+		writeLog(&LogEntry{Level: LL_WARN, Code: "cond_without_names", Args: []any{cond.NameExp()}, Flag: "Workstat.ListenCond", Exp: cond.NameExp()})
 	}
 
-	wLog := this.myLog(store)
-	lister := wLog.listerOfCond[cond]
-
-	if lister == nil {
-		lister := &condLister{
-			fnOfCond: fn,
-			cond:     cond,
-			fnsOfId:  make(map[uint32]*ListenFuncById),
-			extParam: extParam,
-		}
-		wLog.listerOfCond[cond] = lister
-		for _, id := range ids {
-			lister.fnsOfId[id] = store.Lister.AddById(id, lister.onDataChg)
+	lister := &CondListener{
+		workstat: this,
+		fnOfCond: fn,
+		cond:     cond,
+		fnsOfId:  make(map[uint32]*Listener),
+		opt:      opt,
+		store:    store,
+		ctx:      ctx,
+	}
+	lister.raw = cond.Check(store)
+	if opt.Hold > 0 {
+		if lister.raw {
+			lister.startHold(store.takeRestoredHold(lister.holdKey()))
 		}
+	} else if opt.Edge != CE_LEVEL {
+		lister.state = lister.raw
+	}
+	if opt.limited() {
+		lister.limit = newListenLimit(opt.ListenOpt, lister.onLimitOut)
 	}
+
+	wLog := this.myLog(store)
+	wLog.listerOfCond[cond] = append(wLog.listerOfCond[cond], lister)
+	for _, id := range ids {
+		lister.fnsOfId[id] = store.Lister.AddByIdOpt(id, ListenOpt{Priority: opt.Priority}, lister.onDataChg)
+	}
+
+	return lister
 }
 
 func (this *Workstat) ExecOper(store *Storehouse, exp OperSet, recordProduce bool) {
+	store.beginBatch()
+	defer store.endBatch()
+
 	opers := exp.Opers()
 
 	if recordProduce {
@@ -137,6 +346,9 @@ func (this *Workstat) ExecOper(store *Storehouse, exp OperSet, recordProduce boo
 }
 
 func (this *Workstat) ExecProc(store *Storehouse, exp ProcExp, recordProduce bool) float64 {
+	store.beginBatch()
+	defer store.endBatch()
+
 	exp.LoadFrom(store)
 
 	steps := exp.Steps()
@@ -154,10 +366,11 @@ func (this *Workstat) ExecProc(store *Storehouse, exp ProcExp, recordProduce boo
 	}
 
 	procStore := exp.MyStore()
+	defer store.mergeFaults(procStore)
 	for i, step := range steps {
 		if outStepLog {
-			writeLog("[Step%d].名字表达式:%s", i+1, step.NameExp())
-			writeLog("[Step%d].数值表达式:%s", i+1, step.ValueExp(procStore))
+			writeLog(&LogEntry{Level: LL_DEBUG, Code: "step_name_exp", Args: []any{i + 1, step.NameExp()}, Exp: step.NameExp()})
+			writeLog(&LogEntry{Level: LL_DEBUG, Code: "step_value_exp", Args: []any{i + 1, step.ValueExp(procStore)}, Exp: step.NameExp()})
 		}
 		if step.Exec(procStore) {
 			break
@@ -0,0 +1,333 @@
+package de
+
+/*******************************************************************************
+
+//     Data Engine 数据引擎 (data-e)
+
+//        Author: Yigui Lu (卢益贵)
+// Contact WX/QQ: 48092788
+//          Blog: https://blog.csdn.net/guestcode
+//   Creation by: 2018-2020
+
+*******************************************************************************/
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCondListenEdge(t *testing.T) {
+	id := testName("测试条件监听", "边沿数据")
+
+	tests := []struct {
+		name string
+		exp  string
+		opt  CondOpt
+		sets []float64
+		want []bool
+	}{
+		{"电平", "边沿数据>10", CondOpt{Edge: CE_LEVEL}, []float64{5, 20, 30, 5, 20}, []bool{true, true, true}},
+		{"上升沿", "边沿数据>10", CondOpt{Edge: CE_RISING}, []float64{5, 20, 30, 5, 20}, []bool{true, true}},
+		{"下降沿", "边沿数据>10", CondOpt{Edge: CE_FALLING}, []float64{5, 20, 30, 5, 20}, []bool{false}},
+		{"双边沿", "边沿数据>10", CondOpt{Edge: CE_BOTH}, []float64{5, 20, 30, 5, 20}, []bool{true, false, true}},
+		{"大于回差", "边沿数据>10", CondOpt{Edge: CE_BOTH, Hysteresis: 5},
+			[]float64{11, 9, 6, 5, 8, 11}, []bool{true, false, true}},
+		{"小于回差", "边沿数据<10", CondOpt{Edge: CE_BOTH, Hysteresis: 2},
+			[]float64{11, 12, 11, 9, 11}, []bool{false, true}},
+		{"取反回差", "!(边沿数据>10)", CondOpt{Edge: CE_BOTH, Hysteresis: 2},
+			[]float64{11, 13, 11, 9, 12}, []bool{false, true}},
+		{"等于回差", "边沿数据=10", CondOpt{Edge: CE_BOTH, Hysteresis: 1},
+			[]float64{10, 11, 9, 12, 10}, []bool{true, false, true}},
+		{"不等回差", "边沿数据<>10", CondOpt{Edge: CE_BOTH, Hysteresis: 1},
+			[]float64{10, 11, 10, 10.5}, []bool{false, true, false, true}},
+		{"取反不等回差", "!(边沿数据<>10)", CondOpt{Edge: CE_BOTH, Hysteresis: 1},
+			[]float64{10, 11, 10, 12, 10}, []bool{true, false, true}},
+		{"区间回差", "边沿数据 between 10 and 20", CondOpt{Edge: CE_BOTH, Hysteresis: 2},
+			[]float64{15, 21, 22, 23, 8, 15}, []bool{true, false, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond, err := ParseCondExp(tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store := NewStorehouse(nil)
+			store.Set(id, 0)
+
+			var got []bool
+			l := WorkStat.ListenCondOpt(store, cond, tt.opt, func(store *Storehouse, state bool, ctx any) {
+				got = append(got, state)
+			}, nil)
+			for _, v := range tt.sets {
+				store.Set(id, v)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("%v，应为%v", got, tt.want)
+			}
+
+			l.Cancel()
+			store.Set(id, 0)
+			store.Set(id, 100)
+			if len(got) != len(tt.want) {
+				t.Fatalf("取消后仍然回调：%v", got)
+			}
+		})
+	}
+}
+
+// 边沿方式的初始状态取监听时的条件状态
+func TestCondListenInitState(t *testing.T) {
+	id := testName("测试条件监听", "初始状态")
+	cond, err := ParseCondExp("初始状态>10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStorehouse(nil)
+	store.Set(id, 20)
+
+	var got []bool
+	l := WorkStat.ListenCondOpt(store, cond, CondOpt{Edge: CE_RISING}, func(store *Storehouse, state bool, ctx any) {
+		got = append(got, state)
+	}, nil)
+	if !l.State() {
+		t.Fatal("初始状态应为成立")
+	}
+	store.Set(id, 30)
+	if len(got) != 0 {
+		t.Fatalf("已成立的条件不应再触发上升沿：%v", got)
+	}
+
+	// ListenCond为电平方式，与原有行为一致
+	count := 0
+	ctx := "上下文"
+	WorkStat.ListenCond(store, cond, func(store *Storehouse, c any) {
+		if c != ctx {
+			t.Fatalf("上下文：%v", c)
+		}
+		count++
+	}, ctx)
+	store.Set(id, 40)
+	store.Set(id, 50)
+	if count != 2 {
+		t.Fatalf("回调%d次，应为2次", count)
+	}
+	WorkStat.CancelCondListen(store, cond)
+	store.Set(id, 60)
+	if count != 2 {
+		t.Fatalf("取消后仍然回调，共%d次", count)
+	}
+}
+
+func TestCondListenHold(t *testing.T) {
+	id := testName("测试条件监听", "保持数据")
+	cond, err := ParseCondExp("保持数据>10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type step struct {
+		set     float64
+		advance time.Duration
+	}
+	tests := []struct {
+		name  string
+		edge  CondEdge
+		steps []step
+		want  []bool
+	}{
+		{"保持后成立", CE_RISING, []step{{20, 4 * time.Second}, {-1, time.Second}}, []bool{true}},
+		{"中途不成立重新计时", CE_RISING,
+			[]step{{20, 3 * time.Second}, {5, 3 * time.Second}, {20, 3 * time.Second}, {-1, 2 * time.Second}}, []bool{true}},
+		{"成立后不重复", CE_RISING, []step{{20, 5 * time.Second}, {30, time.Second}, {5, 0}}, []bool{true}},
+		{"双边沿", CE_BOTH, []step{{20, 5 * time.Second}, {30, time.Second}, {5, 0}}, []bool{true, false}},
+		{"电平", CE_LEVEL, []step{{20, 5 * time.Second}, {30, time.Second}, {5, 0}}, []bool{true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := NewTickClock(testStart)
+			store := NewStorehouse(nil)
+			store.Set(id, 0)
+
+			var got []bool
+			opt := CondOpt{ListenOpt: ListenOpt{Clock: clk}, Edge: tt.edge, Hold: 5 * time.Second}
+			WorkStat.ListenCondOpt(store, cond, opt, func(store *Storehouse, state bool, ctx any) {
+				got = append(got, state)
+			}, nil)
+			for _, st := range tt.steps {
+				if st.set >= 0 {
+					store.Set(id, st.set)
+				}
+				clk.Advance(st.advance)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("%v，应为%v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 计时中的保持条件恢复后继续计时，已成立的恢复后不再回调
+func TestCondListenHoldSnapshot(t *testing.T) {
+	id := testName("测试条件监听", "保持快照")
+	cond, err := ParseCondExp("保持快照>10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		before  time.Duration
+		after   time.Duration
+		want    []bool
+		fired   bool
+		elapsed time.Duration
+	}{
+		{"计时中", 3 * time.Second, 2 * time.Second, []bool{true}, false, 3 * time.Second},
+		{"计时未到", 3 * time.Second, time.Second, nil, false, 3 * time.Second},
+		{"已成立", 7 * time.Second, 10 * time.Second, nil, true, 7 * time.Second},
+	}
+	for _, tt := range tests {
+		for _, listenFirst := range []bool{false, true} {
+			t.Run(tt.name, func(t *testing.T) {
+				opt := CondOpt{Edge: CE_BOTH, Hold: 5 * time.Second}
+
+				clk := NewTickClock(testStart)
+				opt.Clock = clk
+				store := NewStorehouse(nil)
+				store.Set(id, 20)
+				WorkStat.ListenCondOpt(store, cond, opt, func(store *Storehouse, state bool, ctx any) {}, nil)
+				clk.Advance(tt.before)
+				snap := store.Snapshot()
+				if want := []SnapshotHold{{Key: "(保持快照>10)", Elapsed: tt.elapsed}}; !reflect.DeepEqual(snap.Holds, want) {
+					t.Fatalf("快照：%v，应为%v", snap.Holds, want)
+				}
+
+				clk = NewTickClock(testStart)
+				opt.Clock = clk
+				other := NewStorehouse(nil)
+				var got []bool
+				listen := func() *CondListener {
+					return WorkStat.ListenCondOpt(other, cond, opt, func(store *Storehouse, state bool, ctx any) {
+						got = append(got, state)
+					}, nil)
+				}
+				var l *CondListener
+				if listenFirst {
+					l = listen()
+				}
+				if err := other.Restore(snap); err != nil {
+					t.Fatal(err)
+				}
+				if !listenFirst {
+					l = listen()
+				}
+				clk.Advance(tt.after)
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("%v，应为%v", got, tt.want)
+				}
+				if l.State() != (tt.fired || (len(tt.want) > 0)) {
+					t.Fatalf("恢复后状态：%v", l.State())
+				}
+
+				// 已成立的条件不成立时正常回调
+				if l.State() {
+					got = nil
+					other.Set(id, 5)
+					if want := []bool{false}; !reflect.DeepEqual(got, want) {
+						t.Fatalf("%v，应为%v", got, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+// 系统时钟的保持到时回调投递到仓库，由拥有者调用Pump执行
+func TestCondListenHoldPump(t *testing.T) {
+	id := testName("测试条件监听", "保持投递")
+	cond, err := ParseCondExp("保持投递>10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStorehouse(nil)
+	notify := make(chan bool, 1)
+	store.SetPumpNotify(func() {
+		select {
+		case notify <- true:
+		default:
+		}
+	})
+
+	var got []bool
+	opt := CondOpt{ListenOpt: ListenOpt{Clock: sysClock{}}, Edge: CE_RISING, Hold: 20 * time.Millisecond}
+	l := WorkStat.ListenCondOpt(store, cond, opt, func(store *Storehouse, state bool, ctx any) {
+		got = append(got, state)
+	}, nil)
+	store.Set(id, 20)
+
+	select {
+	case <-notify:
+	case <-time.After(5 * time.Second):
+		t.Fatal("没有收到定时回调通知")
+	}
+	if len(got) != 0 || l.State() {
+		t.Fatal("回调应在Pump中执行")
+	}
+	store.Pump()
+	if want := []bool{true}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("%v，应为%v", got, want)
+	}
+}
+
+func TestCondListenSubscribers(t *testing.T) {
+	id := testName("测试条件监听", "多订阅")
+	cond, err := ParseCondExp("多订阅>10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStorehouse(nil)
+
+	type feature struct {
+		name string
+	}
+	var got []string
+	fn := func(store *Storehouse, ctx any) {
+		got = append(got, ctx.(*feature).name)
+	}
+	first := WorkStat.ListenCond(store, cond, fn, &feature{"成就"})
+	second := WorkStat.ListenCond(store, cond, fn, &feature{"任务"})
+	if first == second {
+		t.Fatal("每次监听应返回不同的句柄")
+	}
+	if first.Cond() != cond {
+		t.Fatal("句柄的条件错误")
+	}
+
+	store.Set(id, 20)
+	if want := []string{"成就", "任务"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("%v，应为%v", got, want)
+	}
+
+	got = nil
+	first.Cancel()
+	first.Cancel()
+	store.Set(id, 30)
+	if want := []string{"任务"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("取消一个后：%v，应为%v", got, want)
+	}
+
+	// 其它仓库的监听互不影响
+	other := NewStorehouse(nil)
+	WorkStat.ListenCond(other, cond, fn, &feature{"其它仓库"})
+	got = nil
+	WorkStat.CancelCondListen(store, cond)
+	store.Set(id, 40)
+	other.Set(id, 40)
+	if want := []string{"其它仓库"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("全部取消后：%v，应为%v", got, want)
+	}
+	if len(store.workstatLog[WorkStat].listerOfCond[cond]) != 0 {
+		t.Fatal("取消后句柄应移除")
+	}
+}